@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"poshta/internal/app/config"
 	"poshta/internal/app/connections"
 	"poshta/internal/app/start"
@@ -11,6 +12,8 @@ import (
 	"poshta/internal/service"
 	"poshta/internal/usecase"
 	"poshta/pkg/logger"
+
+	"github.com/sirupsen/logrus"
 )
 
 func Run(configFiles ...string) {
@@ -25,6 +28,14 @@ func Run(configFiles ...string) {
 		logger.Error("Failed to load config", err, nil)
 		panic(err)
 	}
+	if err := cfg.Validate(); err != nil {
+		logger.Error("Invalid configuration", err, nil)
+		panic(err)
+	}
+
+	// фоновые задачи останавливаются вместе с приложением
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Инициализация соединений
 	conns, err := connections.NewConnections(cfg)
@@ -38,29 +49,146 @@ func Run(configFiles ...string) {
 	userRepo := repository.NewUserRepository(conns.DB)
 	chatRepo := repository.NewChatRepository(conns.DB)
 	messageRepo := repository.NewMessageRepository(conns.DB)
+	receiptRepo := repository.NewReceiptRepository(conns.DB)
+	refreshRepo := repository.NewRefreshTokenRepository(conns.DB)
+	sessionRepo := repository.NewSessionRepository(conns.DB)
+	totpRepo := repository.NewTOTPRepository(conns.DB)
+	wsTicketRepo := repository.NewWSTicketRepository(conns.DB)
+	accountTokenRepo := repository.NewAccountTokenRepository(conns.DB)
+	contactRepo := repository.NewContactRepository(conns.DB)
+	blockRepo := repository.NewBlockRepository(conns.DB)
+	publicKeyRepo := repository.NewPublicKeyRepository(conns.DB)
+	prekeyRepo := repository.NewPrekeyRepository(conns.DB)
+	verificationRepo := repository.NewKeyVerificationRepository(conns.DB)
+	keyLogRepo := repository.NewKeyLogRepository(conns.DB)
+	attachmentRepo := repository.NewAttachmentRepository(conns.DB)
+	auditRepo := repository.NewAuditRepository(conns.DB)
+
+	// счётчики неудачных входов общие для всех инстансов только в SQL
+	loginAttemptRepo := repository.NewMemoryLoginAttemptRepository()
+	if cfg.Login.AttemptStore == "sql" {
+		loginAttemptRepo = repository.NewLoginAttemptRepository(conns.DB)
+	}
+
+	hub := ws.NewHub()
+	go hub.Run()
+
+	// асимметричные ключи подписи читаются из каталога и ротируются по расписанию
+	tokenKeys := service.NewHMACKeys(cfg.JWT.SecretKey)
+	if cfg.JWT.Algorithm != service.AlgHS256 {
+		// ключ проверяет токены, пока жив самый долгий из подписанных им
+		keyStore, err := service.NewKeyStore(cfg.JWT.KeyDir, cfg.JWT.Algorithm, cfg.JWT.KeyRotationInterval, cfg.JWT.RefreshTokenTTL)
+		if err != nil {
+			logger.Error("Failed to load JWT signing keys", err, nil)
+			panic(err)
+		}
+		go runKeyRotation(ctx, keyStore, cfg.JWT.KeyRotationInterval)
+		tokenKeys = keyStore
+	}
+
+	keyLogKey, err := loadKeyLogKey(cfg.KeyLog.SigningKeyFile)
+	if err != nil {
+		logger.Error("Failed to load key log signing key", err, nil)
+		panic(err)
+	}
+
+	mail, err := newMailer(cfg.Mail)
+	if err != nil {
+		logger.Error("Failed to initialize mailer", err, nil)
+		panic(err)
+	}
+
+	blobs, err := newStorage(cfg.Attachments)
+	if err != nil {
+		logger.Error("Failed to initialize attachment storage", err, nil)
+		panic(err)
+	}
 
 	// init services
 
-	authService := service.NewAuthService(userRepo, service.JWTConfig{
-		SecretKey:       cfg.JWT.SecretKey,
-		AccessTokenTTL:  cfg.JWT.AccessTokenTTL,
-		RefreshTokenTTL: cfg.JWT.RefreshTokenTTL,
-		Issuer:          cfg.JWT.Issuer,
+	accountService := service.NewAccountService(userRepo, accountTokenRepo, sessionRepo, hub, mail, service.AccountConfig{
+		Keys:                 tokenKeys,
+		Issuer:               cfg.JWT.Issuer,
+		AppURL:               cfg.Account.AppURL,
+		VerificationTokenTTL: cfg.Account.VerificationTokenTTL,
+		ResetTokenTTL:        cfg.Account.ResetTokenTTL,
+	})
+	loginGuard := service.NewLoginGuard(loginAttemptRepo, auditRepo, service.LoginGuardConfig{
+		MaxFailuresPerUsername: cfg.Login.MaxFailuresPerUsername,
+		MaxFailuresPerIP:       cfg.Login.MaxFailuresPerIP,
+		BackoffAfter:           cfg.Login.BackoffAfter,
+		BackoffBase:            cfg.Login.BackoffBase,
+		BackoffMax:             cfg.Login.BackoffMax,
+		FailureWindow:          cfg.Login.FailureWindow,
+		LockoutDuration:        cfg.Login.LockoutDuration,
+	})
+	authService := service.NewAuthService(userRepo, refreshRepo, sessionRepo, totpRepo, wsTicketRepo, hub, accountService, loginGuard, service.JWTConfig{
+		Keys:                 tokenKeys,
+		SecretKey:            cfg.JWT.SecretKey,
+		AccessTokenTTL:       cfg.JWT.AccessTokenTTL,
+		RefreshTokenTTL:      cfg.JWT.RefreshTokenTTL,
+		Issuer:               cfg.JWT.Issuer,
+		WSTicketTTL:          cfg.JWT.WSTicketTTL,
+		MFATokenTTL:          cfg.MFA.TokenTTL,
+		TOTPIssuer:           cfg.MFA.TOTPIssuer,
+		RequireVerifiedEmail: cfg.Account.Restricts(config.RestrictLogin),
 	} )
-	chatService := usecase.NewChatService(chatRepo, userRepo)
-	messageService := usecase.NewMessageUseCase(messageRepo, chatRepo, userRepo)
+	policy := usecase.NewPolicy(chatRepo, messageRepo, userRepo, contactRepo, blockRepo, usecase.UnverifiedRestrictions{
+		CreateChat:  cfg.Account.Restricts(config.RestrictCreateChat),
+		SendMessage: cfg.Account.Restricts(config.RestrictSendMessage),
+	})
+	chatService := usecase.NewChatService(chatRepo, userRepo, verificationRepo, policy)
+	messageService := usecase.NewMessageUseCase(messageRepo, chatRepo, userRepo, receiptRepo, publicKeyRepo, hub, policy, usecase.MessageConfig{
+		EditWindow: cfg.Messages.EditWindow,
+	})
+	sessionService := service.NewSessionService(sessionRepo, hub)
+	keyService := usecase.NewKeyService(userRepo, publicKeyRepo, chatRepo, hub, policy)
+	verificationService := usecase.NewVerificationService(userRepo, verificationRepo, policy)
+	keyLogService := usecase.NewKeyLogService(keyLogRepo, keyLogKey)
+	prekeyService := usecase.NewPrekeyService(prekeyRepo, hub, policy, usecase.PrekeyConfig{
+		LowThreshold: cfg.Prekeys.LowThreshold,
+		MaxStock:     cfg.Prekeys.MaxStock,
+		MaxBatch:     cfg.Prekeys.MaxBatch,
+	})
+	attachmentService := usecase.NewAttachmentService(attachmentRepo, blobs, policy, usecase.AttachmentConfig{
+		MaxFileSize:  cfg.Attachments.MaxFileSize,
+		MaxImageSize: cfg.Attachments.MaxImageSize,
+		MaxVoiceSize: cfg.Attachments.MaxVoiceSize,
+		ChunkSize:    cfg.Attachments.ChunkSize,
+		OrphanTTL:    cfg.Attachments.OrphanTTL,
+	})
+	userService := usecase.NewUserService(userRepo, contactRepo, blockRepo, policy)
+	profileService := usecase.NewProfileService(userRepo, blockRepo, sessionRepo, hub, accountService, loginGuard, policy)
 
+	// ключи, сохранённые до появления лога, дописываются в него при старте
+	appended, err := keyLogService.AppendMissing(ctx)
+	if err != nil {
+		logger.Error("Failed to record existing public keys in the key log", err, nil)
+		panic(err)
+	}
+	if appended > 0 {
+		logger.Info("Recorded existing public keys in the key log", logrus.Fields{"count": appended})
+	}
 
-	hub := ws.NewHub()
-	go hub.Run()
+	// удаляем исчезающие сообщения в фоне
+	go runExpirySweeper(ctx, cfg.Messages.SweepInterval, messageService)
+	go runLoginAttemptPruner(ctx, loginGuard)
+	go runAttachmentSweeper(ctx, cfg.Attachments.SweepInterval, attachmentService)
 
 	// init handlers
 	authHandler := handlers.NewAuthHandler(authService)
 	chatHandler := handlers.NewChatHandler(chatService)
 	messageHandler := handlers.NewMessageHandler(messageService)
+	sessionHandler := handlers.NewSessionHandler(sessionService)
+	accountHandler := handlers.NewAccountHandler(accountService)
+	profileHandler := handlers.NewProfileHandler(profileService)
+	userHandler := handlers.NewUserHandler(userService)
+	keyHandler := handlers.NewKeyHandler(keyService, prekeyService, verificationService)
+	keyLogHandler := handlers.NewKeyLogHandler(keyLogService)
+	attachmentHandler := handlers.NewAttachmentHandler(attachmentService)
 	
 
-	wsHandler := handlers.NewWSHandler(hub, messageService, chatService)
+	wsHandler := handlers.NewWSHandler(hub, authService, messageService)
 
 	// init jwt middleware
 
@@ -68,5 +196,5 @@ func Run(configFiles ...string) {
 
 	// Запуск HTTP сервера
 	logger.Info("Starting HTTP server", nil)
-	start.HTTP(cfg, authHandler, chatHandler, messageHandler, wsHandler,  jwtMiddleware)
+	start.HTTP(cfg, authHandler, chatHandler, messageHandler, sessionHandler, accountHandler, profileHandler, userHandler, keyHandler, keyLogHandler, attachmentHandler, wsHandler,  jwtMiddleware)
 }
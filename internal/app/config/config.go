@@ -1,6 +1,9 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/caarlos0/env/v6"
 	"github.com/joho/godotenv"
 	"github.com/mcuadros/go-defaults"
@@ -9,9 +12,35 @@ import (
 )
 
 type Config struct {
-	HTTPServer HTTPServerConfig
-	DB         DBConfig
-	JWT 	   JWTConfig
+	HTTPServer  HTTPServerConfig
+	DB          DBConfig
+	JWT         JWTConfig
+	Messages    MessagesConfig
+	MFA         MFAConfig
+	Mail        MailConfig
+	Account     AccountConfig
+	Login       LoginConfig
+	Users       UsersConfig
+	Prekeys     PrekeysConfig
+	KeyLog      KeyLogConfig
+	Attachments AttachmentsConfig
+}
+
+// Validate checks every section and reports all problems at once, so a broken
+// deployment can be fixed in one go
+func (c *Config) Validate() error {
+	return errors.Join(
+		c.JWT.Validate(),
+		c.MFA.Validate(),
+		c.Messages.Validate(),
+		c.Mail.Validate(),
+		c.Account.Validate(),
+		c.Login.Validate(),
+		c.Users.Validate(),
+		c.Prekeys.Validate(),
+		c.KeyLog.Validate(),
+		c.Attachments.Validate(),
+	)
 }
 
 type HTTPServerConfig struct {
@@ -23,11 +52,247 @@ type DBConfig struct {
 	DSN string `env:"DATABASE_DSN"`
 }
 
+// DefaultJWTSecret is the placeholder secret; the server refuses to sign HS256 tokens with it
+const DefaultJWTSecret = "your_secret_key_here"
+
 type JWTConfig struct {
-	SecretKey       string        `env:"JWT_SECRET_KEY" default:"your_secret_key_here"`
-	AccessTokenTTL  time.Duration `env:"JWT_ACCESS_TOKEN_TTL" default:"15m"`
-	RefreshTokenTTL time.Duration `env:"JWT_REFRESH_TOKEN_TTL" default:"72h"`
-	Issuer          string        `env:"JWT_ISSUER" default:"poshta-app"`
+	Algorithm           string        `env:"JWT_ALGORITHM" default:"HS256"` // HS256, RS256 or EdDSA
+	SecretKey           string        `env:"JWT_SECRET_KEY" default:"your_secret_key_here"`
+	KeyDir              string        `env:"JWT_KEY_DIR" default:"keys"`
+	KeyRotationInterval time.Duration `env:"JWT_KEY_ROTATION_INTERVAL" default:"720h"`
+	AccessTokenTTL      time.Duration `env:"JWT_ACCESS_TOKEN_TTL" default:"15m"`
+	RefreshTokenTTL     time.Duration `env:"JWT_REFRESH_TOKEN_TTL" default:"72h"`
+	Issuer              string        `env:"JWT_ISSUER" default:"poshta-app"`
+	WSTicketTTL         time.Duration `env:"JWT_WS_TICKET_TTL" default:"30s"`
+}
+
+// Validate rejects configurations the server must not start with
+func (c JWTConfig) Validate() error {
+	switch c.Algorithm {
+	case "HS256":
+		if c.SecretKey == "" || c.SecretKey == DefaultJWTSecret {
+			return errors.New("JWT_SECRET_KEY must be set to a strong secret in HS256 mode")
+		}
+	case "RS256", "EdDSA":
+		if c.KeyRotationInterval <= 0 {
+			return errors.New("JWT_KEY_ROTATION_INTERVAL must be positive")
+		}
+	default:
+		return fmt.Errorf("unsupported JWT_ALGORITHM %q", c.Algorithm)
+	}
+	return nil
+}
+
+type MFAConfig struct {
+	TokenTTL   time.Duration `env:"MFA_TOKEN_TTL" default:"5m"`       // time to enter the second factor after the password
+	TOTPIssuer string        `env:"MFA_TOTP_ISSUER" default:"Poshta"` // shown in authenticator apps
+}
+
+// Validate rejects configurations the server must not start with
+func (c MFAConfig) Validate() error {
+	if c.TokenTTL <= 0 {
+		return errors.New("MFA_TOKEN_TTL must be positive")
+	}
+	return nil
+}
+
+type MailConfig struct {
+	Driver       string `env:"MAIL_DRIVER" default:"log"` // smtp, file or log
+	From         string `env:"MAIL_FROM" default:"Poshta <no-reply@localhost>"`
+	SMTPHost     string `env:"MAIL_SMTP_HOST"`
+	SMTPPort     int    `env:"MAIL_SMTP_PORT" default:"587"`
+	SMTPUsername string `env:"MAIL_SMTP_USERNAME"`
+	SMTPPassword string `env:"MAIL_SMTP_PASSWORD"`
+	FileDir      string `env:"MAIL_FILE_DIR" default:"mail"` // for the file driver
+}
+
+// Validate rejects configurations the server must not start with
+func (c MailConfig) Validate() error {
+	switch c.Driver {
+	case "smtp":
+		if c.SMTPHost == "" {
+			return errors.New("MAIL_SMTP_HOST must be set for the smtp mail driver")
+		}
+	case "file", "log":
+	default:
+		return fmt.Errorf("unsupported MAIL_DRIVER %q", c.Driver)
+	}
+	return nil
+}
+
+// Actions accounts with an unverified email address can be barred from
+const (
+	RestrictLogin       = "login"
+	RestrictCreateChat  = "create_chat"
+	RestrictSendMessage = "send_message"
+)
+
+type AccountConfig struct {
+	AppURL               string        `env:"ACCOUNT_APP_URL" default:"http://localhost:3000"` // links in emails point to the frontend
+	VerificationTokenTTL time.Duration `env:"ACCOUNT_VERIFICATION_TOKEN_TTL" default:"48h"`
+	ResetTokenTTL        time.Duration `env:"ACCOUNT_RESET_TOKEN_TTL" default:"1h"`
+	// comma-separated list of RestrictLogin, RestrictCreateChat, RestrictSendMessage
+	UnverifiedRestrictions []string `env:"ACCOUNT_UNVERIFIED_RESTRICTIONS" envSeparator:","`
+	// password reset and verification emails per client IP and per address and window
+	MailRateLimitPerIP    int           `env:"ACCOUNT_MAIL_RATE_LIMIT_PER_IP" default:"10"`
+	MailRateLimitPerEmail int           `env:"ACCOUNT_MAIL_RATE_LIMIT_PER_EMAIL" default:"3"`
+	MailRateWindow        time.Duration `env:"ACCOUNT_MAIL_RATE_WINDOW" default:"1h"`
+}
+
+// Validate rejects configurations the server must not start with
+func (c AccountConfig) Validate() error {
+	for _, action := range c.UnverifiedRestrictions {
+		switch action {
+		case RestrictLogin, RestrictCreateChat, RestrictSendMessage:
+		default:
+			return fmt.Errorf("unsupported ACCOUNT_UNVERIFIED_RESTRICTIONS entry %q", action)
+		}
+	}
+	if c.MailRateLimitPerIP <= 0 || c.MailRateLimitPerEmail <= 0 || c.MailRateWindow <= 0 {
+		return errors.New("ACCOUNT_MAIL_RATE_LIMIT_PER_IP, ACCOUNT_MAIL_RATE_LIMIT_PER_EMAIL and ACCOUNT_MAIL_RATE_WINDOW must be positive")
+	}
+	return nil
+}
+
+// Restricts reports whether unverified accounts are barred from the action
+func (c AccountConfig) Restricts(action string) bool {
+	for _, restricted := range c.UnverifiedRestrictions {
+		if restricted == action {
+			return true
+		}
+	}
+	return false
+}
+
+// LoginConfig sets the brute-force protection of logins
+type LoginConfig struct {
+	AttemptStore           string        `env:"LOGIN_ATTEMPT_STORE" default:"memory"` // memory (single instance) or sql
+	MaxFailuresPerUsername int           `env:"LOGIN_MAX_FAILURES_PER_USERNAME" default:"10"`
+	MaxFailuresPerIP       int           `env:"LOGIN_MAX_FAILURES_PER_IP" default:"100"`
+	BackoffAfter           int           `env:"LOGIN_BACKOFF_AFTER" default:"3"` // failures before the backoff starts
+	BackoffBase            time.Duration `env:"LOGIN_BACKOFF_BASE" default:"1s"`
+	BackoffMax             time.Duration `env:"LOGIN_BACKOFF_MAX" default:"1m"`
+	FailureWindow          time.Duration `env:"LOGIN_FAILURE_WINDOW" default:"15m"`
+	LockoutDuration        time.Duration `env:"LOGIN_LOCKOUT_DURATION" default:"15m"`
+}
+
+// Validate rejects configurations the server must not start with
+func (c LoginConfig) Validate() error {
+	if c.AttemptStore != "memory" && c.AttemptStore != "sql" {
+		return fmt.Errorf("unsupported LOGIN_ATTEMPT_STORE %q", c.AttemptStore)
+	}
+	if c.MaxFailuresPerUsername <= 0 || c.MaxFailuresPerIP <= 0 {
+		return errors.New("LOGIN_MAX_FAILURES_PER_USERNAME and LOGIN_MAX_FAILURES_PER_IP must be positive")
+	}
+	if c.BackoffAfter < 0 || c.BackoffBase < 0 || c.BackoffMax < c.BackoffBase {
+		return errors.New("LOGIN_BACKOFF_MAX must not be less than LOGIN_BACKOFF_BASE")
+	}
+	if c.FailureWindow <= 0 || c.LockoutDuration <= 0 {
+		return errors.New("LOGIN_FAILURE_WINDOW and LOGIN_LOCKOUT_DURATION must be positive")
+	}
+	return nil
+}
+
+type UsersConfig struct {
+	SearchRateLimit  int           `env:"USERS_SEARCH_RATE_LIMIT" default:"30"` // searches per user and window
+	SearchRateWindow time.Duration `env:"USERS_SEARCH_RATE_WINDOW" default:"1m"`
+}
+
+// Validate rejects configurations the server must not start with
+func (c UsersConfig) Validate() error {
+	if c.SearchRateLimit <= 0 || c.SearchRateWindow <= 0 {
+		return errors.New("USERS_SEARCH_RATE_LIMIT and USERS_SEARCH_RATE_WINDOW must be positive")
+	}
+	return nil
+}
+
+type PrekeysConfig struct {
+	LowThreshold     int           `env:"PREKEYS_LOW_THRESHOLD" default:"10"`
+	MaxStock         int           `env:"PREKEYS_MAX_STOCK" default:"500"`
+	MaxBatch         int           `env:"PREKEYS_MAX_BATCH" default:"100"`
+	BundleRateLimit  int           `env:"PREKEYS_BUNDLE_RATE_LIMIT" default:"60"` // bundles per user and window
+	BundleRateWindow time.Duration `env:"PREKEYS_BUNDLE_RATE_WINDOW" default:"1m"`
+}
+
+// Validate rejects configurations the server must not start with
+func (c PrekeysConfig) Validate() error {
+	if c.LowThreshold < 0 || c.MaxStock <= 0 || c.MaxBatch <= 0 {
+		return errors.New("PREKEYS_MAX_STOCK and PREKEYS_MAX_BATCH must be positive")
+	}
+	if c.BundleRateLimit <= 0 || c.BundleRateWindow <= 0 {
+		return errors.New("PREKEYS_BUNDLE_RATE_LIMIT and PREKEYS_BUNDLE_RATE_WINDOW must be positive")
+	}
+	return nil
+}
+
+type KeyLogConfig struct {
+	// Ed25519 key the key log's tree heads are signed with, created on first start.
+	// Every instance has to use the same key.
+	SigningKeyFile string `env:"KEYLOG_SIGNING_KEY_FILE" default:"keylog/signing_key.pem"`
+}
+
+// Validate rejects configurations the server must not start with
+func (c KeyLogConfig) Validate() error {
+	if c.SigningKeyFile == "" {
+		return errors.New("KEYLOG_SIGNING_KEY_FILE must be set")
+	}
+	return nil
+}
+
+type AttachmentsConfig struct {
+	Storage         string        `env:"ATTACHMENTS_STORAGE" default:"local"`   // local or s3
+	Dir             string        `env:"ATTACHMENTS_DIR" default:"attachments"` // for the local storage
+	S3Endpoint      string        `env:"ATTACHMENTS_S3_ENDPOINT"`
+	S3Region        string        `env:"ATTACHMENTS_S3_REGION" default:"us-east-1"`
+	S3Bucket        string        `env:"ATTACHMENTS_S3_BUCKET"`
+	S3AccessKey     string        `env:"ATTACHMENTS_S3_ACCESS_KEY"`
+	S3SecretKey     string        `env:"ATTACHMENTS_S3_SECRET_KEY"`
+	S3VirtualHosted bool          `env:"ATTACHMENTS_S3_VIRTUAL_HOSTED"`                 // bucket.host/key instead of host/bucket/key
+	MaxFileSize     int64         `env:"ATTACHMENTS_MAX_FILE_SIZE" default:"104857600"` // bytes
+	MaxImageSize    int64         `env:"ATTACHMENTS_MAX_IMAGE_SIZE" default:"20971520"`
+	MaxVoiceSize    int64         `env:"ATTACHMENTS_MAX_VOICE_SIZE" default:"20971520"`
+	ChunkSize       int64         `env:"ATTACHMENTS_CHUNK_SIZE" default:"5242880"` // largest chunk per request
+	OrphanTTL       time.Duration `env:"ATTACHMENTS_ORPHAN_TTL" default:"24h"`
+	SweepInterval   time.Duration `env:"ATTACHMENTS_SWEEP_INTERVAL" default:"1h"`
+}
+
+// Validate rejects configurations the server must not start with
+func (c AttachmentsConfig) Validate() error {
+	switch c.Storage {
+	case "local":
+		if c.Dir == "" {
+			return errors.New("ATTACHMENTS_DIR must be set for the local attachment storage")
+		}
+	case "s3":
+		if c.S3Endpoint == "" || c.S3Bucket == "" || c.S3AccessKey == "" || c.S3SecretKey == "" {
+			return errors.New("ATTACHMENTS_S3_ENDPOINT, ATTACHMENTS_S3_BUCKET, ATTACHMENTS_S3_ACCESS_KEY and ATTACHMENTS_S3_SECRET_KEY must be set for the s3 attachment storage")
+		}
+	default:
+		return fmt.Errorf("unsupported ATTACHMENTS_STORAGE %q", c.Storage)
+	}
+	if c.MaxFileSize <= 0 || c.MaxImageSize <= 0 || c.MaxVoiceSize <= 0 || c.ChunkSize <= 0 {
+		return errors.New("ATTACHMENTS_MAX_*_SIZE and ATTACHMENTS_CHUNK_SIZE must be positive")
+	}
+	if c.OrphanTTL <= 0 || c.SweepInterval <= 0 {
+		return errors.New("ATTACHMENTS_ORPHAN_TTL and ATTACHMENTS_SWEEP_INTERVAL must be positive")
+	}
+	return nil
+}
+
+type MessagesConfig struct {
+	SweepInterval time.Duration `env:"MESSAGES_SWEEP_INTERVAL" default:"1m"`
+	EditWindow    time.Duration `env:"MESSAGES_EDIT_WINDOW" default:"48h"` // how long after sending a message can be edited
+}
+
+// Validate rejects configurations the server must not start with
+func (c MessagesConfig) Validate() error {
+	if c.SweepInterval <= 0 {
+		return errors.New("MESSAGES_SWEEP_INTERVAL must be positive")
+	}
+	if c.EditWindow <= 0 {
+		return errors.New("MESSAGES_EDIT_WINDOW must be positive")
+	}
+	return nil
 }
 
 func NewConfig(filenames ...string) (*Config, error) {
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcuadros/go-defaults"
+)
+
+func TestValidateReportsEverySection(t *testing.T) {
+	cfg := &Config{}
+	defaults.SetDefaults(cfg)
+	cfg.JWT.SecretKey = "a-strong-test-secret"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate with defaults: %v", err)
+	}
+
+	cfg.JWT.SecretKey = DefaultJWTSecret
+	cfg.Messages.SweepInterval = 0
+	cfg.MFA.TokenTTL = 0
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted an invalid configuration")
+	}
+	for _, want := range []string{"JWT_SECRET_KEY", "MFA_TOKEN_TTL", "MESSAGES_SWEEP_INTERVAL"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate error %q doesn't mention %s", err, want)
+		}
+	}
+}
+
+func TestMFAConfigValidate(t *testing.T) {
+	tests := []struct {
+		ttl   time.Duration
+		valid bool
+	}{
+		{5 * time.Minute, true},
+		{0, false},
+		{-time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		err := MFAConfig{TokenTTL: tt.ttl, TOTPIssuer: "Poshta"}.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("Validate(MFA_TOKEN_TTL=%v) = %v, want valid %v", tt.ttl, err, tt.valid)
+		}
+	}
+}
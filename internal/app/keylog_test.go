@@ -0,0 +1,52 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLoadKeyLogKeyConcurrentCreate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys", "keylog.pem")
+
+	const instances = 8
+	keys := make([]ed25519.PrivateKey, instances)
+	errs := make([]error, instances)
+	var wg sync.WaitGroup
+	for i := 0; i < instances; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i], errs[i] = loadKeyLogKey(path)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range keys {
+		if errs[i] != nil {
+			t.Fatalf("instance %d: %v", i, errs[i])
+		}
+		if !keys[i].Equal(keys[0]) {
+			t.Fatalf("instance %d signs with a different key", i)
+		}
+	}
+
+	stored, err := loadKeyLogKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Equal(keys[0]) {
+		t.Error("the stored key differs from the one in use")
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("key directory holds %d files, want only the key", len(entries))
+	}
+}
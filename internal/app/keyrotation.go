@@ -0,0 +1,36 @@
+package app
+
+import (
+	"context"
+	"poshta/internal/service"
+	"poshta/pkg/logger"
+	"time"
+)
+
+// keyRotationCheck is how often the signing key directory is checked for rotation
+const keyRotationCheck = time.Hour
+
+// runKeyRotation periodically rotates the JWT signing keys
+func runKeyRotation(ctx context.Context, keys *service.KeyStore, interval time.Duration) {
+	if interval > keyRotationCheck {
+		interval = keyRotationCheck
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rotated, err := keys.Rotate()
+			if err != nil {
+				logger.Error("Failed to rotate JWT signing keys", err, nil)
+				continue
+			}
+			if rotated {
+				logger.Info("Rotated JWT signing key", nil)
+			}
+		}
+	}
+}
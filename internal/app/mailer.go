@@ -0,0 +1,24 @@
+package app
+
+import (
+	"poshta/internal/app/config"
+	"poshta/pkg/mailer"
+)
+
+// newMailer creates the mailer selected by MAIL_DRIVER
+func newMailer(cfg config.MailConfig) (mailer.Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		})
+	case "file":
+		return mailer.NewFileMailer(cfg.FileDir, cfg.From)
+	default:
+		return mailer.NewLogMailer(cfg.From)
+	}
+}
@@ -15,7 +15,7 @@ import (
 	_ "poshta/docs"
 )
 
-func HTTP(cfg *config.Config, authHandler *handlers.AuthHandler, chatHandler *handlers.ChatHandler, messageHandler *handlers.MessageHandler , wsHandler *handlers.WSHandler ,jwtMiddleware *middleware.JWTMiddleware) {
+func HTTP(cfg *config.Config, authHandler *handlers.AuthHandler, chatHandler *handlers.ChatHandler, messageHandler *handlers.MessageHandler, sessionHandler *handlers.SessionHandler, accountHandler *handlers.AccountHandler, profileHandler *handlers.ProfileHandler, userHandler *handlers.UserHandler, keyHandler *handlers.KeyHandler, keyLogHandler *handlers.KeyLogHandler, attachmentHandler *handlers.AttachmentHandler, wsHandler *handlers.WSHandler ,jwtMiddleware *middleware.JWTMiddleware) {
 	// Initialize mux router
 	router := mux.NewRouter()
 
@@ -33,24 +33,93 @@ func HTTP(cfg *config.Config, authHandler *handlers.AuthHandler, chatHandler *ha
 	router.HandleFunc("/api/auth/register", authHandler.Register).Methods("POST")
 	router.HandleFunc("/api/auth/login", authHandler.Login).Methods("POST")
 	router.HandleFunc("/api/auth/refresh", authHandler.RefreshToken).Methods("POST")
+	router.HandleFunc("/api/auth/login/mfa", authHandler.LoginMFA).Methods("POST")
+	router.HandleFunc("/api/auth/logout", authHandler.Logout).Methods("POST")
+	router.Handle("/api/auth/logout-all", jwtMiddleware.CreateAuthenticatedHandler(authHandler.LogoutAll)).Methods("POST")
+	router.Handle("/api/auth/totp/enroll", jwtMiddleware.CreateAuthenticatedHandler(authHandler.EnrollTOTP)).Methods("POST")
+	router.Handle("/api/auth/totp/activate", jwtMiddleware.CreateAuthenticatedHandler(authHandler.ActivateTOTP)).Methods("POST")
+	router.Handle("/api/auth/totp", jwtMiddleware.CreateAuthenticatedHandler(authHandler.DisableTOTP)).Methods("DELETE")
+	// письма ограничены и по IP, и по адресу, чтобы сервером нельзя было засыпать чужой ящик
+	mailIPLimiter := middleware.NewRateLimiter(cfg.Account.MailRateLimitPerIP, cfg.Account.MailRateWindow)
+	mailEmailLimiter := middleware.NewRateLimiter(cfg.Account.MailRateLimitPerEmail, cfg.Account.MailRateWindow)
+	limitMail := func(handler http.HandlerFunc) http.HandlerFunc {
+		return mailIPLimiter.LimitBy(middleware.IPRateKey, mailEmailLimiter.LimitBy(middleware.EmailRateKey, handler))
+	}
+	router.HandleFunc("/api/auth/forgot-password", limitMail(accountHandler.ForgotPassword)).Methods("POST")
+	router.HandleFunc("/api/auth/reset-password", accountHandler.ResetPassword).Methods("POST")
+	router.HandleFunc("/api/auth/verify-email", accountHandler.VerifyEmail).Methods("POST")
+	router.HandleFunc("/api/auth/verify-email/resend", limitMail(accountHandler.ResendVerification)).Methods("POST")
+
+	// Session routes
+	router.Handle("/api/sessions", jwtMiddleware.CreateAuthenticatedHandler(sessionHandler.GetSessions)).Methods("GET")
+	router.Handle("/api/sessions/{id}", jwtMiddleware.CreateAuthenticatedHandler(sessionHandler.RevokeSession)).Methods("DELETE")
+	router.HandleFunc("/.well-known/jwks.json", authHandler.JWKS).Methods("GET")
 	// get user's public key
-	router.HandleFunc("/api/{user_id}/public_key", authHandler.GetUserPublicKey).Methods("GET")
+	router.HandleFunc("/api/{user_id}/public_key", keyHandler.GetPublicKey).Methods("GET")
+	router.Handle("/api/keys", jwtMiddleware.CreateAuthenticatedHandler(keyHandler.RotatePublicKey)).Methods("PUT")
+	router.Handle("/api/keys/identity", jwtMiddleware.CreateAuthenticatedHandler(keyHandler.SetIdentityKey)).Methods("PUT")
+	router.Handle("/api/keys/signed-prekey", jwtMiddleware.CreateAuthenticatedHandler(keyHandler.SetSignedPrekey)).Methods("PUT")
+	router.Handle("/api/keys/one-time-prekeys", jwtMiddleware.CreateAuthenticatedHandler(keyHandler.AddOneTimePrekeys)).Methods("POST")
+	router.Handle("/api/keys/one-time-prekeys/count", jwtMiddleware.CreateAuthenticatedHandler(keyHandler.CountOneTimePrekeys)).Methods("GET")
+
+	// Key log routes, public for auditors like the public keys themselves
+	router.HandleFunc("/api/keylog/head", keyLogHandler.GetTreeHead).Methods("GET")
+	router.HandleFunc("/api/keylog/key", keyLogHandler.GetSigningKey).Methods("GET")
+	router.HandleFunc("/api/keylog/proof/{user_id}", keyLogHandler.GetInclusionProof).Methods("GET")
+	router.HandleFunc("/api/keylog/consistency", keyLogHandler.GetConsistencyProof).Methods("GET")
 
 	// Chat routes
 	router.Handle("/api/chats", jwtMiddleware.CreateAuthenticatedHandler(chatHandler.CreateChat)).Methods("POST")
 	router.Handle("/api/chats/{user_id}/chats", jwtMiddleware.CreateAuthenticatedHandler( chatHandler.GetUserChats)).Methods("GET")
 	router.Handle("/api/chats/{chat_id}/messages", jwtMiddleware.CreateAuthenticatedHandler(chatHandler.GetChatMessages)).Methods("GET")
 	router.Handle("/api/chats/{chat_id}/chats", jwtMiddleware.CreateAuthenticatedHandler(chatHandler.DeleteChat)).Methods("DELETE")
+	router.Handle("/api/chats/group", jwtMiddleware.CreateAuthenticatedHandler(chatHandler.CreateGroupChat)).Methods("POST")
+	router.Handle("/api/chats/{chat_id}/members", jwtMiddleware.CreateAuthenticatedHandler(chatHandler.GetChatMembers)).Methods("GET")
+	router.Handle("/api/chats/{chat_id}/members", jwtMiddleware.CreateAuthenticatedHandler(chatHandler.AddMember)).Methods("POST")
+	router.Handle("/api/chats/{chat_id}/members/{user_id}", jwtMiddleware.CreateAuthenticatedHandler(chatHandler.RemoveMember)).Methods("DELETE")
+	router.Handle("/api/chats/{chat_id}/members/{user_id}/role", jwtMiddleware.CreateAuthenticatedHandler(chatHandler.PromoteMember)).Methods("PUT")
+	router.Handle("/api/chats/{chat_id}/leave", jwtMiddleware.CreateAuthenticatedHandler(chatHandler.LeaveChat)).Methods("POST")
+	router.Handle("/api/chats/{chat_id}/settings", jwtMiddleware.CreateAuthenticatedHandler(chatHandler.UpdateSettings)).Methods("PUT")
 	
 	// Message routes
 	router.Handle("/api/message", jwtMiddleware.CreateAuthenticatedHandler(messageHandler.SendMessage)).Methods("POST")
 	router.Handle("/api/messages/{id}", jwtMiddleware.CreateAuthenticatedHandler(messageHandler.DeleteMessage)).Methods("DELETE")
+	router.Handle("/api/messages/{id}", jwtMiddleware.CreateAuthenticatedHandler(messageHandler.EditMessage)).Methods("PATCH")
+	router.Handle("/api/messages/{id}/revisions", jwtMiddleware.CreateAuthenticatedHandler(messageHandler.GetMessageHistory)).Methods("GET")
+	router.Handle("/api/chats/{chat_id}/read", jwtMiddleware.CreateAuthenticatedHandler(messageHandler.MarkRead)).Methods("POST")
+	router.Handle("/api/chats/{chat_id}/read", jwtMiddleware.CreateAuthenticatedHandler(messageHandler.GetReadPositions)).Methods("GET")
+
+	// Attachment routes
+	router.Handle("/api/chats/{chat_id}/attachments", jwtMiddleware.CreateAuthenticatedHandler(attachmentHandler.CreateUpload)).Methods("POST")
+	router.Handle("/api/attachments/{id}", jwtMiddleware.CreateAuthenticatedHandler(attachmentHandler.GetAttachment)).Methods("GET")
+	router.Handle("/api/attachments/{id}/content", jwtMiddleware.CreateAuthenticatedHandler(attachmentHandler.UploadChunk)).Methods("PUT")
+	router.Handle("/api/attachments/{id}/content", jwtMiddleware.CreateAuthenticatedHandler(attachmentHandler.DownloadAttachment)).Methods("GET")
+
 
+	// Profile routes
+	router.Handle("/api/profile", jwtMiddleware.CreateAuthenticatedHandler(profileHandler.GetProfile)).Methods("GET")
+	router.Handle("/api/profile", jwtMiddleware.CreateAuthenticatedHandler(profileHandler.UpdateProfile)).Methods("PATCH")
+	router.Handle("/api/profile/password", jwtMiddleware.CreateAuthenticatedHandler(profileHandler.ChangePassword)).Methods("POST")
+	// search is registered before /api/users/{id} so "search" isn't taken for an ID
+	searchLimiter := middleware.NewRateLimiter(cfg.Users.SearchRateLimit, cfg.Users.SearchRateWindow)
+	router.Handle("/api/users/search", jwtMiddleware.CreateAuthenticatedHandler(searchLimiter.Limit(userHandler.SearchUsers))).Methods("GET")
+	router.Handle("/api/users/{id}", jwtMiddleware.CreateAuthenticatedHandler(profileHandler.GetUser)).Methods("GET")
+	bundleLimiter := middleware.NewRateLimiter(cfg.Prekeys.BundleRateLimit, cfg.Prekeys.BundleRateWindow)
+	router.Handle("/api/users/{id}/prekey-bundle", jwtMiddleware.CreateAuthenticatedHandler(bundleLimiter.Limit(keyHandler.GetPrekeyBundle))).Methods("GET")
+	router.Handle("/api/users/{id}/safety-number", jwtMiddleware.CreateAuthenticatedHandler(keyHandler.GetSafetyNumber)).Methods("GET")
+	router.Handle("/api/users/{id}/verification", jwtMiddleware.CreateAuthenticatedHandler(keyHandler.VerifyKey)).Methods("PUT")
+	router.Handle("/api/users/{id}/verification", jwtMiddleware.CreateAuthenticatedHandler(keyHandler.UnverifyKey)).Methods("DELETE")
+	router.Handle("/api/users/{id}/block", jwtMiddleware.CreateAuthenticatedHandler(userHandler.BlockUser)).Methods("POST")
+	router.Handle("/api/users/{id}/block", jwtMiddleware.CreateAuthenticatedHandler(userHandler.UnblockUser)).Methods("DELETE")
 
-	// Protected route example
-	router.Handle("/api/profile", jwtMiddleware.CreateAuthenticatedHandler(authHandler.GetUserProfile)).Methods("GET")
+	// Contact routes
+	router.Handle("/api/contacts", jwtMiddleware.CreateAuthenticatedHandler(userHandler.GetContacts)).Methods("GET")
+	router.Handle("/api/contacts", jwtMiddleware.CreateAuthenticatedHandler(userHandler.AddContact)).Methods("POST")
+	router.Handle("/api/contacts/{user_id}", jwtMiddleware.CreateAuthenticatedHandler(userHandler.UpdateContact)).Methods("PUT")
+	router.Handle("/api/contacts/{user_id}", jwtMiddleware.CreateAuthenticatedHandler(userHandler.RemoveContact)).Methods("DELETE")
 
 	// websocket
+	router.Handle("/api/ws/ticket", jwtMiddleware.CreateAuthenticatedHandler(wsHandler.IssueTicket)).Methods("POST")
 	router.HandleFunc("/ws", wsHandler.ServeWS)
 	
 
@@ -63,8 +132,8 @@ func HTTP(cfg *config.Config, authHandler *handlers.AuthHandler, chatHandler *ha
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000"},
-		AllowedMethods:   []string{"GET", "POST","DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Idempotency-Key"},
 		AllowCredentials: true,
 	})
 
@@ -0,0 +1,23 @@
+package app
+
+import (
+	"poshta/internal/app/config"
+	"poshta/pkg/storage"
+)
+
+// newStorage creates the attachment storage selected by ATTACHMENTS_STORAGE
+func newStorage(cfg config.AttachmentsConfig) (storage.Storage, error) {
+	switch cfg.Storage {
+	case "s3":
+		return storage.NewS3Storage(storage.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: !cfg.S3VirtualHosted,
+		})
+	default:
+		return storage.NewLocalStorage(cfg.Dir)
+	}
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"poshta/internal/service"
+	"poshta/internal/usecase"
+	"poshta/pkg/logger"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runExpirySweeper periodically deletes disappearing messages whose time is up
+func runExpirySweeper(ctx context.Context, interval time.Duration, messageService usecase.MessageUseCase) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := messageService.DeleteExpiredMessages(ctx)
+			if err != nil {
+				logger.Error("Failed to delete expired messages", err, nil)
+				continue
+			}
+			if deleted > 0 {
+				logger.Info("Deleted expired messages", logrus.Fields{"count": deleted})
+			}
+		}
+	}
+}
+
+// runAttachmentSweeper periodically deletes attachments no message references
+func runAttachmentSweeper(ctx context.Context, interval time.Duration, attachmentService usecase.AttachmentService) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := attachmentService.DeleteOrphans(ctx)
+			if err != nil {
+				// удалённые до ошибки вложения учитываются в логе ошибки
+				logger.Error("Failed to delete orphaned attachments", err, logrus.Fields{"count": deleted})
+				continue
+			}
+			if deleted > 0 {
+				logger.Info("Deleted orphaned attachments", logrus.Fields{"count": deleted})
+			}
+		}
+	}
+}
+
+// loginAttemptPruneInterval is how often expired lockouts and old login failures are cleaned up
+const loginAttemptPruneInterval = time.Minute
+
+// runLoginAttemptPruner periodically unlocks expired lockouts and forgets old login failures
+func runLoginAttemptPruner(ctx context.Context, guard *service.LoginGuard) {
+	ticker := time.NewTicker(loginAttemptPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := guard.Prune(ctx); err != nil {
+				logger.Error("Failed to prune login attempts", err, nil)
+			}
+		}
+	}
+}
@@ -3,24 +3,36 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"poshta/internal/domain/models"
+	"poshta/internal/middleware"
 	"poshta/internal/usecase"
+	"poshta/pkg/logger"
 	"poshta/pkg/reqresp"
+	"time"
+
 	"github.com/gorilla/websocket"
 )
 
 type Client struct {
-	UserID string
-	Conn   *websocket.Conn
-	Hub    *Hub
-	Send   chan []byte
+	UserID    string
+	User      *models.User // authenticated user, acts in every frame the client sends
+	DeviceID  string
+	SessionID string // login the connection belongs to, see Hub.DisconnectSession
+	Conn      *websocket.Conn
+	Hub       *Hub
+	Send      chan []byte
+	ExpiresAt time.Time // connection is closed once the token it was opened with expires
 }
 
-func (c *Client) ReadPump(messageUseCase usecase.MessageUseCase, chatUseCase usecase.ChatService) {
+func (c *Client) ReadPump(messageUseCase usecase.MessageUseCase) {
 	defer func() {
 		c.Hub.Unregister <- c
 		c.Conn.Close()
 	}()
 
+	ctx := middleware.WithUser(context.Background(), c.User)
+
 	for {
 		_, msgBytes, err := c.Conn.ReadMessage()
 		if err != nil {
@@ -32,62 +44,230 @@ func (c *Client) ReadPump(messageUseCase usecase.MessageUseCase, chatUseCase use
 			continue
 		}
 
+		// отправитель всегда берется из аутентифицированного соединения
+		msg.SenderID = c.UserID
+		msgBytes, err = json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+
 		switch msg.Type {
 		case "message":
-			// сохраняем в БД
-			_, _ = messageUseCase.SendMessage(context.Background(), reqresp.SendMessageRequest{
-				ChatID:   msg.ChatID,
-				SenderID: msg.SenderID,
-				Content:  msg.Content,
-				EncryptedKey: msg.EncryptedKey,
+			// сохраняем в БД, участники получают сообщение через hub
+			saved, err := messageUseCase.SendMessage(ctx, reqresp.SendMessageRequest{
+				ChatID:             msg.ChatID,
+				Content:            msg.Content,
+				EncryptedKey:       msg.EncryptedKey,
+				EncryptedKeySender: msg.EncryptedKeySender,
+				Keys:               msg.Keys,
+				ClientMsgID:        msg.ClientMsgID,
+				TTL:                msg.TTL,
+				AttachmentIDs:      msg.AttachmentIDs,
 			})
-
-
-
-			// получаем чат и второго пользователя
-			chat, _ := chatUseCase.GetChatByID(context.Background(), msg.ChatID)
-			recipient := chat.User1ID
-			if recipient == msg.SenderID {
-				recipient = chat.User2ID
+			if err != nil {
+				c.replyError(msg, err)
+				continue
 			}
 
-			c.Hub.SendTo <- TargetedMessage{
-				RecipientIDs: []string{msg.SenderID, recipient},
-				Message:      msgBytes,
+			// подтверждаем отправителю ID и время, присвоенные сервером
+			createdAt := saved.CreatedAt
+			c.reply(reqresp.WSMessage{
+				Type:        "ack",
+				ID:          saved.ID,
+				ClientMsgID: msg.ClientMsgID,
+				ChatID:      saved.ChatID,
+				CreatedAt:   &createdAt,
+			})
+
+		case "edit":
+			// чат берётся из самого сообщения, chat_id кадра не нужен;
+			// участники получают новую версию через hub как "message_edited"
+			edited, err := messageUseCase.EditMessage(ctx, msg.MessageID, reqresp.EditMessageRequest{
+				Content:            msg.Content,
+				EncryptedKey:       msg.EncryptedKey,
+				EncryptedKeySender: msg.EncryptedKeySender,
+				Keys:               msg.Keys,
+			})
+			if err != nil {
+				c.replyError(msg, err)
+				continue
 			}
 
+			c.reply(reqresp.WSMessage{
+				Type:        "ack",
+				MessageID:   edited.ID,
+				ClientMsgID: msg.ClientMsgID,
+				ChatID:      edited.ChatID,
+				EditedAt:    edited.EditedAt,
+			})
 
 		case "typing":
-			chat, _ := chatUseCase.GetChatByID(context.Background(), msg.ChatID)
-			recipient := chat.User1ID
-			if recipient == msg.SenderID {
-				recipient = chat.User2ID
+			// typing и presence не пересылаются между заблокированными пользователями
+			others, err := messageUseCase.SignalRecipients(ctx, msg.ChatID)
+			if err != nil {
+				continue
 			}
 
 			// Пересылаем сообщение с типом "typing"
 			c.Hub.SendTo <- TargetedMessage{
-				RecipientIDs: []string{recipient}, // Только получателю
-				Message:      msgBytes,             // передаем оригинальное сообщение "typing"
+				RecipientIDs: others,   // Только остальным участникам
+				Message:      msgBytes, // передаем сообщение "typing"
 			}
-		
+
+		case "delivered":
+			_ = messageUseCase.MarkDelivered(ctx, msg.ChatID, msg.MessageID)
+
+		case "read":
+			_ = messageUseCase.MarkRead(ctx, msg.ChatID, msg.MessageID)
+
 		case "offline":
-			chat, _ := chatUseCase.GetChatByID(context.Background(), msg.ChatID)
-			recipient := chat.User1ID
-			if recipient == msg.SenderID {
-				recipient = chat.User2ID
+			others, err := messageUseCase.SignalRecipients(ctx, msg.ChatID)
+			if err != nil {
+				continue
 			}
+
 			c.Hub.SendTo <- TargetedMessage{
-				RecipientIDs: []string{recipient},
-				Message:      msgBytes,             
+				RecipientIDs: others,
+				Message:      msgBytes,
+			}
+		}
+	}
+}
+
+// reply sends a frame to this connection only
+func (c *Client) reply(frame reqresp.WSMessage) {
+	payload, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+	c.Hub.Reply <- ClientMessage{Client: c, Message: payload}
+}
+
+// replyError reports a failed "message" or "edit" frame back to the sender.
+// Errors for other frame types are not reported.
+func (c *Client) replyError(msg reqresp.WSMessage, err error) {
+	if msg.Type != "message" && msg.Type != "edit" {
+		return
+	}
+
+	code := errorCode(err)
+	if code == "internal" {
+		logger.Error("Failed to handle ws message", err, nil)
+	}
+
+	c.reply(reqresp.WSMessage{
+		Type:        "error",
+		ClientMsgID: msg.ClientMsgID,
+		ChatID:      msg.ChatID,
+		MessageID:   msg.MessageID,
+		Code:        code,
+		Error:       err.Error(),
+	})
+}
+
+// maxHeldFrames bounds the live frames held back while missed frames are replayed
+const maxHeldFrames = 1024
+
+// WritePump writes frames to the connection. If replay is not nil, the frames the
+// client missed while offline are written first, as soon as they arrive on it.
+func (c *Client) WritePump(replay <-chan []reqresp.WSMessage) {
+	expiry := time.NewTimer(time.Until(c.ExpiresAt))
+	defer func() {
+		expiry.Stop()
+		c.Conn.Close()
+	}()
+
+	if replay != nil {
+		held, ok := c.holdDuringReplay(replay, expiry.C)
+		if !ok {
+			return
+		}
+		for _, msg := range held {
+			if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case msg, ok := <-c.Send:
+			if !ok {
+				_ = c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
 			}
+			_ = c.Conn.WriteMessage(websocket.TextMessage, msg)
+
+		case <-expiry.C:
+			c.closeExpired()
+			return
 		}
+	}
+}
+
+// holdDuringReplay writes the replayed frames and returns the live frames that
+// arrived on Send meanwhile, so they neither overtake the replay nor overflow Send.
+// It reports false if the connection must be closed.
+func (c *Client) holdDuringReplay(replay <-chan []reqresp.WSMessage, expired <-chan time.Time) ([][]byte, bool) {
+	replayed := make(chan error, 1)
+	var held [][]byte
+	for {
+		select {
+		case frames := <-replay:
+			replay = nil
+			go func() {
+				for _, frame := range frames {
+					if err := c.Conn.WriteJSON(frame); err != nil {
+						replayed <- err
+						return
+					}
+				}
+				replayed <- nil
+			}()
+
+		case err := <-replayed:
+			return held, err == nil
+
+		case msg, ok := <-c.Send:
+			// клиент слишком отстал — пусть переподключится и досинхронизируется
+			if !ok || len(held) == maxHeldFrames {
+				return nil, false
+			}
+			held = append(held, msg)
 
-	
+		case <-expired:
+			c.closeExpired()
+			return nil, false
+		}
 	}
 }
 
-func (c *Client) WritePump() {
-	for msg := range c.Send {
-		_ = c.Conn.WriteMessage(websocket.TextMessage, msg)
+// closeExpired tells the client its token expired; it should reconnect with a new one
+func (c *Client) closeExpired() {
+	_ = c.Conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token expired"),
+		time.Now().Add(time.Second))
+}
+
+// errorCode maps usecase errors to the codes of "error" frames
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, usecase.ErrChatNotFound):
+		return "chat_not_found"
+	case errors.Is(err, usecase.ErrMessageNotFound):
+		return "message_not_found"
+	case errors.Is(err, usecase.ErrEditWindowClosed):
+		return "edit_window_closed"
+	case errors.Is(err, usecase.ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, usecase.ErrInvalidMessageKey), errors.Is(err, usecase.ErrInvalidClientMsgID),
+		errors.Is(err, usecase.ErrInvalidTTL), errors.Is(err, usecase.ErrTooManyAttachments):
+		return "invalid_request"
+	case errors.Is(err, usecase.ErrAttachmentUnavailable):
+		return "attachment_unavailable"
+	case errors.Is(err, usecase.ErrStaleKey):
+		return "stale_key"
+	default:
+		return "internal"
 	}
 }
@@ -1,10 +1,19 @@
 package ws
 
+import (
+	"encoding/json"
+	"poshta/pkg/reqresp"
+)
+
+// Hub keeps every live connection of every user. A user may be connected from
+// several devices at once; each connection is tracked separately.
 type Hub struct {
-	Clients    map[string]*Client
+	Clients    map[string]map[*Client]bool // user ID -> live connections
 	Register   chan *Client
 	Unregister chan *Client
 	SendTo     chan TargetedMessage
+	Reply      chan ClientMessage
+	Disconnect chan string // session ID whose connections must be closed
 }
 
 type TargetedMessage struct {
@@ -12,12 +21,20 @@ type TargetedMessage struct {
 	Message      []byte
 }
 
+// ClientMessage is delivered to a single connection only, e.g. an ack for the device that sent a message
+type ClientMessage struct {
+	Client  *Client
+	Message []byte
+}
+
 func NewHub() *Hub {
 	return &Hub{
-		Clients:    make(map[string]*Client),
+		Clients:    make(map[string]map[*Client]bool),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		SendTo:     make(chan TargetedMessage),
+		Reply:      make(chan ClientMessage),
+		Disconnect: make(chan string),
 	}
 }
 
@@ -25,20 +42,86 @@ func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.Register:
-			h.Clients[client.UserID] = client
+			conns, ok := h.Clients[client.UserID]
+			if !ok {
+				conns = make(map[*Client]bool)
+				h.Clients[client.UserID] = conns
+			}
+			// a device that reconnects replaces only its own stale connection
+			for existing := range conns {
+				if existing.DeviceID == client.DeviceID {
+					h.remove(existing)
+				}
+			}
+			conns[client] = true
 
 		case client := <-h.Unregister:
-			if _, ok := h.Clients[client.UserID]; ok {
-				delete(h.Clients, client.UserID)
-				close(client.Send)
-			}
+			h.remove(client)
 
 		case msg := <-h.SendTo:
 			for _, id := range msg.RecipientIDs {
-				if client, ok := h.Clients[id]; ok {
-					client.Send <- msg.Message
+				for client := range h.Clients[id] {
+					select {
+					case client.Send <- msg.Message:
+					default:
+						// клиент не успевает читать — отключаем только это устройство
+						h.remove(client)
+					}
+				}
+			}
+
+		case msg := <-h.Reply:
+			if !h.Clients[msg.Client.UserID][msg.Client] {
+				continue
+			}
+			select {
+			case msg.Client.Send <- msg.Message:
+			default:
+				h.remove(msg.Client)
+			}
+
+		case sessionID := <-h.Disconnect:
+			for _, conns := range h.Clients {
+				for client := range conns {
+					if client.SessionID == sessionID {
+						h.remove(client)
+					}
 				}
 			}
 		}
 	}
 }
+
+// remove drops a single connection, leaving the user's other devices untouched
+func (h *Hub) remove(client *Client) {
+	conns, ok := h.Clients[client.UserID]
+	if !ok || !conns[client] {
+		return
+	}
+
+	delete(conns, client)
+	close(client.Send)
+	if len(conns) == 0 {
+		delete(h.Clients, client.UserID)
+	}
+}
+
+// DisconnectSession implements service.SessionDisconnector
+func (h *Hub) DisconnectSession(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	h.Disconnect <- sessionID
+}
+
+// Notify implements usecase.Notifier
+func (h *Hub) Notify(userIDs []string, event reqresp.WSMessage) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	h.SendTo <- TargetedMessage{
+		RecipientIDs: userIDs,
+		Message:      payload,
+	}
+}
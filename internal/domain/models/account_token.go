@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Purposes of account tokens, also used as the "type" claim of the token
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+)
+
+// AccountToken records a token sent by email. It can be used once, before ExpiresAt.
+type AccountToken struct {
+	ID        string     `db:"id"`
+	UserID    string     `db:"user_id"`
+	Purpose   string     `db:"purpose"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
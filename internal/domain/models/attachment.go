@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Attachment kinds
+const (
+	AttachmentKindFile  = "file"
+	AttachmentKindImage = "image"
+	AttachmentKindVoice = "voice"
+)
+
+// Attachment is an encrypted file uploaded to a chat. It is complete once Received
+// reaches Size and can then be referenced by one message of its uploader.
+type Attachment struct {
+	ID          string     `json:"id" db:"id"`
+	ChatID      string     `json:"chat_id" db:"chat_id"`
+	UploaderID  string     `json:"uploader_id" db:"uploader_id"`
+	Kind        string     `json:"kind" db:"kind"`
+	Size        int64      `json:"size" db:"size"`         // размер шифротекста в байтах
+	Received    int64      `json:"received" db:"received"` // смещение, с которого продолжается загрузка
+	ChunkCount  int        `json:"-" db:"chunk_count"`
+	MessageID   *int64     `json:"message_id,omitempty" db:"message_id"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" db:"completed_at"` // nil while uploading
+}
+
+// AttachmentChunk is one uploaded piece of an attachment's ciphertext
+type AttachmentChunk struct {
+	AttachmentID string `db:"attachment_id"`
+	Index        int    `db:"chunk_index"`
+	StorageKey   string `db:"storage_key"`
+	Size         int64  `db:"size"`
+}
+
+// IDList is a list of IDs stored as a comma-separated column, e.g. from GROUP_CONCAT
+type IDList []string
+
+// Scan implements sql.Scanner
+func (l *IDList) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return fmt.Errorf("cannot scan %T into IDList", src)
+	}
+	if s == "" {
+		*l = nil
+		return nil
+	}
+	*l = strings.Split(s, ",")
+	return nil
+}
+
+// Value implements driver.Valuer
+func (l IDList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
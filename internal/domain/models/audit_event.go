@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Audit event types
+const (
+	AuditLoginLocked   = "login_locked"
+	AuditLoginUnlocked = "login_unlocked"
+)
+
+// AuditEvent records a security-relevant event. Subject names what it is
+// about, e.g. "username:alice" or "ip:203.0.113.7".
+type AuditEvent struct {
+	ID        int64     `json:"id" db:"id"`
+	Type      string    `json:"type" db:"event_type"`
+	Subject   string    `json:"subject" db:"subject"`
+	IP        string    `json:"ip" db:"ip"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
@@ -4,10 +4,33 @@ import (
 	"time"
 )
 
+const (
+	ChatTypeDirect = "direct"
+	ChatTypeGroup  = "group"
+)
+
+const (
+	ChatRoleOwner  = "owner"
+	ChatRoleAdmin  = "admin"
+	ChatRoleMember = "member"
+)
+
 type Chat struct {			
 	ID        string       			`json:"id" db:"id"`
-	User1ID   string     		 	`json:"user1_id" db:"user1_id"`
-	User2ID   string       			`json:"user2_id" db:"user2_id"`
+	Type      string       			`json:"type" db:"type"`
+	Title     string       			`json:"title,omitempty" db:"title"`
+	AvatarURL string       			`json:"avatar_url,omitempty" db:"avatar_url"`
+	User1ID   string     		 	`json:"user1_id,omitempty" db:"user1_id"` // only for direct chats
+	User2ID   string       			`json:"user2_id,omitempty" db:"user2_id"` // only for direct chats
+	MessageTTL int         			`json:"message_ttl" db:"message_ttl"` // disappearing messages timer in seconds, 0 = off
 	CreatedAt time.Time           	`json:"created_at" db:"created_at"`
 
-}
\ No newline at end of file
+}
+
+type ChatMember struct {
+	ChatID   string    `json:"chat_id" db:"chat_id"`
+	UserID   string    `json:"user_id" db:"user_id"`
+	Username string    `json:"username" db:"username"`
+	Role     string    `json:"role" db:"role"`
+	JoinedAt time.Time `json:"joined_at" db:"joined_at"`
+}
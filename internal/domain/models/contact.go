@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Discoverability settings: who may find a user by username
+const (
+	DiscoverableEveryone = "everyone"
+	DiscoverableContacts = "contacts" // only users the user added as a contact
+	DiscoverableNobody   = "nobody"
+)
+
+// Contact is an entry of a user's address book
+type Contact struct {
+	OwnerID     string    `json:"-" db:"owner_id"`
+	UserID      string    `json:"user_id" db:"contact_id"`
+	Username    string    `json:"username" db:"username"`
+	DisplayName string    `json:"display_name" db:"display_name"`
+	Nickname    string    `json:"nickname" db:"nickname"` // only visible to the owner
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+// KeyLogEntry is a leaf of the public key transparency log
+type KeyLogEntry struct {
+	LeafIndex  uint64 `json:"leaf_index" db:"leaf_index"`
+	UserID     string `json:"user_id" db:"user_id"`
+	KeyVersion int    `json:"key_version" db:"key_version"`
+	PublicKey  string `json:"public_key" db:"public_key"`
+	LeafHash   []byte `json:"leaf_hash" db:"leaf_hash"`
+	LoggedAt   int64  `json:"logged_at" db:"logged_at"` // unix seconds
+}
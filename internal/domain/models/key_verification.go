@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// KeyVerification records whether a user verified another user's public key
+type KeyVerification struct {
+	VerifierID   string     `json:"-" db:"verifier_id"`
+	UserID       string     `json:"user_id" db:"user_id"`
+	KeyVersion   int        `json:"key_version" db:"key_version"`           // version that was verified
+	VerifiedAt   *time.Time `json:"verified_at,omitempty" db:"verified_at"` // nil once the key changed
+	KeyChangedAt *time.Time `json:"key_changed_at,omitempty" db:"key_changed_at"`
+}
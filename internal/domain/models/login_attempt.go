@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// LoginAttempt counts the failed logins of a username or an IP address
+type LoginAttempt struct {
+	Key           string     `db:"attempt_key"`
+	Failures      int        `db:"failures"`
+	LastFailureAt time.Time  `db:"last_failure_at"`
+	LockedUntil   *time.Time `db:"locked_until"`
+}
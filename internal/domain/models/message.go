@@ -9,8 +9,58 @@ type Message struct {
 	SenderName string	  `json:"sender_name" db:"sender_name"`
 	Content   string      `json:"content" db:"content"`
 	CreatedAt time.Time   `json:"created_at" db:"created_at"`
-	ExpiredAt time.Time   `json:"expired_at" db:"expired_at"`
+	ExpiredAt *time.Time  `json:"expired_at" db:"expired_at"` // nil for messages that never expire
+	EditedAt  *time.Time  `json:"edited_at,omitempty" db:"edited_at"` // time of the last edit, nil if never edited
 	Readed 	  bool	      `json:"readed" db:"readed"`
+	EncryptedKey string    `json:"encrypted_key" db:"encrypted_key"` // key wrapped for the requesting user
+	KeyVersion   int       `json:"key_version,omitempty" db:"key_version"` // version of the requesting user's public key EncryptedKey was wrapped with
+	ClientMsgID  string    `json:"client_msg_id,omitempty" db:"client_msg_id"`
+	Withheld  bool         `json:"-" db:"withheld"` // sent to a user who blocked the sender, only the sender sees it
+	Keys      []MessageKey `json:"keys,omitempty" db:"-"`
+	AttachmentIDs IDList   `json:"attachment_ids,omitempty" db:"attachment_ids"`
+}
+
+// MessageKey is the message key wrapped for a single recipient or recipient device
+type MessageKey struct {
+	MessageID    int64  `json:"message_id" db:"message_id"`
+	RecipientID  string `json:"recipient_id" db:"recipient_id"`
+	DeviceID     string `json:"device_id,omitempty" db:"device_id"`
+	EncryptedKey string `json:"encrypted_key" db:"encrypted_key"`
+	KeyVersion   int    `json:"key_version,omitempty" db:"key_version"` // recipient's public key version, 0 for device keys
+}
+
+const (
+	MessageEventDeleted = "message_deleted"
+	MessageEventEdited  = "message_edited"
+)
+
+// MessageRevision is a replaced version of an edited message. EncryptedKey and
+// KeyVersion hold the key the revision was wrapped with for the requesting user.
+type MessageRevision struct {
+	ID           int64     `json:"id" db:"id"`
+	MessageID    int64     `json:"message_id" db:"message_id"`
+	Content      string    `json:"content" db:"content"`
 	EncryptedKey string    `json:"encrypted_key" db:"encrypted_key"`
+	KeyVersion   int       `json:"key_version,omitempty" db:"key_version"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`   // when this content was written
+	ReplacedAt   time.Time `json:"replaced_at" db:"replaced_at"` // when an edit replaced it
+}
+
+// MessageEvent records a change to an existing message so it can be replayed to offline clients
+type MessageEvent struct {
+	ID        int64     `json:"id" db:"id"`
+	ChatID    string    `json:"chat_id" db:"chat_id"`
+	MessageID int64     `json:"message_id" db:"message_id"`
+	Type      string    `json:"type" db:"type"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	VisibleTo string    `json:"-" db:"visible_to"` // the only user who may see the event, "" for every chat member
 }
 
+// MessageCursor selects a page of chat history. With Before set the page holds the
+// newest messages older than it, with After set the oldest messages newer than it,
+// and with neither the newest messages of the chat.
+type MessageCursor struct {
+	Before int64
+	After  int64
+	Limit  int
+}
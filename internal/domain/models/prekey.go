@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// IdentityKey is the long-term Ed25519 public key of a user used for X3DH
+type IdentityKey struct {
+	UserID      string    `json:"user_id" db:"user_id"`
+	IdentityKey string    `json:"identity_key" db:"identity_key"` // base64
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// SignedPrekey is an X25519 prekey signed with the user's identity key
+type SignedPrekey struct {
+	UserID    string    `json:"-" db:"user_id"`
+	KeyID     uint32    `json:"key_id" db:"key_id"`
+	PublicKey string    `json:"public_key" db:"public_key"` // base64
+	Signature string    `json:"signature" db:"signature"`   // base64 Ed25519 signature of the raw public key
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// OneTimePrekey is an X25519 prekey that is handed out once and then deleted
+type OneTimePrekey struct {
+	UserID    string    `json:"-" db:"user_id"`
+	KeyID     uint32    `json:"key_id" db:"key_id"`
+	PublicKey string    `json:"public_key" db:"public_key"` // base64
+	CreatedAt time.Time `json:"-" db:"created_at"`
+}
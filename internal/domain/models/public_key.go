@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// PublicKey is one version of a user's public key. Versions start at 1 and grow
+// with every rotation; all but the current one are revoked.
+type PublicKey struct {
+	UserID    string     `json:"user_id" db:"user_id"`
+	Version   int        `json:"version" db:"version"`
+	PublicKey string     `json:"public_key" db:"public_key"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"` // nil for the current key
+}
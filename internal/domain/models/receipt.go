@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// ReadPosition is how far a chat member has received and read the chat
+type ReadPosition struct {
+	ChatID                 string    `json:"chat_id" db:"chat_id"`
+	UserID                 string    `json:"user_id" db:"user_id"`
+	LastDeliveredMessageID int64     `json:"last_delivered_message_id" db:"last_delivered_message_id"`
+	LastReadMessageID      int64     `json:"last_read_message_id" db:"last_read_message_id"`
+	UpdatedAt              time.Time `json:"updated_at" db:"updated_at"`
+}
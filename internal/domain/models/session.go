@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session is a single login of a user on one device
+type Session struct {
+	ID         string     `json:"id" db:"id"`
+	UserID     string     `json:"-" db:"user_id"`
+	DeviceName string     `json:"device_name" db:"device_name"`
+	UserAgent  string     `json:"user_agent" db:"user_agent"`
+	IP         string     `json:"ip" db:"ip"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"-" db:"revoked_at"`
+	Current    bool       `json:"current" db:"-"` // the session of the request
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// TOTP is the second factor of a user. It is active once EnabledAt is set.
+type TOTP struct {
+	UserID       string     `db:"user_id"`
+	Secret       string     `db:"secret"`
+	CreatedAt    time.Time  `db:"created_at"`
+	EnabledAt    *time.Time `db:"enabled_at"`
+	LastUsedStep int64      `db:"last_used_step"`
+}
@@ -4,12 +4,16 @@ import "time"
 
 
 type User struct {
-	ID        string    `json:"id" db:"id"`
-	Username  string    `json:"username" db:"username"`
-	Email     string    `json:"email" db:"email"`
-	Password  string    `json:"-" db:"password"` // Password is not exposed in JSON responses
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
-	PublicKey string    `json:"public_key" db:"public_key"`
+	ID               string     `json:"id" db:"id"`
+	Username         string     `json:"username" db:"username"`
+	Email            string     `json:"email" db:"email"`
+	EmailVerifiedAt  *time.Time `json:"email_verified_at,omitempty" db:"email_verified_at"`
+	DisplayName      string     `json:"display_name" db:"display_name"`
+	Bio              string     `json:"bio" db:"bio"`
+	Discoverability  string     `json:"discoverability" db:"discoverability"`
+	Password         string     `json:"-" db:"password"` // Password is not exposed in JSON responses
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
+	PublicKey        string     `json:"public_key" db:"public_key"`
+	PublicKeyVersion int        `json:"public_key_version" db:"public_key_version"`
 }
-
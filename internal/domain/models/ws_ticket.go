@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// WSTicket is an issued, not yet used WebSocket ticket. ID is the jti of the ticket.
+type WSTicket struct {
+	ID        string    `db:"id"`
+	UserID    string    `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"poshta/internal/service"
+	"poshta/pkg/logger"
+	"poshta/pkg/reqresp"
+)
+
+// AccountHandler handles email verification and password reset
+type AccountHandler struct {
+	accountService service.AccountService
+}
+
+func NewAccountHandler(accountService service.AccountService) *AccountHandler {
+	return &AccountHandler{
+		accountService: accountService,
+	}
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Email a single-use password reset link. The response is the same whether or not the address is registered.
+// @Tags auth
+// @Accept json
+// @Param request body reqresp.EmailRequest true "Email address of the account"
+// @Success 202 {string} string "Accepted"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid request payload"
+// @Failure 429 {string} string "Too many requests for this IP address or email address"
+// @Failure 500 {object} reqresp.ErrorResponse "Internal server error"
+// @Router /auth/forgot-password [post]
+func (h *AccountHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := h.accountService.ForgotPassword(r.Context(), req.Email); err != nil {
+		logger.Error("Failed to start password reset", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword godoc
+// @Summary Reset password
+// @Description Set a new password with the token from the reset email. Ends every session of the account.
+// @Tags auth
+// @Accept json
+// @Param request body reqresp.ResetPasswordRequest true "Reset token and new password"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid or expired token, or password too short"
+// @Failure 500 {object} reqresp.ErrorResponse "Internal server error"
+// @Router /auth/reset-password [post]
+func (h *AccountHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := h.accountService.ResetPassword(r.Context(), req.Token, req.Password); err != nil {
+		respondWithStatusError(w, accountErrorStatus(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyEmail godoc
+// @Summary Verify email address
+// @Description Confirm the email address with the token from the verification email
+// @Tags auth
+// @Accept json
+// @Param request body reqresp.VerifyEmailRequest true "Verification token"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid or expired token"
+// @Failure 500 {object} reqresp.ErrorResponse "Internal server error"
+// @Router /auth/verify-email [post]
+func (h *AccountHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := h.accountService.VerifyEmail(r.Context(), req.Token); err != nil {
+		respondWithStatusError(w, accountErrorStatus(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerification godoc
+// @Summary Resend the verification email
+// @Description Email a new verification link; earlier links stop working. The response is the same whether or not the address is registered.
+// @Tags auth
+// @Accept json
+// @Param request body reqresp.EmailRequest true "Email address of the account"
+// @Success 202 {string} string "Accepted"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid request payload"
+// @Failure 429 {string} string "Too many requests for this IP address or email address"
+// @Failure 500 {object} reqresp.ErrorResponse "Internal server error"
+// @Router /auth/verify-email/resend [post]
+func (h *AccountHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := h.accountService.ResendVerification(r.Context(), req.Email); err != nil {
+		logger.Error("Failed to resend verification email", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// accountErrorStatus maps email verification and password reset errors to HTTP status codes
+func accountErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidToken), errors.Is(err, service.ErrInvalidPassword):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"poshta/internal/usecase"
+	"poshta/pkg/logger"
+	"poshta/pkg/reqresp"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// AttachmentHandler handles uploads and downloads of encrypted attachments
+type AttachmentHandler struct {
+	attachmentService usecase.AttachmentService
+}
+
+func NewAttachmentHandler(attachmentService usecase.AttachmentService) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentService: attachmentService,
+	}
+}
+
+// CreateUpload godoc
+// @Summary Start an attachment upload
+// @Description Start uploading an encrypted file, image or voice note to a chat. The ciphertext is then sent in chunks to /attachments/{id}/content.
+// @Tags attachments
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param chat_id path string true "Chat ID"
+// @Param request body reqresp.CreateAttachmentRequest true "Kind and ciphertext size"
+// @Success 201 {object} models.Attachment "Upload started"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid kind or size"
+// @Failure 403 {object} reqresp.ErrorResponse "Not a chat member"
+// @Failure 404 {object} reqresp.ErrorResponse "Chat not found"
+// @Failure 413 {object} reqresp.ErrorResponse "Larger than allowed for the kind"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /chats/{chat_id}/attachments [post]
+func (h *AttachmentHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.CreateAttachmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	attachment, err := h.attachmentService.CreateUpload(r.Context(), mux.Vars(r)["chat_id"], req)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, attachment)
+}
+
+// UploadChunk godoc
+// @Summary Upload a chunk
+// @Description Append the next chunk of ciphertext, sent as the raw request body. offset must equal the received size of the upload; to resume an interrupted upload get the attachment and continue from received.
+// @Tags attachments
+// @Accept octet-stream
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "Attachment ID"
+// @Param offset query int true "Byte offset of the chunk"
+// @Success 200 {object} models.Attachment "Upload state, completed_at is set after the last chunk"
+// @Failure 400 {object} reqresp.ErrorResponse "Empty or too large chunk"
+// @Failure 404 {object} reqresp.ErrorResponse "Upload not found"
+// @Failure 409 {object} reqresp.ErrorResponse "Offset does not match the upload"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /attachments/{id}/content [put]
+func (h *AttachmentHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "offset must be a non-negative number")
+		return
+	}
+	defer r.Body.Close()
+
+	attachment, err := h.attachmentService.UploadChunk(r.Context(), mux.Vars(r)["id"], offset, r.Body)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, attachment)
+}
+
+// GetAttachment godoc
+// @Summary Get an attachment
+// @Description Get the size and upload state of an attachment of a chat the user is a member of
+// @Tags attachments
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "Attachment ID"
+// @Success 200 {object} models.Attachment "Attachment"
+// @Failure 404 {object} reqresp.ErrorResponse "Attachment not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /attachments/{id} [get]
+func (h *AttachmentHandler) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, err := h.attachmentService.GetAttachment(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, attachment)
+}
+
+// DownloadAttachment godoc
+// @Summary Download an attachment
+// @Description Download the ciphertext of a complete attachment of a chat the user is a member of
+// @Tags attachments
+// @Produce octet-stream
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "Attachment ID"
+// @Success 200 {file} binary "Ciphertext"
+// @Failure 404 {object} reqresp.ErrorResponse "Attachment not found"
+// @Failure 409 {object} reqresp.ErrorResponse "Upload not complete"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /attachments/{id}/content [get]
+func (h *AttachmentHandler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, content, err := h.attachmentService.OpenAttachment(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	// статус уже отправлен, при ошибке остаётся только оборвать ответ
+	if _, err := io.Copy(w, content); err != nil {
+		logger.Error("Failed to stream attachment", err, nil)
+	}
+}
@@ -2,15 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"math"
 	"net/http"
-	"poshta/internal/domain/models"
-	"poshta/internal/middleware"
 	"poshta/internal/service"
+	"poshta/pkg/httputil"
 	"poshta/pkg/logger"
 	"poshta/pkg/reqresp"
-
-	"github.com/gorilla/mux"
+	"strconv"
 )
 
 // AuthHandler handles auth-related HTTP requests
@@ -82,6 +81,8 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} reqresp.AuthResponse "Authentication successful"
 // @Failure 400 {object} map[string]string "Invalid request body"
 // @Failure 401 {object} map[string]string "Invalid credentials"
+// @Failure 403 {object} map[string]string "Email address is not verified"
+// @Failure 429 {object} map[string]string "Too many failed attempts, see Retry-After"
 // @Failure 500 {object} map[string]string "Internal server error"
 // @Router /auth/login [post]
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -97,11 +98,19 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	req.UserAgent = r.UserAgent()
+	req.IP = httputil.ClientIP(r)
+
 	authResp, err := h.authService.Login(r.Context(), req)
 	if err != nil {
+		if respondThrottled(w, err) {
+			return
+		}
 		switch err {
 		case service.ErrInvalidCredentials:
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		case service.ErrEmailNotVerified:
+			http.Error(w, "Email address is not verified", http.StatusForbidden)
 		default:
 			logger.Error("Login failed", err, nil)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -114,6 +123,173 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(authResp)
 }
 
+// LoginMFA godoc
+// @Summary Complete login with a second factor
+// @Description Exchange the mfa_token returned by /auth/login and a TOTP code or a recovery code for JWT tokens
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body reqresp.MFALoginRequest true "MFA token and code"
+// @Success 200 {object} reqresp.AuthResponse "Authentication successful"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 401 {object} map[string]string "Invalid code or expired mfa_token"
+// @Failure 429 {object} map[string]string "Too many failed attempts, see Retry-After"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /auth/login/mfa [post]
+func (h *AuthHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Error("Failed to decode request body", err, nil)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.UserAgent = r.UserAgent()
+	req.IP = httputil.ClientIP(r)
+
+	authResp, err := h.authService.LoginMFA(r.Context(), req)
+	if err != nil {
+		if respondThrottled(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, service.ErrInvalidOTP):
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+		case errors.Is(err, service.ErrInvalidToken), errors.Is(err, service.ErrTOTPNotEnrolled):
+			http.Error(w, "Invalid or expired mfa_token", http.StatusUnauthorized)
+		default:
+			logger.Error("MFA login failed", err, nil)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(authResp)
+}
+
+// EnrollTOTP godoc
+// @Summary Enroll TOTP
+// @Description Start two-factor authentication enrollment. Returns the secret and an otpauth URI for authenticator apps.
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} reqresp.TOTPEnrollResponse "Enrollment started"
+// @Failure 409 {object} reqresp.ErrorResponse "Already enabled"
+// @Failure 500 {object} reqresp.ErrorResponse "Internal server error"
+// @Router /auth/totp/enroll [post]
+func (h *AuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	enrollment, err := h.authService.EnrollTOTP(r.Context(), user)
+	if err != nil {
+		respondWithStatusError(w, totpErrorStatus(err), err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, enrollment)
+}
+
+// ActivateTOTP godoc
+// @Summary Activate TOTP
+// @Description Verify the first code from the authenticator app to enable two-factor authentication. Returns single-use recovery codes, shown only once.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param request body reqresp.TOTPCodeRequest true "First TOTP code"
+// @Success 200 {object} reqresp.RecoveryCodesResponse "Two-factor authentication enabled"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid code or not enrolled"
+// @Failure 409 {object} reqresp.ErrorResponse "Already enabled"
+// @Failure 500 {object} reqresp.ErrorResponse "Internal server error"
+// @Router /auth/totp/activate [post]
+func (h *AuthHandler) ActivateTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req reqresp.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	codes, err := h.authService.ActivateTOTP(r.Context(), user.ID, req.Code)
+	if err != nil {
+		respondWithStatusError(w, totpErrorStatus(err), err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, codes)
+}
+
+// DisableTOTP godoc
+// @Summary Disable TOTP
+// @Description Turn two-factor authentication off, confirmed with a current code or a recovery code
+// @Tags auth
+// @Accept json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param request body reqresp.TOTPCodeRequest true "TOTP code or recovery code"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid code or not enabled"
+// @Failure 500 {object} reqresp.ErrorResponse "Internal server error"
+// @Router /auth/totp [delete]
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req reqresp.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := h.authService.DisableTOTP(r.Context(), user.ID, req); err != nil {
+		respondWithStatusError(w, totpErrorStatus(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondThrottled answers 429 with a Retry-After header if the login is throttled
+func respondThrottled(w http.ResponseWriter, err error) bool {
+	var throttled *service.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+
+	seconds := int64(math.Ceil(throttled.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+	return true
+}
+
+// totpErrorStatus maps two-factor authentication errors to HTTP status codes
+func totpErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidOTP), errors.Is(err, service.ErrTOTPNotEnrolled):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrTOTPAlreadyEnabled):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // RefreshToken handles token refresh
 
 
@@ -147,9 +323,13 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authResp, err := h.authService.RefreshToken(req.RefreshToken)
+	authResp, err := h.authService.RefreshToken(r.Context(), req.RefreshToken)
 	if err != nil {
 		logger.Error("Token refresh failed", err, nil)
+		if errors.Is(err, service.ErrInternal) {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
 		return
 	}
@@ -159,88 +339,73 @@ func (h *AuthHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(authResp)
 }
 
-
-// GetUser godoc
-// @Summary Get user information
-// @Description Example of protected route with JWT middleware
-// @Tags user
-// @Accept json
-// @Produce json
-// @Security ApiKeyAuth
-// @Param Authorization header string true "Bearer token"
-// @Success 200 {object} map[string]interface{} "User profile information"
-// @Failure 500 {object} map[string]string "Service unavailable"
-// @Router /profile [get]
-func (h *AuthHandler) GetUserProfile(w http.ResponseWriter, r *http.Request) {
-    if r.Method != http.MethodGet {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-        return
-    }
-    
-    // Get user from context
-    user, ok := r.Context().Value(middleware.UserContextKey).(*models.User)
-    if !ok {
-        http.Error(w, "User not found in context", http.StatusInternalServerError)
-        return
-    }
-    
-    // Create response with user data
-    response := map[string]interface{}{
-        "message": "Protected resource accessed",
-        "user": map[string]interface{}{
-            "id":       user.ID,
-            "username": user.Username,
-            "email":    user.Email,
-
-        },
-    }
-    
-    jsonResponse, err := json.Marshal(response)
-    if err != nil {
-        http.Error(w, "Error creating response", http.StatusInternalServerError)
-        return
-    }
-    
-    w.Header().Set("Content-Type", "application/json")
-    w.WriteHeader(http.StatusOK)
-    w.Write(jsonResponse)
-}
-
-// GetUser godoc
-// @Summary Get user public key
-// @Description Get user public key 
-// @Tags user
+// Logout godoc
+// @Summary Log out
+// @Description Revoke the refresh token and every token rotated from the same login
+// @Tags auth
 // @Accept json
-// @Produce json
-// @Param user_id path int true "User ID"
-// @Success 200 {object} map[string]string "User public key retrieved successfully"
-// @Failure 400 {object} map[string]string "Invalid user ID"
-// @Failure 500 {object} map[string]string "Service unavailable"
-// @Router /{user_id}/public_key [get]
-func (h *AuthHandler) GetUserPublicKey(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// @Param request body reqresp.RefreshTokenRequest true "Refresh token"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {object} map[string]string "Invalid request body"
+// @Failure 401 {object} map[string]string "Invalid or expired token"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /auth/logout [post]
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Error("Failed to decode request body", err, nil)
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	vars := mux.Vars(r)
-	userID := vars["user_id"]
-	if userID == "" {
-		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+	if err := h.authService.Logout(r.Context(), req.RefreshToken); err != nil {
+		if errors.Is(err, service.ErrInternal) {
+			logger.Error("Logout failed", err, nil)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
 		return
 	}
 
-	publicKey, err := h.authService.GetUserPublicKey(userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll godoc
+// @Summary Log out everywhere
+// @Description Revoke every refresh token of the current user
+// @Tags auth
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 500 {object} map[string]string "Internal server error"
+// @Router /auth/logout-all [post]
+func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
 	if err != nil {
-		logger.Error("Failed to get user's public key", err, nil)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.authService.LogoutAll(r.Context(), user.ID); err != nil {
+		logger.Error("Logout from all devices failed", err, nil)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"user_id":    fmt.Sprintf("%s", userID),
-		"public_key": publicKey,
-	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+
+// JWKS godoc
+// @Summary JSON Web Key Set
+// @Description Public keys access tokens are signed with, identified by kid. Empty in HS256 mode.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} reqresp.JWKS "Key set"
+// @Router /.well-known/jwks.json [get]
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, h.authService.JWKS())
 }
@@ -2,9 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"poshta/internal/domain/models"
 	"poshta/internal/usecase"
+	"poshta/pkg/logger"
 	"poshta/pkg/reqresp"
+	"strconv"
+
 	"github.com/gorilla/mux"
 )
 
@@ -25,9 +30,11 @@ func NewChatHandler(chatService usecase.ChatService) *ChatHandler {
 // @Produce json
 // @Security ApiKeyAuth
 // @Param Authorization header string true "Bearer token"
-// @Param request body reqresp.CreateChatRequest true "Create chat request"
+// @Param request body reqresp.CreateChatRequest true "Create chat request; the other user by user2_id or username"
 // @Success 201 {object} models.Chat "Chat created successfully"
 // @Failure 400 {object} reqresp.ErrorResponse "Invalid request"
+// @Failure 403 {object} reqresp.ErrorResponse "Forbidden"
+// @Failure 404 {object} reqresp.ErrorResponse "User not found"
 // @Failure 500 {object} reqresp.ErrorResponse "Server error"
 // @Router /chats [post]
 func (h *ChatHandler) CreateChat(w http.ResponseWriter, r *http.Request) {
@@ -40,7 +47,7 @@ func (h *ChatHandler) CreateChat(w http.ResponseWriter, r *http.Request) {
 
 	chat, err := h.chatService.CreateChat(r.Context(), req)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithChatError(w, err)
 		return
 	}
 
@@ -58,6 +65,8 @@ func (h *ChatHandler) CreateChat(w http.ResponseWriter, r *http.Request) {
 // @Param chat_id path string true "Chat ID"
 // @Success 200 {array} string "Chats deleted successfully"
 // @Failure 400 {object} reqresp.ErrorResponse "Invalid chat ID"
+// @Failure 403 {object} reqresp.ErrorResponse "Forbidden"
+// @Failure 404 {object} reqresp.ErrorResponse "Chat not found"
 // @Failure 500 {object} reqresp.ErrorResponse "Server error"
 // @Router /chats/{chat_id}/chats [delete]
 func (h* ChatHandler) DeleteChat(w http.ResponseWriter, r *http.Request){
@@ -72,7 +81,7 @@ func (h* ChatHandler) DeleteChat(w http.ResponseWriter, r *http.Request){
 	_, err := h.chatService.DeleteChat(r.Context(), chatID)
 
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithChatError(w, err)
 		return
 	}
 
@@ -90,6 +99,7 @@ func (h* ChatHandler) DeleteChat(w http.ResponseWriter, r *http.Request){
 // @Param user_id path string true "User ID"
 // @Success 200 {array} models.Chat "Chats retrieved successfully"
 // @Failure 400 {object} reqresp.ErrorResponse "Invalid user ID"
+// @Failure 403 {object} reqresp.ErrorResponse "Forbidden"
 // @Failure 500 {object} reqresp.ErrorResponse "Server error"
 // @Router /chats/{user_id}/chats [get]
 func (h *ChatHandler) GetUserChats(w http.ResponseWriter, r *http.Request) {
@@ -103,7 +113,7 @@ func (h *ChatHandler) GetUserChats(w http.ResponseWriter, r *http.Request) {
 
 	chats, err := h.chatService.GetUserChats(r.Context(), userID) // pass string now
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithChatError(w, err)
 		return
 	}
 
@@ -119,8 +129,13 @@ func (h *ChatHandler) GetUserChats(w http.ResponseWriter, r *http.Request) {
 // @Security ApiKeyAuth
 // @Param Authorization header string true "Bearer token"
 // @Param chat_id path string true "Chat ID"
-// @Success 200 {array} models.Message "Messages retrieved successfully"
+// @Param device_id query string false "Device ID whose wrapped message keys should be returned"
+// @Param before query int false "Return messages older than this message ID"
+// @Param after query int false "Return messages newer than this message ID"
+// @Param limit query int false "Page size (default 50, max 200)"
+// @Success 200 {object} reqresp.Chat "Messages retrieved successfully"
 // @Failure 400 {object} reqresp.ErrorResponse "Invalid chat ID"
+// @Failure 403 {object} reqresp.ErrorResponse "Forbidden"
 // @Failure 404 {object} reqresp.ErrorResponse "Chat not found"
 // @Failure 500 {object} reqresp.ErrorResponse "Server error"
 // @Router /chats/{chat_id}/messages [get]
@@ -128,38 +143,286 @@ func (h *ChatHandler) GetChatMessages(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	chatID := vars["chat_id"] // no ParseInt anymore
 
-	 // Получаем юзера из контекста
-	 user, err := GetUserFromContext(r.Context())
-	 if err != nil {
-		 http.Error(w, "unauthorized", http.StatusUnauthorized)
-		 return
-	 }
-
 	if chatID == "" {
 		respondWithError(w, http.StatusBadRequest, "Invalid chat ID")
 		return
 	}
 
-	// First check if chat exists
-	chat, err := h.chatService.GetChatByID(r.Context(), chatID) // pass string now
+	query := r.URL.Query()
+	deviceID := query.Get("device_id")
+
+	var cursor models.MessageCursor
+	for name, dst := range map[string]*int64{"before": &cursor.Before, "after": &cursor.After} {
+		if v := query.Get(name); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id <= 0 {
+				respondWithError(w, http.StatusBadRequest, "Invalid "+name+" cursor")
+				return
+			}
+			*dst = id
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		cursor.Limit = limit
+	}
+
+	messages, err := h.chatService.GetChatMessages(r.Context(), chatID, deviceID, cursor)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithChatError(w, err)
 		return
 	}
-	if chat == nil {
-		respondWithError(w, http.StatusNotFound, "Chat not found")
+
+	respondWithJSON(w, http.StatusOK, messages)
+}
+
+
+// @Summary Create a group chat
+// @Description Create a group chat owned by the current user
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param request body reqresp.CreateGroupChatRequest true "Create group chat request"
+// @Success 201 {object} models.Chat "Chat created successfully"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid request"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /chats/group [post]
+func (h *ChatHandler) CreateGroupChat(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.CreateGroupChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Title == "" {
+		respondWithError(w, http.StatusBadRequest, "Title is required")
 		return
 	}
 
-	messages, err := h.chatService.GetChatMessages(r.Context(), chatID, user.ID) // pass string
+	chat, err := h.chatService.CreateGroupChat(r.Context(), req)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithChatError(w, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, messages)
+	respondWithJSON(w, http.StatusCreated, chat)
+}
+
+// @Summary Get chat members
+// @Description Get members of a chat with their roles
+// @Tags chats
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param chat_id path string true "Chat ID"
+// @Success 200 {array} models.ChatMember "Members retrieved successfully"
+// @Failure 403 {object} reqresp.ErrorResponse "Forbidden"
+// @Failure 404 {object} reqresp.ErrorResponse "Chat not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /chats/{chat_id}/members [get]
+func (h *ChatHandler) GetChatMembers(w http.ResponseWriter, r *http.Request) {
+	chatID := mux.Vars(r)["chat_id"]
+
+	members, err := h.chatService.GetChatMembers(r.Context(), chatID)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// @Summary Add chat member
+// @Description Add a user to a group chat. Requires owner or admin role.
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param chat_id path string true "Chat ID"
+// @Param request body reqresp.AddChatMemberRequest true "Member to add"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid request"
+// @Failure 403 {object} reqresp.ErrorResponse "Forbidden"
+// @Failure 404 {object} reqresp.ErrorResponse "Chat or user not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /chats/{chat_id}/members [post]
+func (h *ChatHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.AddChatMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := h.chatService.AddMember(r.Context(), mux.Vars(r)["chat_id"], req.UserID); err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Remove chat member
+// @Description Remove a user from a group chat. Owners can remove anyone, admins only members.
+// @Tags chats
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param chat_id path string true "Chat ID"
+// @Param user_id path string true "User ID"
+// @Success 204 {string} string "No Content"
+// @Failure 403 {object} reqresp.ErrorResponse "Forbidden"
+// @Failure 404 {object} reqresp.ErrorResponse "Chat or member not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /chats/{chat_id}/members/{user_id} [delete]
+func (h *ChatHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := h.chatService.RemoveMember(r.Context(), vars["chat_id"], vars["user_id"]); err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
+// @Summary Change chat member role
+// @Description Promote a member to admin or demote an admin to member. Owner only.
+// @Tags chats
+// @Accept json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param chat_id path string true "Chat ID"
+// @Param user_id path string true "User ID"
+// @Param request body reqresp.UpdateChatMemberRoleRequest true "New role"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid role"
+// @Failure 403 {object} reqresp.ErrorResponse "Forbidden"
+// @Failure 404 {object} reqresp.ErrorResponse "Chat or member not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /chats/{chat_id}/members/{user_id}/role [put]
+func (h *ChatHandler) PromoteMember(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.UpdateChatMemberRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	if err := h.chatService.PromoteMember(r.Context(), vars["chat_id"], vars["user_id"], req.Role); err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Leave chat
+// @Description Leave a group chat. If the owner leaves, ownership passes to an admin or the oldest member.
+// @Tags chats
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param chat_id path string true "Chat ID"
+// @Success 204 {string} string "No Content"
+// @Failure 404 {object} reqresp.ErrorResponse "Chat not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /chats/{chat_id}/leave [post]
+func (h *ChatHandler) LeaveChat(w http.ResponseWriter, r *http.Request) {
+	if err := h.chatService.LeaveChat(r.Context(), mux.Vars(r)["chat_id"]); err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Update chat settings
+// @Description Update chat settings such as the disappearing messages timer (seconds, 0 = off)
+// @Tags chats
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param chat_id path string true "Chat ID"
+// @Param request body reqresp.ChatSettingsRequest true "Chat settings"
+// @Success 200 {object} models.Chat "Settings updated"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid settings"
+// @Failure 403 {object} reqresp.ErrorResponse "Forbidden"
+// @Failure 404 {object} reqresp.ErrorResponse "Chat not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /chats/{chat_id}/settings [put]
+func (h *ChatHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.ChatSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	defer r.Body.Close()
+
+	chat, err := h.chatService.UpdateSettings(r.Context(), mux.Vars(r)["chat_id"], req)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chat)
+}
+
+// chatErrorStatus maps chat usecase errors to HTTP status codes
+func chatErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, usecase.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, usecase.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, usecase.ErrInvalidChatUsers), errors.Is(err, usecase.ErrNotGroupChat), errors.Is(err, usecase.ErrInvalidRole),
+		errors.Is(err, usecase.ErrInvalidSettings), errors.Is(err, usecase.ErrInvalidTTL),
+		errors.Is(err, usecase.ErrInvalidCursor),
+		errors.Is(err, usecase.ErrInvalidMessageKey), errors.Is(err, usecase.ErrInvalidClientMsgID),
+		errors.Is(err, usecase.ErrInvalidSearch), errors.Is(err, usecase.ErrInvalidContact), errors.Is(err, usecase.ErrInvalidNickname),
+		errors.Is(err, usecase.ErrInvalidBlock), errors.Is(err, usecase.ErrInvalidPublicKey), errors.Is(err, usecase.ErrInvalidKeyVersion),
+		errors.Is(err, usecase.ErrInvalidPrekey), errors.Is(err, usecase.ErrInvalidPrekeyBatch), errors.Is(err, usecase.ErrInvalidSignature),
+		errors.Is(err, usecase.ErrIdentityKeyMissing), errors.Is(err, usecase.ErrInvalidVerification),
+		errors.Is(err, usecase.ErrInvalidTreeSize), errors.Is(err, usecase.ErrInvalidAttachmentKind),
+		errors.Is(err, usecase.ErrInvalidAttachmentSize), errors.Is(err, usecase.ErrInvalidChunk),
+		errors.Is(err, usecase.ErrTooManyAttachments):
+		return http.StatusBadRequest
+	case errors.Is(err, usecase.ErrAttachmentTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, usecase.ErrAlreadyChatMember), errors.Is(err, usecase.ErrAlreadyContact), errors.Is(err, usecase.ErrStaleKey),
+		errors.Is(err, usecase.ErrTooManyPrekeys), errors.Is(err, usecase.ErrSafetyNumberMismatch),
+		errors.Is(err, usecase.ErrChunkOffset), errors.Is(err, usecase.ErrAttachmentIncomplete),
+		errors.Is(err, usecase.ErrAttachmentUnavailable):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// respondWithChatError responds with the status chatErrorStatus maps err to
+func respondWithChatError(w http.ResponseWriter, err error) {
+	respondWithStatusError(w, chatErrorStatus(err), err)
+}
+
+// respondWithStatusError responds with err and the given status. Internal errors
+// are logged and not shown to the client.
+func respondWithStatusError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusInternalServerError {
+		logger.Error("Request failed", err, nil)
+		respondWithError(w, status, "Internal server error")
+		return
+	}
+	respondWithError(w, status, err.Error())
+}
 
 // Helper functions for responding with JSON
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"poshta/internal/usecase"
+	"poshta/pkg/reqresp"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// KeyHandler handles the public keys messages are encrypted to, X3DH prekeys and key verification
+type KeyHandler struct {
+	keyService          usecase.KeyService
+	prekeyService       usecase.PrekeyService
+	verificationService usecase.VerificationService
+}
+
+func NewKeyHandler(keyService usecase.KeyService, prekeyService usecase.PrekeyService, verificationService usecase.VerificationService) *KeyHandler {
+	return &KeyHandler{
+		keyService:          keyService,
+		prekeyService:       prekeyService,
+		verificationService: verificationService,
+	}
+}
+
+// RotatePublicKey godoc
+// @Summary Rotate public key
+// @Description Replace the public key of the current user with a new version. The previous version is revoked but stays available. Everyone the user shares a chat with receives a key_changed WebSocket event.
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param request body reqresp.RotateKeyRequest true "New public key"
+// @Success 200 {object} models.PublicKey "Current public key"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid public key"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /keys [put]
+func (h *KeyHandler) RotatePublicKey(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.RotateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	key, err := h.keyService.RotatePublicKey(r.Context(), req)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, key)
+}
+
+// GetPublicKey godoc
+// @Summary Get user public key
+// @Description Get the current public key of a user, or an older version with ?version=
+// @Tags keys
+// @Produce json
+// @Param user_id path string true "User ID"
+// @Param version query int false "Key version, the current one by default"
+// @Success 200 {object} models.PublicKey "Public key"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid version"
+// @Failure 404 {object} reqresp.ErrorResponse "User or key version not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /{user_id}/public_key [get]
+func (h *KeyHandler) GetPublicKey(w http.ResponseWriter, r *http.Request) {
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, usecase.ErrInvalidKeyVersion.Error())
+			return
+		}
+		version = n
+	}
+
+	key, err := h.keyService.GetPublicKey(r.Context(), mux.Vars(r)["user_id"], version)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, key)
+}
+
+
+// SetIdentityKey godoc
+// @Summary Upload identity key
+// @Description Set the Ed25519 identity key used for X3DH. Replacing it deletes the signed and one-time prekeys, which have to be uploaded again.
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param request body reqresp.IdentityKeyRequest true "Base64 identity key"
+// @Success 200 {object} models.IdentityKey "Identity key"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid key"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /keys/identity [put]
+func (h *KeyHandler) SetIdentityKey(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.IdentityKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	key, err := h.prekeyService.SetIdentityKey(r.Context(), req)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, key)
+}
+
+// SetSignedPrekey godoc
+// @Summary Upload signed prekey
+// @Description Replace the signed X25519 prekey. The signature over the raw public key must verify with the identity key.
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param request body reqresp.SignedPrekeyRequest true "Signed prekey"
+// @Success 200 {object} models.SignedPrekey "Signed prekey"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid key or signature, or no identity key"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /keys/signed-prekey [put]
+func (h *KeyHandler) SetSignedPrekey(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.SignedPrekeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	prekey, err := h.prekeyService.SetSignedPrekey(r.Context(), req)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, prekey)
+}
+
+// AddOneTimePrekeys godoc
+// @Summary Upload one-time prekeys
+// @Description Add a batch of one-time X25519 prekeys. Key IDs that are already stored are skipped, so uploads can be retried.
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param request body reqresp.OneTimePrekeysRequest true "Prekeys"
+// @Success 200 {object} reqresp.PrekeyCountResponse "Prekeys stored now"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid batch"
+// @Failure 409 {object} reqresp.ErrorResponse "Too many prekeys stored"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /keys/one-time-prekeys [post]
+func (h *KeyHandler) AddOneTimePrekeys(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.OneTimePrekeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	count, err := h.prekeyService.AddOneTimePrekeys(r.Context(), req)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, reqresp.PrekeyCountResponse{Count: count})
+}
+
+// CountOneTimePrekeys godoc
+// @Summary Count one-time prekeys
+// @Description Number of one-time prekeys of the current user that have not been handed out yet
+// @Tags keys
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} reqresp.PrekeyCountResponse "Prekeys left"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /keys/one-time-prekeys/count [get]
+func (h *KeyHandler) CountOneTimePrekeys(w http.ResponseWriter, r *http.Request) {
+	count, err := h.prekeyService.CountOneTimePrekeys(r.Context())
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, reqresp.PrekeyCountResponse{Count: count})
+}
+
+// GetPrekeyBundle godoc
+// @Summary Get prekey bundle
+// @Description Get the identity key, signed prekey and one one-time prekey of a user to start an X3DH session. The one-time prekey is handed out only once; it is omitted when the user has none left. Rate-limited.
+// @Tags keys
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "User ID"
+// @Success 200 {object} reqresp.PrekeyBundle "Prekey bundle"
+// @Failure 404 {object} reqresp.ErrorResponse "User has no prekey bundle"
+// @Failure 429 {string} string "Too many requests, see Retry-After"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /users/{id}/prekey-bundle [get]
+func (h *KeyHandler) GetPrekeyBundle(w http.ResponseWriter, r *http.Request) {
+	bundle, err := h.prekeyService.GetBundle(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, bundle)
+}
+
+// GetSafetyNumber godoc
+// @Summary Get safety number
+// @Description Get the safety number of the current user's and another user's public keys, to compare out of band, and whether the other user's key was verified
+// @Tags keys
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "User ID"
+// @Success 200 {object} reqresp.SafetyNumberResponse "Safety number"
+// @Failure 400 {object} reqresp.ErrorResponse "Own user ID"
+// @Failure 404 {object} reqresp.ErrorResponse "User not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /users/{id}/safety-number [get]
+func (h *KeyHandler) GetSafetyNumber(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.verificationService.GetSafetyNumber(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// VerifyKey godoc
+// @Summary Mark key as verified
+// @Description Mark the other user's current public key as verified after comparing the safety number. The verification is reset when their key changes.
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "User ID"
+// @Param request body reqresp.VerifyKeyRequest true "Compared safety number"
+// @Success 200 {object} reqresp.SafetyNumberResponse "Verified"
+// @Failure 400 {object} reqresp.ErrorResponse "Own user ID"
+// @Failure 404 {object} reqresp.ErrorResponse "User not found"
+// @Failure 409 {object} reqresp.ErrorResponse "Safety number does not match the current keys"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /users/{id}/verification [put]
+func (h *KeyHandler) VerifyKey(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.VerifyKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	resp, err := h.verificationService.VerifyKey(r.Context(), mux.Vars(r)["id"], req)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// UnverifyKey godoc
+// @Summary Withdraw key verification
+// @Description Mark the other user's key as not verified and clear the key change flag
+// @Tags keys
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "User ID"
+// @Success 204 {string} string "No Content"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /users/{id}/verification [delete]
+func (h *KeyHandler) UnverifyKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.verificationService.UnverifyKey(r.Context(), mux.Vars(r)["id"]); err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"poshta/internal/usecase"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// KeyLogHandler serves the append-only public key log to clients and auditors
+type KeyLogHandler struct {
+	keyLogService usecase.KeyLogService
+}
+
+func NewKeyLogHandler(keyLogService usecase.KeyLogService) *KeyLogHandler {
+	return &KeyLogHandler{
+		keyLogService: keyLogService,
+	}
+}
+
+// GetTreeHead godoc
+// @Summary Get signed tree head
+// @Description Get the current size and root hash of the key log, signed with the log's Ed25519 key
+// @Tags keylog
+// @Produce json
+// @Success 200 {object} reqresp.SignedTreeHead "Signed tree head"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /keylog/head [get]
+func (h *KeyLogHandler) GetTreeHead(w http.ResponseWriter, r *http.Request) {
+	head, err := h.keyLogService.TreeHead(r.Context())
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, head)
+}
+
+// GetSigningKey godoc
+// @Summary Get key log signing key
+// @Description Get the Ed25519 public key tree heads are signed with
+// @Tags keylog
+// @Produce json
+// @Success 200 {object} reqresp.KeyLogPublicKey "Signing key"
+// @Router /keylog/key [get]
+func (h *KeyLogHandler) GetSigningKey(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, h.keyLogService.PublicKey())
+}
+
+// GetInclusionProof godoc
+// @Summary Get inclusion proof
+// @Description Prove that the current public key of a user is in the key log. Without tree_size the proof is for the current tree head, which is returned with it.
+// @Tags keylog
+// @Produce json
+// @Param user_id path string true "User ID"
+// @Param tree_size query int false "Size of a tree head the client already has"
+// @Success 200 {object} reqresp.KeyInclusionProof "Inclusion proof"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid tree size"
+// @Failure 404 {object} reqresp.ErrorResponse "User not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /keylog/proof/{user_id} [get]
+func (h *KeyLogHandler) GetInclusionProof(w http.ResponseWriter, r *http.Request) {
+	treeSize, ok := treeSizeParam(w, r, "tree_size")
+	if !ok {
+		return
+	}
+
+	proof, err := h.keyLogService.InclusionProof(r.Context(), mux.Vars(r)["user_id"], treeSize)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, proof)
+}
+
+// GetConsistencyProof godoc
+// @Summary Get consistency proof
+// @Description Prove that the key log of size first is a prefix of the log of size second, so no key was removed or replaced in between
+// @Tags keylog
+// @Produce json
+// @Param first query int true "Size of the older tree head"
+// @Param second query int false "Size of the newer tree head, the current size by default"
+// @Success 200 {object} reqresp.KeyConsistencyProof "Consistency proof"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid tree size"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /keylog/consistency [get]
+func (h *KeyLogHandler) GetConsistencyProof(w http.ResponseWriter, r *http.Request) {
+	first, ok := treeSizeParam(w, r, "first")
+	if !ok {
+		return
+	}
+	second, ok := treeSizeParam(w, r, "second")
+	if !ok {
+		return
+	}
+
+	proof, err := h.keyLogService.ConsistencyProof(r.Context(), first, second)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, proof)
+}
+
+// treeSizeParam parses an optional tree size query parameter, 0 if it is missing
+func treeSizeParam(w http.ResponseWriter, r *http.Request, name string) (uint64, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || n == 0 {
+		respondWithError(w, http.StatusBadRequest, usecase.ErrInvalidTreeSize.Error())
+		return 0, false
+	}
+	return n, true
+}
@@ -11,7 +11,6 @@ import (
 	"poshta/internal/usecase"
 	"poshta/pkg/reqresp"
 	"strconv"
-	"strings"
 
 	"github.com/gorilla/mux"
 
@@ -35,9 +34,12 @@ func NewMessageHandler(messageUseCase usecase.MessageUseCase) *MessageHandler {
 // @Produce json
 // @Security ApiKeyAuth
 // @Param Authorization header string true "Bearer token"
+// @Param Idempotency-Key header string false "Retries with the same key return the already stored message"
 // @Param request body reqresp.SendMessageRequest true "Create message request"
-// @Success 201 {object} models.Message "Chat created successfully"
+// @Success 201 {object} models.Message "Message created successfully"
 // @Failure 400 {object} reqresp.ErrorResponse "Invalid request"
+// @Failure 403 {object} reqresp.ErrorResponse "Not a chat member"
+// @Failure 404 {object} reqresp.ErrorResponse "Chat not found"
 // @Failure 500 {object} reqresp.ErrorResponse "Server error"
 // @Router /message [post]
 func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
@@ -48,9 +50,14 @@ func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	// Idempotency-Key shares the client_msg_id namespace with WebSocket sends
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		req.ClientMsgID = key
+	}
+
 	message, err := h.messageUseCase.SendMessage(r.Context(), req)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithChatError(w, err)
 		return
 	}
 
@@ -59,6 +66,73 @@ func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 
 
 
+// EditMessage godoc
+// @Summary      Edit a message
+// @Description  Replaces the content and wrapped keys of a message. Only its sender can edit it, within the configured time after sending; the replaced version stays in the message history.
+// @Tags         messages
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path  int                         true  "Message ID"
+// @Param        request  body  reqresp.EditMessageRequest  true  "New content and keys"
+// @Success      200  {object}  models.Message "Edited message"
+// @Failure      400  {object}  reqresp.ErrorResponse "Invalid request"
+// @Failure      403  {object}  reqresp.ErrorResponse "Forbidden or edit window closed"
+// @Failure      404  {object}  reqresp.ErrorResponse "Message not found"
+// @Failure      500  {object}  reqresp.ErrorResponse "Internal Server Error"
+// @Router       /messages/{id} [patch]
+func (h *MessageHandler) EditMessage(w http.ResponseWriter, r *http.Request) {
+    messageID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        respondWithError(w, http.StatusBadRequest, "Invalid message ID")
+        return
+    }
+
+    var req reqresp.EditMessageRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+        return
+    }
+    defer r.Body.Close()
+
+    message, err := h.messageUseCase.EditMessage(r.Context(), messageID, req)
+    if err != nil {
+        respondWithChatError(w, err)
+        return
+    }
+
+    respondWithJSON(w, http.StatusOK, message)
+}
+
+// GetMessageHistory godoc
+// @Summary      Get message edit history
+// @Description  Returns a message as it is now followed by the versions its edits replaced, oldest first, each with the key wrapped for the current user.
+// @Tags         messages
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id         path   int     true   "Message ID"
+// @Param        device_id  query  string  false  "Device ID whose wrapped keys should be returned"
+// @Success      200  {object}  reqresp.MessageHistory "Message history"
+// @Failure      400  {object}  reqresp.ErrorResponse "Invalid ID"
+// @Failure      404  {object}  reqresp.ErrorResponse "Message not found"
+// @Failure      500  {object}  reqresp.ErrorResponse "Internal Server Error"
+// @Router       /messages/{id}/revisions [get]
+func (h *MessageHandler) GetMessageHistory(w http.ResponseWriter, r *http.Request) {
+    messageID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+    if err != nil {
+        respondWithError(w, http.StatusBadRequest, "Invalid message ID")
+        return
+    }
+
+    history, err := h.messageUseCase.GetMessageHistory(r.Context(), messageID, r.URL.Query().Get("device_id"))
+    if err != nil {
+        respondWithChatError(w, err)
+        return
+    }
+
+    respondWithJSON(w, http.StatusOK, history)
+}
+
 // DeleteMessage godoc
 // @Summary      Delete a message
 // @Description  Deletes a message by ID. Only the owner of the message can delete it.
@@ -69,6 +143,7 @@ func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
 // @Failure      400  {object}  ErrorResponse "Invalid ID"
 // @Failure      401  {object}  ErrorResponse "Unauthorized"
 // @Failure      403  {object}  ErrorResponse "Forbidden"
+// @Failure      404  {object}  ErrorResponse "Message not found"
 // @Failure      500  {object}  ErrorResponse "Internal Server Error"
 // @Router       /messages/{id} [delete]
 func (h *MessageHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
@@ -80,28 +155,68 @@ func (h *MessageHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Получаем юзера из контекста
-    user, err := GetUserFromContext(r.Context())
+    // Удаляем сообщение через usecase
+    err = h.messageUseCase.DeleteMessage(r.Context(), messageID)
     if err != nil {
-        http.Error(w, "unauthorized", http.StatusUnauthorized)
+        respondWithChatError(w, err)
         return
     }
 
-    // Удаляем сообщение через usecase
-    err = h.messageUseCase.DeleteMessage(r.Context(), messageID, user.ID)
-    if err != nil {
-        if strings.Contains(err.Error(), "unauthorized") {
-            http.Error(w, "forbidden", http.StatusForbidden)
-            return
-        }
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+    w.WriteHeader(http.StatusNoContent)
+}
+
+
+
+// MarkRead godoc
+// @Summary      Mark chat as read
+// @Description  Marks every message of the chat up to message_id as read by the current user and notifies the other members.
+// @Tags         messages
+// @Accept       json
+// @Security     BearerAuth
+// @Param        chat_id  path  string                   true  "Chat ID"
+// @Param        request  body  reqresp.MarkReadRequest  true  "Last read message"
+// @Success      204  {string}  string  "No Content"
+// @Failure      400  {object}  reqresp.ErrorResponse "Invalid request"
+// @Failure      403  {object}  reqresp.ErrorResponse "Forbidden"
+// @Failure      404  {object}  reqresp.ErrorResponse "Message not found"
+// @Failure      500  {object}  reqresp.ErrorResponse "Internal Server Error"
+// @Router       /chats/{chat_id}/read [post]
+func (h *MessageHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+    var req reqresp.MarkReadRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID <= 0 {
+        respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+        return
+    }
+    defer r.Body.Close()
+
+    if err := h.messageUseCase.MarkRead(r.Context(), mux.Vars(r)["chat_id"], req.MessageID); err != nil {
+        respondWithChatError(w, err)
         return
     }
 
     w.WriteHeader(http.StatusNoContent)
 }
 
+// GetReadPositions godoc
+// @Summary      Get read positions
+// @Description  Returns how far every member of the chat has received and read it.
+// @Tags         messages
+// @Produce      json
+// @Security     BearerAuth
+// @Param        chat_id  path  string  true  "Chat ID"
+// @Success      200  {array}   models.ReadPosition "Read positions"
+// @Failure      403  {object}  reqresp.ErrorResponse "Forbidden"
+// @Failure      500  {object}  reqresp.ErrorResponse "Internal Server Error"
+// @Router       /chats/{chat_id}/read [get]
+func (h *MessageHandler) GetReadPositions(w http.ResponseWriter, r *http.Request) {
+    positions, err := h.messageUseCase.GetReadPositions(r.Context(), mux.Vars(r)["chat_id"])
+    if err != nil {
+        respondWithChatError(w, err)
+        return
+    }
 
+    respondWithJSON(w, http.StatusOK, positions)
+}
 
 func GetUserFromContext(ctx context.Context) (*models.User, error) {
     user, ok := ctx.Value(middleware.UserContextKey).(*models.User)
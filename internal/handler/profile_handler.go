@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"poshta/internal/usecase"
+	"poshta/pkg/reqresp"
+
+	"github.com/gorilla/mux"
+)
+
+type ProfileHandler struct {
+	profileService usecase.ProfileService
+}
+
+func NewProfileHandler(profileService usecase.ProfileService) *ProfileHandler {
+	return &ProfileHandler{
+		profileService: profileService,
+	}
+}
+
+// GetProfile godoc
+// @Summary Get own profile
+// @Description Get the profile of the current user, including the email address and whether it is verified
+// @Tags user
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} models.User "Profile"
+// @Failure 401 {object} reqresp.ErrorResponse "Unauthorized"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /profile [get]
+func (h *ProfileHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	profile, err := h.profileService.GetProfile(r.Context())
+	if err != nil {
+		respondWithStatusError(w, profileErrorStatus(err), err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+// UpdateProfile godoc
+// @Summary Update own profile
+// @Description Change username, display name, bio or email address. Omitted fields stay unchanged. A new email address has to be verified again.
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param request body reqresp.UpdateProfileRequest true "Fields to change"
+// @Success 200 {object} models.User "Updated profile"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid profile"
+// @Failure 401 {object} reqresp.ErrorResponse "Unauthorized"
+// @Failure 409 {object} reqresp.ErrorResponse "Username or email address already in use"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /profile [patch]
+func (h *ProfileHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	profile, err := h.profileService.UpdateProfile(r.Context(), req)
+	if err != nil {
+		respondWithStatusError(w, profileErrorStatus(err), err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+// ChangePassword godoc
+// @Summary Change password
+// @Description Set a new password, confirmed with the current one. Every other session of the user is ended.
+// @Tags user
+// @Accept json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param request body reqresp.ChangePasswordRequest true "Current and new password"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {object} reqresp.ErrorResponse "New password too short"
+// @Failure 401 {object} reqresp.ErrorResponse "Unauthorized"
+// @Failure 403 {object} reqresp.ErrorResponse "Current password is incorrect"
+// @Failure 429 {object} reqresp.ErrorResponse "Too many failed attempts, see Retry-After"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /profile/password [post]
+func (h *ProfileHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := h.profileService.ChangePassword(r.Context(), req); err != nil {
+		if respondThrottled(w, err) {
+			return
+		}
+		respondWithStatusError(w, profileErrorStatus(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetUser godoc
+// @Summary Get a user's profile
+// @Description Get the public profile of a user: username, display name, bio and registration time
+// @Tags user
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "User ID"
+// @Success 200 {object} reqresp.PublicProfile "Public profile"
+// @Failure 401 {object} reqresp.ErrorResponse "Unauthorized"
+// @Failure 404 {object} reqresp.ErrorResponse "User not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /users/{id} [get]
+func (h *ProfileHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["id"]
+
+	profile, err := h.profileService.GetPublicProfile(r.Context(), userID)
+	if err != nil {
+		respondWithStatusError(w, profileErrorStatus(err), err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+// profileErrorStatus maps profile errors to HTTP status codes
+func profileErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, usecase.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, usecase.ErrInvalidProfile), errors.Is(err, usecase.ErrInvalidPassword):
+		return http.StatusBadRequest
+	case errors.Is(err, usecase.ErrWrongPassword):
+		return http.StatusForbidden
+	case errors.Is(err, usecase.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, usecase.ErrUsernameTaken), errors.Is(err, usecase.ErrEmailTaken):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
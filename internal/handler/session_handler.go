@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"poshta/internal/middleware"
+	"poshta/internal/service"
+	"poshta/pkg/logger"
+
+	"github.com/gorilla/mux"
+)
+
+type SessionHandler struct {
+	sessionService service.SessionService
+}
+
+func NewSessionHandler(sessionService service.SessionService) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+	}
+}
+
+// GetSessions godoc
+// @Summary List sessions
+// @Description List the active logins of the current user. The session of the request is marked as current.
+// @Tags sessions
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {array} models.Session "Active sessions"
+// @Failure 401 {object} reqresp.ErrorResponse "Unauthorized"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /sessions [get]
+func (h *SessionHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	sessions, err := h.sessionService.GetSessions(r.Context(), user.ID, middleware.SessionIDFromContext(r.Context()))
+	if err != nil {
+		logger.Error("Failed to list sessions", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary Revoke session
+// @Description End a login of the current user: its refresh token stops working and its WebSocket connections are closed
+// @Tags sessions
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "Session ID"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {object} reqresp.ErrorResponse "Unauthorized"
+// @Failure 404 {object} reqresp.ErrorResponse "Session not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /sessions/{id} [delete]
+func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if err := h.sessionService.RevokeSession(r.Context(), user.ID, mux.Vars(r)["id"]); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		logger.Error("Failed to revoke session", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
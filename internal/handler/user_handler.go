@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"poshta/internal/usecase"
+	"poshta/pkg/reqresp"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// UserHandler handles user search and contacts
+type UserHandler struct {
+	userService usecase.UserService
+}
+
+func NewUserHandler(userService usecase.UserService) *UserHandler {
+	return &UserHandler{
+		userService: userService,
+	}
+}
+
+// SearchUsers godoc
+// @Summary Search users
+// @Description Find users whose username starts with q. Users who restricted their discoverability don't show up. Rate-limited.
+// @Tags users
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param q query string true "Username prefix, 2 to 32 characters"
+// @Param limit query int false "Maximum number of results (default 20, max 50)"
+// @Success 200 {array} reqresp.PublicProfile "Matching users"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid query"
+// @Failure 429 {string} string "Too many requests, see Retry-After"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /users/search [get]
+func (h *UserHandler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	users, err := h.userService.SearchUsers(r.Context(), query.Get("q"), limit)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+// GetContacts godoc
+// @Summary List contacts
+// @Description List the contacts of the current user with their nicknames
+// @Tags contacts
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {array} models.Contact "Contacts"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /contacts [get]
+func (h *UserHandler) GetContacts(w http.ResponseWriter, r *http.Request) {
+	contacts, err := h.userService.GetContacts(r.Context())
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, contacts)
+}
+
+// AddContact godoc
+// @Summary Add a contact
+// @Description Add a user, given by user_id or username, to the contacts of the current user
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param request body reqresp.AddContactRequest true "User and optional nickname"
+// @Success 201 {object} models.Contact "Contact added"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid request"
+// @Failure 404 {object} reqresp.ErrorResponse "User not found"
+// @Failure 409 {object} reqresp.ErrorResponse "Already a contact"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /contacts [post]
+func (h *UserHandler) AddContact(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.AddContactRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	contact, err := h.userService.AddContact(r.Context(), req)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, contact)
+}
+
+// UpdateContact godoc
+// @Summary Rename a contact
+// @Description Change the nickname of a contact; an empty nickname removes it
+// @Tags contacts
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param user_id path string true "User ID of the contact"
+// @Param request body reqresp.UpdateContactRequest true "New nickname"
+// @Success 200 {object} models.Contact "Contact updated"
+// @Failure 400 {object} reqresp.ErrorResponse "Invalid nickname"
+// @Failure 404 {object} reqresp.ErrorResponse "Contact not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /contacts/{user_id} [put]
+func (h *UserHandler) UpdateContact(w http.ResponseWriter, r *http.Request) {
+	var req reqresp.UpdateContactRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	contact, err := h.userService.UpdateContact(r.Context(), mux.Vars(r)["user_id"], req.Nickname)
+	if err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, contact)
+}
+
+// RemoveContact godoc
+// @Summary Remove a contact
+// @Description Remove a user from the contacts of the current user
+// @Tags contacts
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param user_id path string true "User ID of the contact"
+// @Success 204 {string} string "No Content"
+// @Failure 404 {object} reqresp.ErrorResponse "Contact not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /contacts/{user_id} [delete]
+func (h *UserHandler) RemoveContact(w http.ResponseWriter, r *http.Request) {
+	if err := h.userService.RemoveContact(r.Context(), mux.Vars(r)["user_id"]); err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BlockUser godoc
+// @Summary Block a user
+// @Description Block a user. They can no longer find the caller, see their profile or open chats with them, their direct messages are silently withheld and typing and presence are no longer exchanged. The blocked user is not told.
+// @Tags users
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "User ID"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {object} reqresp.ErrorResponse "Cannot block yourself"
+// @Failure 404 {object} reqresp.ErrorResponse "User not found"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /users/{id}/block [post]
+func (h *UserHandler) BlockUser(w http.ResponseWriter, r *http.Request) {
+	if err := h.userService.BlockUser(r.Context(), mux.Vars(r)["id"]); err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnblockUser godoc
+// @Summary Unblock a user
+// @Description Lift a block. Messages withheld while the user was blocked are not delivered.
+// @Tags users
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "User ID"
+// @Success 204 {string} string "No Content"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /users/{id}/block [delete]
+func (h *UserHandler) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	if err := h.userService.UnblockUser(r.Context(), mux.Vars(r)["id"]); err != nil {
+		respondWithChatError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
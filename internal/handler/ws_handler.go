@@ -1,24 +1,38 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"poshta/internal/app/ws"
+	"poshta/internal/middleware"
+	"poshta/internal/service"
 	"poshta/internal/usecase"
+	"poshta/pkg/logger"
+	"poshta/pkg/reqresp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+// wsTokenProtocol is the Sec-WebSocket-Protocol value a client sends together
+// with its access token, e.g. "Sec-WebSocket-Protocol: access_token, <jwt>".
+const wsTokenProtocol = "access_token"
+
 type WSHandler struct {
 	Hub            *ws.Hub
+	AuthService    service.AuthService
 	MessageUseCase usecase.MessageUseCase
-	ChatUseCase    usecase.ChatService
 }
 
-func NewWSHandler(hub *ws.Hub, msgUC usecase.MessageUseCase, chatUC usecase.ChatService) *WSHandler {
+func NewWSHandler(hub *ws.Hub, authService service.AuthService, msgUC usecase.MessageUseCase) *WSHandler {
 	return &WSHandler{
 		Hub:            hub,
+		AuthService:    authService,
 		MessageUseCase: msgUC,
-		ChatUseCase:    chatUC,
 	}
 }
 
@@ -26,29 +40,146 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
+	Subprotocols: []string{wsTokenProtocol},
 }
 
+// IssueTicket godoc
+// @Summary Issue WebSocket ticket
+// @Description Issue a short-lived ticket used to open a WebSocket connection via /ws?ticket=
+// @Tags websocket
+// @Produce json
+// @Security ApiKeyAuth
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} reqresp.WSTicketResponse "Ticket issued"
+// @Failure 401 {object} reqresp.ErrorResponse "Unauthorized"
+// @Failure 500 {object} reqresp.ErrorResponse "Server error"
+// @Router /ws/ticket [post]
+func (h *WSHandler) IssueTicket(w http.ResponseWriter, r *http.Request) {
+	user, err := GetUserFromContext(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	ticket, expiry, err := h.AuthService.GenerateWSTicket(r.Context(), user,
+		middleware.SessionIDFromContext(r.Context()), middleware.AccessExpiryFromContext(r.Context()))
+	if errors.Is(err, service.ErrInvalidToken) {
+		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to issue ws ticket", err, nil)
+		respondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, reqresp.WSTicketResponse{
+		Ticket:    ticket,
+		ExpiresIn: int64(time.Until(expiry).Seconds()),
+	})
+}
+
+// ServeWS upgrades the connection after authenticating it either with a ticket
+// from /api/ws/ticket or with an access token passed as a subprotocol.
 func (h *WSHandler) ServeWS(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("user_id")
-	if userID == "" {
-		http.Error(w, "Missing user_id", http.StatusBadRequest)
+	tokenString, tokenType := wsCredentials(r)
+	if tokenString == "" {
+		http.Error(w, "Missing credentials", http.StatusUnauthorized)
+		return
+	}
+
+	// тикет одноразовый, токен доступа — нет
+	var token *jwt.Token
+	var err error
+	if tokenType == "ws_ticket" {
+		token, err = h.AuthService.ValidateWSTicket(r.Context(), tokenString)
+	} else {
+		token, err = h.AuthService.ValidateTokenType(tokenString, tokenType)
+	}
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.AuthService.GetUserFromToken(token)
+	if err != nil || user == nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
 
+	expiresAt, err := h.AuthService.SessionExpiry(token)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	// каждое устройство пользователя — отдельное соединение
+	deviceID := r.URL.Query().Get("device_id")
+	if deviceID == "" {
+		deviceID = uuid.New().String()
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		http.Error(w, "Failed to upgrade", http.StatusInternalServerError)
+		logger.Error("Failed to upgrade", err, nil)
 		return
 	}
 
 	client := &ws.Client{
-		UserID: userID,
-		Conn:   conn,
-		Hub:    h.Hub,
-		Send:   make(chan []byte, 256),
+		UserID:    user.ID,
+		User:      user,
+		DeviceID:  deviceID,
+		SessionID: h.AuthService.SessionID(token),
+		Conn:      conn,
+		Hub:       h.Hub,
+		Send:      make(chan []byte, 256),
+		ExpiresAt: expiresAt,
 	}
 
+	var replay chan []reqresp.WSMessage
+	if r.URL.Query().Has("last_message_id") {
+		replay = make(chan []reqresp.WSMessage, 1)
+	}
+
+	// регистрируемся до загрузки пропущенного, чтобы ничего не потерять;
+	// живые кадры придерживаются, пока пропущенные не отправлены
 	h.Hub.Register <- client
-	go client.WritePump()
-	go client.ReadPump(h.MessageUseCase, h.ChatUseCase)
+	go client.WritePump(replay)
+	go client.ReadPump(h.MessageUseCase)
+
+	if replay != nil {
+		replay <- h.missedFrames(r, client)
+	}
+}
+
+// missedFrames loads the frames the client missed while offline. Live frames
+// queued by the hub meanwhile are sent after them, so clients should ignore
+// messages and events with IDs they already have.
+func (h *WSHandler) missedFrames(r *http.Request, client *ws.Client) []reqresp.WSMessage {
+	lastMessageID, _ := strconv.ParseInt(r.URL.Query().Get("last_message_id"), 10, 64)
+	lastEventID, _ := strconv.ParseInt(r.URL.Query().Get("last_event_id"), 10, 64)
+
+	frames, err := h.MessageUseCase.Replay(middleware.WithUser(r.Context(), client.User), client.DeviceID, lastMessageID, lastEventID)
+	if err != nil {
+		logger.Error("Failed to replay missed messages", err, nil)
+		return nil
+	}
+	return frames
+}
+
+// wsCredentials extracts the token presented on the upgrade request and the
+// token type it is expected to carry.
+func wsCredentials(r *http.Request) (string, string) {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		return ticket, "ws_ticket"
+	}
+
+	protocols := websocket.Subprotocols(r)
+	for i, p := range protocols {
+		if p == wsTokenProtocol && i+1 < len(protocols) {
+			return strings.TrimSpace(protocols[i+1]), "access"
+		}
+	}
+
+	return "", ""
 }
@@ -3,15 +3,40 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"poshta/internal/domain/models"
 	"poshta/internal/service"
 	"poshta/pkg/logger"
 	"strings"
+	"time"
 )
 
 // Key for user context
 type contextKey string
 
-const UserContextKey contextKey = "user"
+const (
+	UserContextKey         contextKey = "user"
+	SessionIDContextKey    contextKey = "session_id"
+	AccessExpiryContextKey contextKey = "access_expiry"
+)
+
+// WithUser returns a copy of ctx carrying the authenticated user
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, UserContextKey, user)
+}
+
+// SessionIDFromContext returns the session the request was authenticated with,
+// or "" if the token carried none
+func SessionIDFromContext(ctx context.Context) string {
+	sessionID, _ := ctx.Value(SessionIDContextKey).(string)
+	return sessionID
+}
+
+// AccessExpiryFromContext returns when the access token the request was
+// authenticated with expires, or the zero time if it is unknown
+func AccessExpiryFromContext(ctx context.Context) time.Time {
+	expiry, _ := ctx.Value(AccessExpiryContextKey).(time.Time)
+	return expiry
+}
 
 // JWTMiddleware is middleware for JWT authentication
 type JWTMiddleware struct {
@@ -43,8 +68,8 @@ func (m *JWTMiddleware) Authenticate(next http.Handler) http.Handler {
 
 		tokenString := headerParts[1]
 
-		// Validate token
-		token, err := m.authService.ValidateToken(tokenString)
+		// Validate token. Refresh and MFA tokens must not authenticate requests.
+		token, err := m.authService.ValidateTokenType(tokenString, "access")
 		if err != nil {
 			logger.Error("Invalid token", err, nil)
 			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
@@ -53,14 +78,23 @@ func (m *JWTMiddleware) Authenticate(next http.Handler) http.Handler {
 
 		// Get user from token
 		user, err := m.authService.GetUserFromToken(token)
-		if err != nil {
+		if err != nil || user == nil {
 			logger.Error("Failed to get user from token", err, nil)
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		// Add user to context
-		ctx := context.WithValue(r.Context(), UserContextKey, user)
+		expiry, err := m.authService.SessionExpiry(token)
+		if err != nil {
+			logger.Error("Failed to get token expiry", err, nil)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		// Add user, session and token expiry to context
+		ctx := WithUser(r.Context(), user)
+		ctx = context.WithValue(ctx, SessionIDContextKey, m.authService.SessionID(token))
+		ctx = context.WithValue(ctx, AccessExpiryContextKey, expiry)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -68,4 +102,4 @@ func (m *JWTMiddleware) Authenticate(next http.Handler) http.Handler {
 // CreateAuthenticatedHandler is a convenience function that wraps a handler with authentication
 func (m *JWTMiddleware) CreateAuthenticatedHandler(handler http.HandlerFunc) http.Handler {
 	return m.Authenticate(handler)
-}
\ No newline at end of file
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"poshta/internal/domain/models"
+	"poshta/pkg/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter allows each user a fixed number of requests per window. It has to
+// run after Authenticate; unauthenticated requests are limited by IP address.
+type RateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Limit answers 429 with a Retry-After header once the caller used up its requests
+func (l *RateLimiter) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return l.LimitBy(rateKey, next)
+}
+
+// LimitBy is Limit with the requests counted per key instead of per caller.
+// Requests the key function returns "" for are not limited.
+func (l *RateLimiter) LimitBy(key func(r *http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		k := key(r)
+		if k == "" {
+			next(w, r)
+			return
+		}
+		if wait := l.take(k, time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// take counts a request and returns how long the caller has to wait if it is over the limit
+func (l *RateLimiter) take(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// окна старше window больше не нужны
+	if now.Sub(l.lastSweep) > l.window {
+		for k, win := range l.windows {
+			if now.Sub(win.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	win, ok := l.windows[key]
+	if !ok || now.Sub(win.start) >= l.window {
+		win = &rateWindow{start: now}
+		l.windows[key] = win
+	}
+	if win.count >= l.limit {
+		return win.start.Add(l.window).Sub(now)
+	}
+	win.count++
+	return 0
+}
+
+func rateKey(r *http.Request) string {
+	if user, ok := r.Context().Value(UserContextKey).(*models.User); ok && user != nil {
+		return "user:" + user.ID
+	}
+	return IPRateKey(r)
+}
+
+// IPRateKey counts requests per client IP address, for LimitBy
+func IPRateKey(r *http.Request) string {
+	return "ip:" + httputil.ClientIP(r)
+}
+
+// maxRateKeyBody caps how much of a request body EmailRateKey reads
+const maxRateKeyBody = 64 << 10
+
+// EmailRateKey counts requests per email address in the JSON body, for LimitBy.
+// The address is normalized so that case and spaces don't make a new key. The
+// body is left in place for the handler.
+func EmailRateKey(r *http.Request) string {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRateKeyBody))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" {
+		return ""
+	}
+	return "email:" + email
+}
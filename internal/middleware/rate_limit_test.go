@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailRateKey(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"email": "Alice@Example.com "}`, "email:alice@example.com"},
+		{`{"email": ""}`, ""},
+		{`not json`, ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		if got := EmailRateKey(r); got != tt.want {
+			t.Errorf("EmailRateKey(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+		// тело остаётся обработчику
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != tt.body {
+			t.Errorf("EmailRateKey left body %q, want %q", body, tt.body)
+		}
+	}
+}
+
+func TestLimitByEmail(t *testing.T) {
+	limiter := NewRateLimiter(2, time.Hour)
+	handler := limiter.LimitBy(EmailRateKey, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	send := func(email string) int {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"email": "`+email+`"}`))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	for i, email := range []string{"bob@example.com", "BOB@example.com"} {
+		if code := send(email); code != http.StatusAccepted {
+			t.Fatalf("request %d: got %d, want %d", i+1, code, http.StatusAccepted)
+		}
+	}
+	if code := send(" bob@example.com"); code != http.StatusTooManyRequests {
+		t.Fatalf("third request for the same address: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := send("carol@example.com"); code != http.StatusAccepted {
+		t.Fatalf("request for another address: got %d, want %d", code, http.StatusAccepted)
+	}
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"poshta/internal/domain/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type AccountTokenRepository interface {
+	Create(ctx context.Context, token *models.AccountToken) error
+	Use(ctx context.Context, id, purpose string) (*models.AccountToken, error)
+}
+
+type accountTokenRepository struct {
+	db *sqlx.DB
+}
+
+func NewAccountTokenRepository(db *sqlx.DB) AccountTokenRepository {
+	return &accountTokenRepository{
+		db: db,
+	}
+}
+
+// Create stores a token and invalidates the unused tokens of the user with the same purpose
+func (r *accountTokenRepository) Create(ctx context.Context, token *models.AccountToken) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE account_tokens
+		SET used_at = UTC_TIMESTAMP()
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`
+	if _, err := tx.ExecContext(ctx, query, token.UserID, token.Purpose); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO account_tokens (id, user_id, purpose, created_at, expires_at)
+		VALUES (:id, :user_id, :purpose, :created_at, :expires_at)
+	`
+	if _, err := tx.NamedExecContext(ctx, query, token); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Use marks an unused, unexpired token as used and returns it. It returns nil
+// if there is no such token.
+func (r *accountTokenRepository) Use(ctx context.Context, id, purpose string) (*models.AccountToken, error) {
+	query := `
+		UPDATE account_tokens
+		SET used_at = UTC_TIMESTAMP()
+		WHERE id = ? AND purpose = ? AND used_at IS NULL AND expires_at > UTC_TIMESTAMP()
+	`
+	result, err := r.db.ExecContext(ctx, query, id, purpose)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, nil
+	}
+
+	token := &models.AccountToken{}
+	query = `SELECT id, user_id, purpose, created_at, expires_at, used_at FROM account_tokens WHERE id = ?`
+	if err := r.db.GetContext(ctx, token, query, id); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poshta/internal/domain/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrChunkOffset is returned by AddChunk when the chunk doesn't continue the
+// upload where it stands, e.g. after a concurrent upload of the same chunk
+var ErrChunkOffset = errors.New("chunk offset does not match the upload")
+
+type AttachmentRepository interface {
+	Create(ctx context.Context, attachment *models.Attachment) (string, error)
+	GetByID(ctx context.Context, attachmentID string) (*models.Attachment, error)
+	AddChunk(ctx context.Context, attachmentID string, offset int64, chunk models.AttachmentChunk) (*models.Attachment, error)
+	GetChunks(ctx context.Context, attachmentID string) ([]models.AttachmentChunk, error)
+	GetOrphans(ctx context.Context, createdBefore time.Time, limit int) ([]models.Attachment, error)
+	DeleteOrphan(ctx context.Context, attachmentID string, deleteBlobs func(chunks []models.AttachmentChunk) error) (bool, error)
+}
+
+type attachmentRepository struct {
+	db *sqlx.DB
+}
+
+func NewAttachmentRepository(db *sqlx.DB) AttachmentRepository {
+	return &attachmentRepository{
+		db: db,
+	}
+}
+
+const attachmentColumns = `id, chat_id, uploader_id, kind, size, received, chunk_count, message_id, created_at, completed_at`
+
+// Create implements AttachmentRepository.
+func (r *attachmentRepository) Create(ctx context.Context, attachment *models.Attachment) (string, error) {
+	attachment.ID = uuid.New().String()
+	query := `
+		INSERT INTO attachments (id, chat_id, uploader_id, kind, size, created_at)
+		VALUES (:id, :chat_id, :uploader_id, :kind, :size, :created_at)
+	`
+	if _, err := r.db.NamedExecContext(ctx, query, attachment); err != nil {
+		return "", err
+	}
+	return attachment.ID, nil
+}
+
+// GetByID returns the attachment, or nil if there is none
+func (r *attachmentRepository) GetByID(ctx context.Context, attachmentID string) (*models.Attachment, error) {
+	attachment := &models.Attachment{}
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE id = ?`
+	if err := r.db.GetContext(ctx, attachment, query, attachmentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// AddChunk records a stored chunk as the next piece of the upload, which has to
+// stand at offset, and completes the upload when all of it has been received.
+// It returns the updated attachment, or nil if it doesn't exist.
+func (r *attachmentRepository) AddChunk(ctx context.Context, attachmentID string, offset int64, chunk models.AttachmentChunk) (*models.Attachment, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	attachment := &models.Attachment{}
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE id = ? FOR UPDATE`
+	if err := tx.GetContext(ctx, attachment, query, attachmentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if attachment.Received != offset || attachment.CompletedAt != nil {
+		return nil, ErrChunkOffset
+	}
+
+	chunk.AttachmentID = attachmentID
+	chunk.Index = attachment.ChunkCount
+	insert := `
+		INSERT INTO attachment_chunks (attachment_id, chunk_index, storage_key, size)
+		VALUES (:attachment_id, :chunk_index, :storage_key, :size)
+	`
+	if _, err := tx.NamedExecContext(ctx, insert, chunk); err != nil {
+		return nil, err
+	}
+
+	attachment.Received += chunk.Size
+	attachment.ChunkCount++
+	if attachment.Received == attachment.Size {
+		now := time.Now().UTC().Truncate(time.Second)
+		attachment.CompletedAt = &now
+	}
+	update := `UPDATE attachments SET received = ?, chunk_count = ?, completed_at = ? WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, update, attachment.Received, attachment.ChunkCount, attachment.CompletedAt, attachmentID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// GetChunks returns the chunks of an attachment in upload order
+func (r *attachmentRepository) GetChunks(ctx context.Context, attachmentID string) ([]models.AttachmentChunk, error) {
+	query := `
+		SELECT attachment_id, chunk_index, storage_key, size
+		FROM attachment_chunks
+		WHERE attachment_id = ?
+		ORDER BY chunk_index ASC
+	`
+	chunks := make([]models.AttachmentChunk, 0)
+	if err := r.db.SelectContext(ctx, &chunks, query, attachmentID); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// GetOrphans returns attachments created before the given time that no message
+// references: abandoned uploads, uploads never sent, and those of deleted messages
+func (r *attachmentRepository) GetOrphans(ctx context.Context, createdBefore time.Time, limit int) ([]models.Attachment, error) {
+	query := `
+		SELECT ` + attachmentColumns + `
+		FROM attachments
+		WHERE message_id IS NULL AND created_at < ?
+		ORDER BY created_at ASC
+		LIMIT ?
+	`
+	attachments := make([]models.Attachment, 0)
+	if err := r.db.SelectContext(ctx, &attachments, query, createdBefore, limit); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// DeleteOrphan deletes the attachment if no message references it. deleteBlobs
+// is called with its chunks while the attachment is locked, so a message can't
+// reference it in the meantime; if it fails nothing is deleted. It reports
+// whether the attachment was deleted.
+func (r *attachmentRepository) DeleteOrphan(ctx context.Context, attachmentID string, deleteBlobs func(chunks []models.AttachmentChunk) error) (bool, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	lock := `SELECT id FROM attachments WHERE id = ? AND message_id IS NULL FOR UPDATE`
+	if err := tx.GetContext(ctx, &id, lock, attachmentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	chunks := make([]models.AttachmentChunk, 0)
+	query := `
+		SELECT attachment_id, chunk_index, storage_key, size
+		FROM attachment_chunks
+		WHERE attachment_id = ?
+	`
+	if err := tx.SelectContext(ctx, &chunks, query, attachmentID); err != nil {
+		return false, err
+	}
+	if err := deleteBlobs(chunks); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM attachments WHERE id = ?`, attachmentID); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"poshta/internal/domain/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type AuditRepository interface {
+	Record(ctx context.Context, event *models.AuditEvent) error
+}
+
+type auditRepository struct {
+	db *sqlx.DB
+}
+
+func NewAuditRepository(db *sqlx.DB) AuditRepository {
+	return &auditRepository{
+		db: db,
+	}
+}
+
+func (r *auditRepository) Record(ctx context.Context, event *models.AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (event_type, subject, ip, created_at)
+		VALUES (:event_type, :subject, :ip, :created_at)
+	`
+	result, err := r.db.NamedExecContext(ctx, query, event)
+	if err != nil {
+		return err
+	}
+	event.ID, err = result.LastInsertId()
+	return err
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type BlockRepository interface {
+	Block(ctx context.Context, blockerID, blockedID string) error
+	Unblock(ctx context.Context, blockerID, blockedID string) error
+	IsBlocked(ctx context.Context, blockerID, blockedID string) (bool, error)
+	BlockedAmong(ctx context.Context, userID string, otherIDs []string) ([]string, error)
+}
+
+type blockRepository struct {
+	db *sqlx.DB
+}
+
+func NewBlockRepository(db *sqlx.DB) BlockRepository {
+	return &blockRepository{
+		db: db,
+	}
+}
+
+// Block blocks the user. Blocking a user twice is not an error.
+func (r *blockRepository) Block(ctx context.Context, blockerID, blockedID string) error {
+	query := `
+		INSERT INTO user_blocks (blocker_id, blocked_id, created_at)
+		VALUES (?, ?, UTC_TIMESTAMP())
+		ON DUPLICATE KEY UPDATE blocker_id = blocker_id
+	`
+	_, err := r.db.ExecContext(ctx, query, blockerID, blockedID)
+	return err
+}
+
+func (r *blockRepository) Unblock(ctx context.Context, blockerID, blockedID string) error {
+	query := `DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?`
+	_, err := r.db.ExecContext(ctx, query, blockerID, blockedID)
+	return err
+}
+
+// IsBlocked reports whether the blocker blocked the other user
+func (r *blockRepository) IsBlocked(ctx context.Context, blockerID, blockedID string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?)`
+	if err := r.db.GetContext(ctx, &exists, query, blockerID, blockedID); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// BlockedAmong returns the users of otherIDs who blocked the user or whom the
+// user blocked
+func (r *blockRepository) BlockedAmong(ctx context.Context, userID string, otherIDs []string) ([]string, error) {
+	blocked := make([]string, 0)
+	if len(otherIDs) == 0 {
+		return blocked, nil
+	}
+
+	query, args, err := sqlx.In(`
+		SELECT blocker_id FROM user_blocks WHERE blocked_id = ? AND blocker_id IN (?)
+		UNION
+		SELECT blocked_id FROM user_blocks WHERE blocker_id = ? AND blocked_id IN (?)
+	`, userID, otherIDs, userID, otherIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.db.SelectContext(ctx, &blocked, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return blocked, nil
+}
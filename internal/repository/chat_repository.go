@@ -3,7 +3,6 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"poshta/internal/domain/models"
 
 	"github.com/google/uuid"
@@ -11,12 +10,21 @@ import (
 )
 
 type ChatRepository interface {
-	Create(ctx context.Context, chat *models.Chat) (string, error)
+	Create(ctx context.Context, chat *models.Chat, members []models.ChatMember) (string, error)
 	Delete(ctx context.Context, chatID string) (string, error)
 	GetByID(ctx context.Context, chatID string) (*models.Chat, error)
 	GetByUserID(ctx context.Context, userID string) ([]models.Chat, error)
 	GetByUsersID(ctx context.Context, user1ID, user2ID string) (*models.Chat, error)
-	GetMessages(ctx context.Context, chatID string) ([]models.Message, error)
+	GetMessages(ctx context.Context, chatID, userID, deviceID string, cursor models.MessageCursor) ([]models.Message, error)
+	UpdateSettings(ctx context.Context, chatID string, messageTTL int) error
+
+	AddMember(ctx context.Context, member *models.ChatMember) error
+	RemoveMember(ctx context.Context, chatID, userID string) error
+	LeaveGroup(ctx context.Context, chatID, userID string) error
+	UpdateMemberRole(ctx context.Context, chatID, userID, role string) error
+	GetMember(ctx context.Context, chatID, userID string) (*models.ChatMember, error)
+	GetMembers(ctx context.Context, chatID string) ([]models.ChatMember, error)
+	GetPartnerIDs(ctx context.Context, userID string) ([]string, error)
 }
 
 type chatRepository struct {
@@ -29,16 +37,17 @@ func NewChatRepository(db *sqlx.DB) ChatRepository {
 	}
 }
 
+const chatColumns = `c.id, c.type, c.title, c.avatar_url, COALESCE(c.user1_id, '') AS user1_id, COALESCE(c.user2_id, '') AS user2_id, c.message_ttl, c.created_at`
+
 // GetByID implements ChatRepository.
 func (c *chatRepository) GetByID(ctx context.Context, chatID string) (*models.Chat, error) {
 	query := `
-		SELECT id, user1_id, user2_id, created_at
-		FROM chats
-		WHERE id = ?	
+		SELECT ` + chatColumns + `
+		FROM chats c
+		WHERE c.id = ?	
 	`
-	row := c.db.QueryRowContext(ctx, query, chatID)
 	var chat models.Chat
-	if err := row.Scan(&chat.ID, &chat.User1ID, &chat.User2ID, &chat.CreatedAt); err != nil {
+	if err := c.db.GetContext(ctx, &chat, query, chatID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No chat found
 		}
@@ -48,119 +57,301 @@ func (c *chatRepository) GetByID(ctx context.Context, chatID string) (*models.Ch
 }
 
 
-// Create implements ChatRepository.
-func (c *chatRepository) Create(ctx context.Context, chat *models.Chat) (string, error) {
+// Create implements ChatRepository. The chat and its members are stored in one transaction.
+func (c *chatRepository) Create(ctx context.Context, chat *models.Chat, members []models.ChatMember) (string, error) {
 	chatID := uuid.New().String()
+
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO chats (id, user1_id, user2_id, created_at )
-		VALUES (?, ?, ?, NOW())
+		INSERT INTO chats (id, type, title, avatar_url, user1_id, user2_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, NOW())
 	`
-	_, err := c.db.ExecContext(ctx, query,  
+	_, err = tx.ExecContext(ctx, query,
 		chatID,
-		chat.User1ID, 
-		chat.User2ID)
-	
+		chat.Type,
+		chat.Title,
+		chat.AvatarURL,
+		nullableString(chat.User1ID),
+		nullableString(chat.User2ID))
 	if err != nil {
 		return "", err
 	}
 
+	memberQuery := `
+		INSERT INTO chat_members (chat_id, user_id, role, joined_at)
+		VALUES (?, ?, ?, NOW())
+	`
+	for _, member := range members {
+		if _, err := tx.ExecContext(ctx, memberQuery, chatID, member.UserID, member.Role); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
 	return chatID, nil
 }
 
-// Delete implements ChatRepository.
+// Delete implements ChatRepository. The messages of the chat are deleted with it.
 func (c *chatRepository) Delete(ctx context.Context, chatID string) (string, error) {
-	query := `
-		DELETE FROM chats
-		WHERE ID = ?
-	`
-	_, err := c.db.ExecContext(ctx, query, chatID)
-
+	tx, err := c.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return "", err
 	}
-	fmt.Print(chatID)
+	defer tx.Rollback()
+
+	if err := deleteChat(ctx, tx, chatID); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
 	return chatID, nil
+}
 
+// deleteChat removes the chat and its messages. messages.chat_id has no
+// ON DELETE CASCADE, so the messages have to go first.
+func deleteChat(ctx context.Context, tx *sqlx.Tx, chatID string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE chat_id = ?`, chatID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM chats WHERE id = ?`, chatID)
+	return err
 }
 
 
 // GetByUserID implements ChatRepository.
 func (c *chatRepository) GetByUserID(ctx context.Context, userID string) ([]models.Chat, error) {
 	query := `
-		SELECT id, user1_id, user2_id, created_at
-		FROM chats
-		WHERE user1_id = ? OR user2_id = ?
+		SELECT ` + chatColumns + `
+		FROM chats c
+		JOIN chat_members cm ON cm.chat_id = c.id
+		WHERE cm.user_id = ?
 	`
-	rows, err := c.db.QueryContext(ctx, query, userID, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	chats := make( []models.Chat, 0)
-	for rows.Next() {
-		var chat models.Chat
-		if err := rows.Scan(&chat.ID, &chat.User1ID, &chat.User2ID, &chat.CreatedAt); err != nil {
-			return nil, err
-		}
-		chats = append(chats, chat)
-	}
-
-	if err := rows.Err(); err != nil {
+	chats := make([]models.Chat, 0)
+	if err := c.db.SelectContext(ctx, &chats, query, userID); err != nil {
 		return nil, err
 	}
 
 	return chats, nil
 }
 
-// get chat by user1 and user2 id
-
+// GetByUsersID returns the direct chat between two users, or nil if there is none
 func (c *chatRepository) GetByUsersID(ctx context.Context, user1ID, user2ID string) (*models.Chat, error) {
 	query := `
-		SELECT id, user1_id, user2_id, created_at
-		FROM chats
-		WHERE (user1_id = ? AND user2_id = ?) OR (user1_id = ? AND user2_id = ?)
+		SELECT ` + chatColumns + `
+		FROM chats c
+		WHERE c.type = 'direct'
+		  AND ((c.user1_id = ? AND c.user2_id = ?) OR (c.user1_id = ? AND c.user2_id = ?))
 	`
-	row := c.db.QueryRowContext(ctx, query, user1ID, user2ID, user2ID, user1ID)
 	var chat models.Chat
-	if err := row.Scan(&chat.ID, &chat.User1ID, &chat.User2ID, &chat.CreatedAt); err != nil {
+	if err := c.db.GetContext(ctx, &chat, query, user1ID, user2ID, user2ID, user1ID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
 	return &chat, nil
 }
 
-func (c *chatRepository) GetMessages(ctx context.Context, chatID string) ([]models.Message, error) {
+// GetMessages returns a page of chat history in ascending ID order, with encrypted_key
+// set to the key wrapped for the given user, preferring the key for deviceID over
+// the account-level one
+func (c *chatRepository) GetMessages(ctx context.Context, chatID, userID, deviceID string, cursor models.MessageCursor) ([]models.Message, error) {
+    args := []interface{}{userID, deviceID, userID, deviceID, chatID, userID}
+    condition := ""
+    order := "DESC"
+    switch {
+    case cursor.After > 0:
+        condition = "AND m.id > ?"
+        order = "ASC"
+        args = append(args, cursor.After)
+    case cursor.Before > 0:
+        condition = "AND m.id < ?"
+        args = append(args, cursor.Before)
+    }
+    args = append(args, cursor.Limit)
+
     query := `
-        SELECT id, chat_id, sender_id, sender_name, content, created_at, readed
-        FROM messages
-        WHERE chat_id = ?
-        ORDER BY created_at ASC
+        SELECT m.id, m.chat_id, m.sender_id, u.username AS sender_name, m.content, m.created_at, m.expired_at, m.edited_at,
+            ` + messageReadColumn + `,
+            ` + messageKeyColumn + `,
+            ` + messageKeyVersionColumn + `,
+            ` + messageAttachmentsColumn + `
+        FROM messages m
+        ` + messageSenderJoin + `
+        WHERE m.chat_id = ? AND ` + messageNotExpired + ` AND ` + messageVisible + ` ` + condition + `
+        ORDER BY m.id ` + order + `
+        LIMIT ?
     `
-    rows, err := c.db.QueryContext(ctx, query, chatID)
-    if err != nil {
+    messages := make([]models.Message, 0, cursor.Limit)
+    if err := c.db.SelectContext(ctx, &messages, query, args...); err != nil {
         return nil, err
     }
-    defer rows.Close()
-    
-    messages := make([]models.Message, 0)
-    for rows.Next() {
-        var message models.Message
-        if err := rows.Scan(
-            &message.ID, 
-            &message.ChatID, 
-            &message.SenderID, 
-			&message.SenderName,
-            &message.Content, 
-            &message.CreatedAt,
-            &message.Readed,
-        ); err != nil {
-            return nil, err
+
+    // страницы "до" выбираются с конца, возвращаем их по возрастанию
+    if order == "DESC" {
+        for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+            messages[i], messages[j] = messages[j], messages[i]
         }
-        messages = append(messages, message)
-    }
-    
-    if err := rows.Err(); err != nil {
-        return nil, err
     }
-    
+
     return messages, nil
-}
\ No newline at end of file
+}
+
+// UpdateSettings implements ChatRepository.
+func (c *chatRepository) UpdateSettings(ctx context.Context, chatID string, messageTTL int) error {
+	query := `UPDATE chats SET message_ttl = ? WHERE id = ?`
+	_, err := c.db.ExecContext(ctx, query, messageTTL, chatID)
+	return err
+}
+
+// AddMember implements ChatRepository.
+func (c *chatRepository) AddMember(ctx context.Context, member *models.ChatMember) error {
+	query := `
+		INSERT INTO chat_members (chat_id, user_id, role, joined_at)
+		VALUES (?, ?, ?, NOW())
+	`
+	_, err := c.db.ExecContext(ctx, query, member.ChatID, member.UserID, member.Role)
+	return err
+}
+
+// RemoveMember implements ChatRepository.
+func (c *chatRepository) RemoveMember(ctx context.Context, chatID, userID string) error {
+	query := `DELETE FROM chat_members WHERE chat_id = ? AND user_id = ?`
+	_, err := c.db.ExecContext(ctx, query, chatID, userID)
+	return err
+}
+
+// LeaveGroup removes the user from a group chat in one transaction. When the
+// owner leaves, ownership passes to the longest-standing admin, or member if
+// there are no admins. The chat is deleted when its last member leaves.
+func (c *chatRepository) LeaveGroup(ctx context.Context, chatID, userID string) error {
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// блокируем участников чата, чтобы одновременные выходы не оставили группу без владельца
+	var members []models.ChatMember
+	query := `
+		SELECT chat_id, user_id, role, joined_at
+		FROM chat_members
+		WHERE chat_id = ?
+		ORDER BY joined_at ASC
+		FOR UPDATE
+	`
+	if err := tx.SelectContext(ctx, &members, query, chatID); err != nil {
+		return err
+	}
+
+	var leaving *models.ChatMember
+	remaining := make([]models.ChatMember, 0, len(members))
+	for i := range members {
+		if members[i].UserID == userID {
+			leaving = &members[i]
+			continue
+		}
+		remaining = append(remaining, members[i])
+	}
+	if leaving == nil {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM chat_members WHERE chat_id = ? AND user_id = ?`, chatID, userID); err != nil {
+		return err
+	}
+
+	switch {
+	case len(remaining) == 0:
+		if err := deleteChat(ctx, tx, chatID); err != nil {
+			return err
+		}
+	case leaving.Role == models.ChatRoleOwner:
+		successor := remaining[0]
+		for _, m := range remaining {
+			if m.Role == models.ChatRoleAdmin {
+				successor = m
+				break
+			}
+		}
+		query := `UPDATE chat_members SET role = ? WHERE chat_id = ? AND user_id = ?`
+		if _, err := tx.ExecContext(ctx, query, models.ChatRoleOwner, chatID, successor.UserID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateMemberRole implements ChatRepository.
+func (c *chatRepository) UpdateMemberRole(ctx context.Context, chatID, userID, role string) error {
+	query := `UPDATE chat_members SET role = ? WHERE chat_id = ? AND user_id = ?`
+	_, err := c.db.ExecContext(ctx, query, role, chatID, userID)
+	return err
+}
+
+// GetMember returns a single membership, or nil if the user is not in the chat
+func (c *chatRepository) GetMember(ctx context.Context, chatID, userID string) (*models.ChatMember, error) {
+	query := `
+		SELECT cm.chat_id, cm.user_id, u.username, cm.role, cm.joined_at
+		FROM chat_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.chat_id = ? AND cm.user_id = ?
+	`
+	var member models.ChatMember
+	if err := c.db.GetContext(ctx, &member, query, chatID, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetMembers returns chat members ordered by the time they joined
+func (c *chatRepository) GetMembers(ctx context.Context, chatID string) ([]models.ChatMember, error) {
+	query := `
+		SELECT cm.chat_id, cm.user_id, u.username, cm.role, cm.joined_at
+		FROM chat_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.chat_id = ?
+		ORDER BY cm.joined_at ASC
+	`
+	members := make([]models.ChatMember, 0)
+	if err := c.db.SelectContext(ctx, &members, query, chatID); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// GetPartnerIDs returns everyone who shares at least one chat with the user
+func (c *chatRepository) GetPartnerIDs(ctx context.Context, userID string) ([]string, error) {
+	query := `
+		SELECT DISTINCT other.user_id
+		FROM chat_members own
+		JOIN chat_members other ON other.chat_id = own.chat_id AND other.user_id <> own.user_id
+		WHERE own.user_id = ?
+	`
+	ids := make([]string, 0)
+	if err := c.db.SelectContext(ctx, &ids, query, userID); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// nullableString stores empty strings as NULL
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poshta/internal/domain/models"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrDuplicateContact is returned by Add when the user is already a contact
+var ErrDuplicateContact = errors.New("duplicate contact")
+
+type ContactRepository interface {
+	Add(ctx context.Context, contact *models.Contact) error
+	Get(ctx context.Context, ownerID, contactID string) (*models.Contact, error)
+	List(ctx context.Context, ownerID string) ([]models.Contact, error)
+	UpdateNickname(ctx context.Context, ownerID, contactID, nickname string) error
+	Remove(ctx context.Context, ownerID, contactID string) (bool, error)
+	IsContact(ctx context.Context, ownerID, contactID string) (bool, error)
+}
+
+type contactRepository struct {
+	db *sqlx.DB
+}
+
+func NewContactRepository(db *sqlx.DB) ContactRepository {
+	return &contactRepository{
+		db: db,
+	}
+}
+
+func (r *contactRepository) Add(ctx context.Context, contact *models.Contact) error {
+	query := `
+		INSERT INTO contacts (owner_id, contact_id, nickname, created_at)
+		VALUES (:owner_id, :contact_id, :nickname, :created_at)
+	`
+	_, err := r.db.NamedExecContext(ctx, query, contact)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return ErrDuplicateContact
+	}
+	return err
+}
+
+// Get returns the contact with the current username and display name, or nil if there is none
+func (r *contactRepository) Get(ctx context.Context, ownerID, contactID string) (*models.Contact, error) {
+	contact := &models.Contact{}
+	query := `
+		SELECT c.owner_id, c.contact_id, u.username, u.display_name, c.nickname, c.created_at
+		FROM contacts c
+		JOIN users u ON u.id = c.contact_id
+		WHERE c.owner_id = ? AND c.contact_id = ?
+	`
+	if err := r.db.GetContext(ctx, contact, query, ownerID, contactID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return contact, nil
+}
+
+// List returns the contacts of the user ordered by nickname, falling back to the username
+func (r *contactRepository) List(ctx context.Context, ownerID string) ([]models.Contact, error) {
+	query := `
+		SELECT c.owner_id, c.contact_id, u.username, u.display_name, c.nickname, c.created_at
+		FROM contacts c
+		JOIN users u ON u.id = c.contact_id
+		WHERE c.owner_id = ?
+		ORDER BY COALESCE(NULLIF(c.nickname, ''), u.username)
+	`
+	contacts := make([]models.Contact, 0)
+	if err := r.db.SelectContext(ctx, &contacts, query, ownerID); err != nil {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+func (r *contactRepository) UpdateNickname(ctx context.Context, ownerID, contactID, nickname string) error {
+	query := `UPDATE contacts SET nickname = ? WHERE owner_id = ? AND contact_id = ?`
+	_, err := r.db.ExecContext(ctx, query, nickname, ownerID, contactID)
+	return err
+}
+
+// Remove deletes the contact. It reports false if there was none.
+func (r *contactRepository) Remove(ctx context.Context, ownerID, contactID string) (bool, error) {
+	query := `DELETE FROM contacts WHERE owner_id = ? AND contact_id = ?`
+	result, err := r.db.ExecContext(ctx, query, ownerID, contactID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// IsContact reports whether the owner added the other user as a contact
+func (r *contactRepository) IsContact(ctx context.Context, ownerID, contactID string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM contacts WHERE owner_id = ? AND contact_id = ?)`
+	if err := r.db.GetContext(ctx, &exists, query, ownerID, contactID); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
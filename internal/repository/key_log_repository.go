@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poshta/internal/domain/models"
+	"poshta/pkg/keylog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type KeyLogRepository interface {
+	Size(ctx context.Context) (uint64, error)
+	LeafHashes(ctx context.Context, from, to uint64) ([][]byte, error)
+	GetCurrentEntry(ctx context.Context, userID string) (*models.KeyLogEntry, error)
+	AppendMissing(ctx context.Context) (int, error)
+}
+
+type keyLogRepository struct {
+	db *sqlx.DB
+}
+
+func NewKeyLogRepository(db *sqlx.DB) KeyLogRepository {
+	return &keyLogRepository{
+		db: db,
+	}
+}
+
+// Size implements KeyLogRepository.
+func (r *keyLogRepository) Size(ctx context.Context) (uint64, error) {
+	var size uint64
+	err := r.db.GetContext(ctx, &size, `SELECT size FROM key_log_size WHERE id = 1`)
+	return size, err
+}
+
+// LeafHashes returns the hashes of the leaves from index from up to, not including, to
+func (r *keyLogRepository) LeafHashes(ctx context.Context, from, to uint64) ([][]byte, error) {
+	query := `
+		SELECT leaf_hash
+		FROM key_log
+		WHERE leaf_index >= ? AND leaf_index < ?
+		ORDER BY leaf_index ASC
+	`
+	hashes := make([][]byte, 0, to-from)
+	if err := r.db.SelectContext(ctx, &hashes, query, from, to); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// GetCurrentEntry returns the leaf of the user's current public key, or nil if
+// the user doesn't exist
+func (r *keyLogRepository) GetCurrentEntry(ctx context.Context, userID string) (*models.KeyLogEntry, error) {
+	entry := &models.KeyLogEntry{}
+	query := `
+		SELECT l.leaf_index, l.user_id, l.key_version, l.public_key, l.leaf_hash, l.logged_at
+		FROM key_log l
+		JOIN users u ON u.id = l.user_id AND u.public_key_version = l.key_version
+		WHERE l.user_id = ?
+	`
+	if err := r.db.GetContext(ctx, entry, query, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+// AppendMissing appends the public keys stored before the log existed, oldest
+// first. It returns the number of appended leaves.
+func (r *keyLogRepository) AppendMissing(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// блокировка размера не даёт другим инстансам дописывать лог параллельно
+	var size uint64
+	if err := tx.GetContext(ctx, &size, `SELECT size FROM key_log_size WHERE id = 1 FOR UPDATE`); err != nil {
+		return 0, err
+	}
+
+	query := `
+		SELECT k.user_id, k.version, k.public_key
+		FROM user_public_keys k
+		LEFT JOIN key_log l ON l.user_id = k.user_id AND l.key_version = k.version
+		WHERE l.leaf_index IS NULL
+		ORDER BY k.created_at ASC, k.user_id ASC, k.version ASC
+	`
+	missing := make([]models.PublicKey, 0)
+	if err := tx.SelectContext(ctx, &missing, query); err != nil {
+		return 0, err
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	for _, key := range missing {
+		if err := insertKeyLogEntry(ctx, tx, size, key.UserID, key.Version, key.PublicKey); err != nil {
+			return 0, err
+		}
+		size++
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE key_log_size SET size = ? WHERE id = 1`, size); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(missing), nil
+}
+
+// appendKeyLog records a new public key version in the log as part of the
+// transaction that stores it. The size row stays locked until the transaction ends.
+func appendKeyLog(ctx context.Context, tx *sqlx.Tx, userID string, version int, publicKey string) error {
+	var size uint64
+	if err := tx.GetContext(ctx, &size, `SELECT size FROM key_log_size WHERE id = 1 FOR UPDATE`); err != nil {
+		return err
+	}
+	if err := insertKeyLogEntry(ctx, tx, size, userID, version, publicKey); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE key_log_size SET size = size + 1 WHERE id = 1`)
+	return err
+}
+
+func insertKeyLogEntry(ctx context.Context, tx *sqlx.Tx, index uint64, userID string, version int, publicKey string) error {
+	entry := keylog.Entry{
+		UserID:     userID,
+		KeyVersion: uint32(version),
+		PublicKey:  publicKey,
+		Timestamp:  time.Now().Unix(),
+	}
+	query := `
+		INSERT INTO key_log (leaf_index, user_id, key_version, public_key, leaf_hash, logged_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	_, err := tx.ExecContext(ctx, query, index, entry.UserID, version, entry.PublicKey, entry.LeafHash(), entry.Timestamp)
+	return err
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poshta/internal/domain/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type KeyVerificationRepository interface {
+	Get(ctx context.Context, verifierID, userID string) (*models.KeyVerification, error)
+	Verify(ctx context.Context, verifierID, userID string, keyVersion int) error
+	Remove(ctx context.Context, verifierID, userID string) error
+}
+
+type keyVerificationRepository struct {
+	db *sqlx.DB
+}
+
+func NewKeyVerificationRepository(db *sqlx.DB) KeyVerificationRepository {
+	return &keyVerificationRepository{
+		db: db,
+	}
+}
+
+// Get returns the verification state of the user's key, or nil if the verifier never verified it
+func (r *keyVerificationRepository) Get(ctx context.Context, verifierID, userID string) (*models.KeyVerification, error) {
+	verification := &models.KeyVerification{}
+	query := `
+		SELECT verifier_id, user_id, key_version, verified_at, key_changed_at
+		FROM key_verifications
+		WHERE verifier_id = ? AND user_id = ?
+	`
+	if err := r.db.GetContext(ctx, verification, query, verifierID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return verification, nil
+}
+
+// Verify marks a version of the user's key as verified and clears the key change flag
+func (r *keyVerificationRepository) Verify(ctx context.Context, verifierID, userID string, keyVersion int) error {
+	query := `
+		INSERT INTO key_verifications (verifier_id, user_id, key_version, verified_at, key_changed_at)
+		VALUES (?, ?, ?, UTC_TIMESTAMP(), NULL)
+		ON DUPLICATE KEY UPDATE key_version = VALUES(key_version), verified_at = VALUES(verified_at), key_changed_at = NULL
+	`
+	_, err := r.db.ExecContext(ctx, query, verifierID, userID, keyVersion)
+	return err
+}
+
+// Remove forgets the verification, including a pending key change flag
+func (r *keyVerificationRepository) Remove(ctx context.Context, verifierID, userID string) error {
+	query := `DELETE FROM key_verifications WHERE verifier_id = ? AND user_id = ?`
+	_, err := r.db.ExecContext(ctx, query, verifierID, userID)
+	return err
+}
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poshta/internal/domain/models"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// LoginAttemptRepository stores failed login counters. The in-memory store
+// suits a single instance; several instances need the SQL store to share counters.
+type LoginAttemptRepository interface {
+	Get(ctx context.Context, key string) (*models.LoginAttempt, error)
+	RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Unlock(ctx context.Context, key string, now time.Time) (bool, error)
+	ExpiredLocks(ctx context.Context, now time.Time) ([]string, error)
+	Reset(ctx context.Context, key string) error
+	DeleteStale(ctx context.Context, before time.Time) (int64, error)
+}
+
+type loginAttemptRepository struct {
+	db *sqlx.DB
+}
+
+func NewLoginAttemptRepository(db *sqlx.DB) LoginAttemptRepository {
+	return &loginAttemptRepository{
+		db: db,
+	}
+}
+
+// Get returns the counter of the key, or nil if there is none
+func (r *loginAttemptRepository) Get(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	attempt := &models.LoginAttempt{}
+	query := `SELECT attempt_key, failures, last_failure_at, locked_until FROM login_attempts WHERE attempt_key = ?`
+	if err := r.db.GetContext(ctx, attempt, query, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return attempt, nil
+}
+
+// RecordFailure counts a failed login. The count starts over when the previous
+// failure is older than window.
+func (r *loginAttemptRepository) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	// failures вычисляется раньше, чем перезаписывается last_failure_at
+	query := `
+		INSERT INTO login_attempts (attempt_key, failures, last_failure_at)
+		VALUES (?, 1, ?)
+		ON DUPLICATE KEY UPDATE
+			failures = IF(last_failure_at < ?, 1, failures + 1),
+			last_failure_at = VALUES(last_failure_at)
+	`
+	if _, err := r.db.ExecContext(ctx, query, key, now, now.Add(-window)); err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, key)
+}
+
+func (r *loginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `UPDATE login_attempts SET locked_until = ? WHERE attempt_key = ?`
+	_, err := r.db.ExecContext(ctx, query, until, key)
+	return err
+}
+
+// Unlock removes the counter of a key whose lockout is over. It reports false
+// if the key is not locked or the lockout is still running, e.g. when another
+// instance unlocked it first.
+func (r *loginAttemptRepository) Unlock(ctx context.Context, key string, now time.Time) (bool, error) {
+	query := `DELETE FROM login_attempts WHERE attempt_key = ? AND locked_until IS NOT NULL AND locked_until <= ?`
+	result, err := r.db.ExecContext(ctx, query, key, now)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// ExpiredLocks returns the keys whose lockout is over but which were not unlocked yet
+func (r *loginAttemptRepository) ExpiredLocks(ctx context.Context, now time.Time) ([]string, error) {
+	var keys []string
+	query := `SELECT attempt_key FROM login_attempts WHERE locked_until IS NOT NULL AND locked_until <= ?`
+	if err := r.db.SelectContext(ctx, &keys, query, now); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *loginAttemptRepository) Reset(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE attempt_key = ?`, key)
+	return err
+}
+
+// DeleteStale removes unlocked counters whose last failure was before the given time
+func (r *loginAttemptRepository) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM login_attempts WHERE last_failure_at < ? AND locked_until IS NULL`
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// memoryLoginAttemptRepository keeps the counters in process memory
+type memoryLoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempt
+}
+
+func NewMemoryLoginAttemptRepository() LoginAttemptRepository {
+	return &memoryLoginAttemptRepository{
+		attempts: make(map[string]models.LoginAttempt),
+	}
+}
+
+func (r *memoryLoginAttemptRepository) Get(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempt, ok := r.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	return &attempt, nil
+}
+
+func (r *memoryLoginAttemptRepository) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempt, ok := r.attempts[key]
+	if !ok || attempt.LastFailureAt.Before(now.Add(-window)) {
+		attempt.Key = key
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	r.attempts[key] = attempt
+	return &attempt, nil
+}
+
+func (r *memoryLoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if attempt, ok := r.attempts[key]; ok {
+		attempt.LockedUntil = &until
+		r.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) Unlock(ctx context.Context, key string, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempt, ok := r.attempts[key]
+	if !ok || attempt.LockedUntil == nil || attempt.LockedUntil.After(now) {
+		return false, nil
+	}
+	delete(r.attempts, key)
+	return true, nil
+}
+
+func (r *memoryLoginAttemptRepository) ExpiredLocks(ctx context.Context, now time.Time) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var keys []string
+	for key, attempt := range r.attempts {
+		if attempt.LockedUntil != nil && !attempt.LockedUntil.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (r *memoryLoginAttemptRepository) Reset(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, key)
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for key, attempt := range r.attempts {
+		if attempt.LockedUntil == nil && attempt.LastFailureAt.Before(before) {
+			delete(r.attempts, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
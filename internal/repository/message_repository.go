@@ -2,17 +2,89 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"poshta/internal/domain/models"
-	
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 )
 
+// mysqlDuplicateEntry is the MySQL error number for a unique key violation
+const mysqlDuplicateEntry = 1062
+
+// ErrDuplicateMessage is returned by Create, together with the ID of the
+// existing message, when the sender already sent a message with the same
+// client_msg_id to the chat
+var ErrDuplicateMessage = errors.New("duplicate message")
+
+// ErrMessageGone is returned by Edit when the message was deleted in the meantime
+var ErrMessageGone = errors.New("message no longer exists")
+
+// ErrAttachmentUnavailable is returned by Create when an attachment is not a
+// complete, unreferenced upload of the sender in the message's chat
+var ErrAttachmentUnavailable = errors.New("attachment is not available")
+
+// messageReadColumn reports whether message m has been read by any other member of its chat
+const messageReadColumn = `EXISTS (
+		SELECT 1
+		FROM chat_read_positions rp
+		WHERE rp.chat_id = m.chat_id AND rp.user_id <> m.sender_id AND rp.last_read_message_id >= m.id
+	) AS readed`
+
+// messageSenderJoin joins the sender u of message m, whose current username is
+// returned as sender_name
+const messageSenderJoin = `JOIN users u ON u.id = m.sender_id`
+
+// messageNotExpired filters out disappearing messages m whose time is up, even
+// before the sweeper has deleted them
+const messageNotExpired = `(m.expired_at IS NULL OR m.expired_at > UTC_TIMESTAMP())`
+
+// messageVisible filters out messages m withheld from everyone but their sender,
+// the user (argument) the messages are selected for
+const messageVisible = `(m.withheld = FALSE OR m.sender_id = ?)`
+
 type MessageRepository interface {
-	Create(ctx context.Context, message *models.Message) (int64, error)
+	Create(ctx context.Context, message *models.Message, keys []models.MessageKey) (int64, error)
 	GetByID(ctx context.Context, messageID int64) (*models.Message, error)
-	Delete(ctx context.Context, messsageID int64) ( error)
+	GetKeys(ctx context.Context, messageID int64) ([]models.MessageKey, error)
+	Delete(ctx context.Context, messsageID int64) (*models.MessageEvent, error)
+	Edit(ctx context.Context, messageID int64, content, encryptedKey string, keys []models.MessageKey, editedAt time.Time) (*models.MessageEvent, error)
+	GetForUser(ctx context.Context, messageID int64, userID, deviceID string) (*models.Message, error)
+	GetRevisions(ctx context.Context, messageID int64, userID, deviceID string) ([]models.MessageRevision, error)
+	GetSince(ctx context.Context, userID, deviceID string, afterID int64, limit int) ([]models.Message, error)
+	GetEventsSince(ctx context.Context, userID string, afterEventID int64, limit int) ([]models.MessageEvent, error)
+	DeleteExpired(ctx context.Context, limit int) ([]models.MessageEvent, error)
 }
 
+// messageKeyColumn selects the key of message m wrapped for a user (first argument),
+// preferring the key for a device (second argument) over the account-level one
+const messageKeyColumn = `COALESCE((
+		SELECT mk.encrypted_key
+		FROM message_keys mk
+		WHERE mk.message_id = m.id AND mk.recipient_id = ? AND mk.device_id IN (?, '')
+		ORDER BY mk.device_id = '' ASC
+		LIMIT 1
+	), '') AS encrypted_key`
+
+// messageKeyVersionColumn selects the public key version of the key chosen by
+// messageKeyColumn, with the same arguments
+const messageKeyVersionColumn = `COALESCE((
+		SELECT mk.key_version
+		FROM message_keys mk
+		WHERE mk.message_id = m.id AND mk.recipient_id = ? AND mk.device_id IN (?, '')
+		ORDER BY mk.device_id = '' ASC
+		LIMIT 1
+	), 0) AS key_version`
+
+// messageAttachmentsColumn selects the IDs of the attachments of message m
+const messageAttachmentsColumn = `(
+		SELECT GROUP_CONCAT(a.id ORDER BY a.id)
+		FROM attachments a
+		WHERE a.message_id = m.id
+	) AS attachment_ids`
+
 type messageRepository struct {
 	db *sqlx.DB
 }
@@ -23,43 +95,376 @@ func NewMessageRepository(db *sqlx.DB) MessageRepository {
 	}
 }
 
-func (m *messageRepository) Create(ctx context.Context, message *models.Message) (int64, error) {
+// Create stores the message together with its per-recipient wrapped keys and
+// links its attachments, see ErrAttachmentUnavailable.
+// Retries with the same client_msg_id are not stored twice, see ErrDuplicateMessage;
+// ID, CreatedAt and ExpiredAt of message are then set to those of the stored one.
+func (m *messageRepository) Create(ctx context.Context, message *models.Message, keys []models.MessageKey) (int64, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO messages (chat_id, sender_id, sender_name, content, encrypted_key, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
+		INSERT INTO messages (chat_id, sender_id, sender_name, content, encrypted_key, client_msg_id, created_at, expired_at, withheld)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	// get sender name from user_id from user repository
-	
-	result, err := m.db.ExecContext(ctx, query, message.ChatID, message.SenderID, message.SenderName, message.Content, message.EncryptedKey, message.CreatedAt)
+	result, err := tx.ExecContext(ctx, query, message.ChatID, message.SenderID, message.SenderName, message.Content, message.EncryptedKey, nullableString(message.ClientMsgID), message.CreatedAt, message.ExpiredAt, message.Withheld)
 	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if message.ClientMsgID != "" && errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+			// истёкшие, но ещё не удалённые сообщения тоже считаются
+			existing := `SELECT id, created_at, expired_at FROM messages WHERE sender_id = ? AND chat_id = ? AND client_msg_id = ?`
+			row := m.db.QueryRowContext(ctx, existing, message.SenderID, message.ChatID, message.ClientMsgID)
+			if err := row.Scan(&message.ID, &message.CreatedAt, &message.ExpiredAt); err != nil {
+				return 0, err
+			}
+			return message.ID, ErrDuplicateMessage
+		}
 		return 0, err
 	}
 	messageID, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
+
+	keyQuery := `
+		INSERT INTO message_keys (message_id, recipient_id, device_id, encrypted_key, key_version)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	for _, key := range keys {
+		if _, err := tx.ExecContext(ctx, keyQuery, messageID, key.RecipientID, key.DeviceID, key.EncryptedKey, key.KeyVersion); err != nil {
+			return 0, err
+		}
+	}
+
+	// вложение может принадлежать только одному сообщению
+	attachQuery := `
+		UPDATE attachments
+		SET message_id = ?
+		WHERE id = ? AND chat_id = ? AND uploader_id = ? AND message_id IS NULL AND completed_at IS NOT NULL
+	`
+	for _, attachmentID := range message.AttachmentIDs {
+		result, err := tx.ExecContext(ctx, attachQuery, messageID, attachmentID, message.ChatID, message.SenderID)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return 0, err
+		} else if n == 0 {
+			return 0, ErrAttachmentUnavailable
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
 	return messageID, nil
 }
 
 
 func (m *messageRepository) GetByID(ctx context.Context, messageID int64) (*models.Message, error) {
 	query := `
-		SELECT id, chat_id, sender_id, sender_name, content, created_at, encrypted_key
-		FROM messages
-		WHERE id = ?	
+		SELECT m.id, m.chat_id, m.sender_id, u.username, m.content, m.created_at, m.expired_at, m.edited_at, m.encrypted_key, COALESCE(m.client_msg_id, ''), m.withheld,
+			` + messageAttachmentsColumn + `
+		FROM messages m
+		` + messageSenderJoin + `
+		WHERE m.id = ? AND ` + messageNotExpired + `
 	`
 	row := m.db.QueryRowContext(ctx, query, messageID)
 	var message models.Message
-	if err := row.Scan(&message.ID, &message.ChatID, &message.SenderID, &message.SenderName, &message.Content, &message.CreatedAt, &message.EncryptedKey); err != nil {
+	if err := row.Scan(&message.ID, &message.ChatID, &message.SenderID, &message.SenderName, &message.Content, &message.CreatedAt, &message.ExpiredAt, &message.EditedAt, &message.EncryptedKey, &message.ClientMsgID, &message.Withheld, &message.AttachmentIDs); err != nil {
 		return nil, err // Other error
 	}
 	return &message, nil
 }
 
-func (m *messageRepository) Delete(ctx context.Context, messageID int64) error {
+// GetKeys returns all wrapped keys of a message
+func (m *messageRepository) GetKeys(ctx context.Context, messageID int64) ([]models.MessageKey, error) {
+	query := `
+		SELECT message_id, recipient_id, device_id, encrypted_key, key_version
+		FROM message_keys
+		WHERE message_id = ?
+	`
+	keys := make([]models.MessageKey, 0)
+	if err := m.db.SelectContext(ctx, &keys, query, messageID); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Delete removes the message and records a deletion event for offline clients
+func (m *messageRepository) Delete(ctx context.Context, messageID int64) (*models.MessageEvent, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var message models.Message
+	if err := tx.GetContext(ctx, &message, `SELECT id, chat_id, sender_id, withheld FROM messages WHERE id = ?`, messageID); err != nil {
+		return nil, err
+	}
+
 	query := `
 		DELETE FROM messages WHERE id = ?
 		`
-	_, err := m.db.ExecContext(ctx, query, messageID)
-	return err
-}
\ No newline at end of file
+	if _, err := tx.ExecContext(ctx, query, messageID); err != nil {
+		return nil, err
+	}
+
+	event, err := insertMessageEvent(ctx, tx, &message, models.MessageEventDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// Edit replaces the content and wrapped keys of the message and records an edit
+// event for offline clients. The replaced content is kept as a revision together
+// with the keys it was wrapped with.
+func (m *messageRepository) Edit(ctx context.Context, messageID int64, content, encryptedKey string, keys []models.MessageKey, editedAt time.Time) (*models.MessageEvent, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var current struct {
+		models.Message
+		WrittenAt time.Time `db:"written_at"`
+	}
+	lock := `SELECT id, chat_id, sender_id, content, withheld, COALESCE(edited_at, created_at) AS written_at FROM messages WHERE id = ? FOR UPDATE`
+	if err := tx.GetContext(ctx, &current, lock, messageID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMessageGone
+		}
+		return nil, err
+	}
+
+	revision := `
+		INSERT INTO message_revisions (message_id, content, created_at, replaced_at)
+		VALUES (?, ?, ?, ?)
+	`
+	result, err := tx.ExecContext(ctx, revision, messageID, current.Content, current.WrittenAt, editedAt)
+	if err != nil {
+		return nil, err
+	}
+	revisionID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	// старые ключи переезжают в ревизию, иначе её нельзя будет расшифровать
+	copyKeys := `
+		INSERT INTO message_revision_keys (revision_id, recipient_id, device_id, encrypted_key, key_version)
+		SELECT ?, recipient_id, device_id, encrypted_key, key_version
+		FROM message_keys
+		WHERE message_id = ?
+	`
+	if _, err := tx.ExecContext(ctx, copyKeys, revisionID, messageID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM message_keys WHERE message_id = ?`, messageID); err != nil {
+		return nil, err
+	}
+
+	keyQuery := `
+		INSERT INTO message_keys (message_id, recipient_id, device_id, encrypted_key, key_version)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	for _, key := range keys {
+		if _, err := tx.ExecContext(ctx, keyQuery, messageID, key.RecipientID, key.DeviceID, key.EncryptedKey, key.KeyVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	update := `UPDATE messages SET content = ?, encrypted_key = ?, edited_at = ? WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, update, content, encryptedKey, editedAt, messageID); err != nil {
+		return nil, err
+	}
+
+	event, err := insertMessageEvent(ctx, tx, &current.Message, models.MessageEventEdited)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// GetForUser returns the message with the key wrapped for the user's device, or
+// nil if it doesn't exist, has expired or is withheld from the user
+func (m *messageRepository) GetForUser(ctx context.Context, messageID int64, userID, deviceID string) (*models.Message, error) {
+	query := `
+		SELECT m.id, m.chat_id, m.sender_id, u.username AS sender_name, m.content, m.created_at, m.expired_at, m.edited_at,
+			` + messageReadColumn + `,
+			` + messageKeyColumn + `,
+			` + messageKeyVersionColumn + `,
+			` + messageAttachmentsColumn + `
+		FROM messages m
+		` + messageSenderJoin + `
+		WHERE m.id = ? AND ` + messageNotExpired + ` AND ` + messageVisible + `
+	`
+	message := &models.Message{}
+	if err := m.db.GetContext(ctx, message, query, userID, deviceID, userID, deviceID, messageID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+// revisionKeyColumn selects the key of revision r wrapped for a user (first
+// argument), preferring the key for a device (second argument), like messageKeyColumn
+const revisionKeyColumn = `COALESCE((
+		SELECT rk.encrypted_key
+		FROM message_revision_keys rk
+		WHERE rk.revision_id = r.id AND rk.recipient_id = ? AND rk.device_id IN (?, '')
+		ORDER BY rk.device_id = '' ASC
+		LIMIT 1
+	), '') AS encrypted_key`
+
+// revisionKeyVersionColumn selects the public key version of the key chosen by
+// revisionKeyColumn, with the same arguments
+const revisionKeyVersionColumn = `COALESCE((
+		SELECT rk.key_version
+		FROM message_revision_keys rk
+		WHERE rk.revision_id = r.id AND rk.recipient_id = ? AND rk.device_id IN (?, '')
+		ORDER BY rk.device_id = '' ASC
+		LIMIT 1
+	), 0) AS key_version`
+
+// GetRevisions returns the replaced versions of the message, oldest first, with
+// the keys wrapped for the user's device
+func (m *messageRepository) GetRevisions(ctx context.Context, messageID int64, userID, deviceID string) ([]models.MessageRevision, error) {
+	query := `
+		SELECT r.id, r.message_id, r.content, r.created_at, r.replaced_at,
+			` + revisionKeyColumn + `,
+			` + revisionKeyVersionColumn + `
+		FROM message_revisions r
+		WHERE r.message_id = ?
+		ORDER BY r.id ASC
+	`
+	revisions := make([]models.MessageRevision, 0)
+	if err := m.db.SelectContext(ctx, &revisions, query, userID, deviceID, userID, deviceID, messageID); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetSince returns messages newer than afterID from every chat the user is a member of
+func (m *messageRepository) GetSince(ctx context.Context, userID, deviceID string, afterID int64, limit int) ([]models.Message, error) {
+	query := `
+		SELECT m.id, m.chat_id, m.sender_id, u.username AS sender_name, m.content, m.created_at, m.expired_at, m.edited_at,
+			` + messageReadColumn + `,
+			` + messageKeyColumn + `,
+			` + messageKeyVersionColumn + `,
+			` + messageAttachmentsColumn + `
+		FROM messages m
+		` + messageSenderJoin + `
+		JOIN chat_members cm ON cm.chat_id = m.chat_id AND cm.user_id = ?
+		WHERE m.id > ? AND ` + messageNotExpired + ` AND ` + messageVisible + `
+		ORDER BY m.id ASC
+		LIMIT ?
+	`
+	messages := make([]models.Message, 0)
+	if err := m.db.SelectContext(ctx, &messages, query, userID, deviceID, userID, deviceID, userID, afterID, userID, limit); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// GetEventsSince returns message events newer than afterEventID from every chat
+// the user is a member of, leaving out events of messages withheld from the user
+func (m *messageRepository) GetEventsSince(ctx context.Context, userID string, afterEventID int64, limit int) ([]models.MessageEvent, error) {
+	query := `
+		SELECT e.id, e.chat_id, e.message_id, e.type, e.created_at, e.visible_to
+		FROM message_events e
+		JOIN chat_members cm ON cm.chat_id = e.chat_id AND cm.user_id = ?
+		WHERE e.id > ? AND (e.visible_to = '' OR e.visible_to = ?)
+		ORDER BY e.id ASC
+		LIMIT ?
+	`
+	events := make([]models.MessageEvent, 0)
+	if err := m.db.SelectContext(ctx, &events, query, userID, afterEventID, userID, limit); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// DeleteExpired hard-deletes up to limit expired messages and records a deletion event for each
+func (m *messageRepository) DeleteExpired(ctx context.Context, limit int) ([]models.MessageEvent, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var expired []models.Message
+	query := `
+		SELECT id, chat_id, sender_id, withheld
+		FROM messages
+		WHERE expired_at <= UTC_TIMESTAMP()
+		ORDER BY expired_at ASC
+		LIMIT ?
+		FOR UPDATE
+	`
+	if err := tx.SelectContext(ctx, &expired, query, limit); err != nil {
+		return nil, err
+	}
+
+	events := make([]models.MessageEvent, 0, len(expired))
+	for i := range expired {
+		msg := &expired[i]
+		if _, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE id = ?`, msg.ID); err != nil {
+			return nil, err
+		}
+		event, err := insertMessageEvent(ctx, tx, msg, models.MessageEventDeleted)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// insertMessageEvent records an event of the message. Events of a withheld
+// message are visible only to its sender, like the message itself.
+func insertMessageEvent(ctx context.Context, tx *sqlx.Tx, message *models.Message, eventType string) (*models.MessageEvent, error) {
+	event := &models.MessageEvent{
+		ChatID:    message.ChatID,
+		MessageID: message.ID,
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+	}
+	if message.Withheld {
+		event.VisibleTo = message.SenderID
+	}
+
+	query := `
+		INSERT INTO message_events (chat_id, message_id, type, created_at, visible_to)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	result, err := tx.ExecContext(ctx, query, event.ChatID, event.MessageID, event.Type, event.CreatedAt, event.VisibleTo)
+	if err != nil {
+		return nil, err
+	}
+	event.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poshta/internal/domain/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PrekeyRepository interface {
+	GetIdentityKey(ctx context.Context, userID string) (*models.IdentityKey, error)
+	SetIdentityKey(ctx context.Context, key *models.IdentityKey) error
+	GetSignedPrekey(ctx context.Context, userID string) (*models.SignedPrekey, error)
+	SetSignedPrekey(ctx context.Context, prekey *models.SignedPrekey) error
+	AddOneTimePrekeys(ctx context.Context, prekeys []models.OneTimePrekey) error
+	CountOneTimePrekeys(ctx context.Context, userID string) (int, error)
+	TakeOneTimePrekey(ctx context.Context, userID string) (*models.OneTimePrekey, int, error)
+}
+
+type prekeyRepository struct {
+	db *sqlx.DB
+}
+
+func NewPrekeyRepository(db *sqlx.DB) PrekeyRepository {
+	return &prekeyRepository{
+		db: db,
+	}
+}
+
+// GetIdentityKey returns the identity key of the user, or nil if none was uploaded
+func (r *prekeyRepository) GetIdentityKey(ctx context.Context, userID string) (*models.IdentityKey, error) {
+	key := &models.IdentityKey{}
+	query := `SELECT user_id, identity_key, created_at FROM identity_keys WHERE user_id = ?`
+	if err := r.db.GetContext(ctx, key, query, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// SetIdentityKey stores the identity key of the user. Replacing it deletes the
+// signed and one-time prekeys, which belonged to the old identity.
+func (r *prekeyRepository) SetIdentityKey(ctx context.Context, key *models.IdentityKey) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM signed_prekeys WHERE user_id = ?`, key.UserID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM one_time_prekeys WHERE user_id = ?`, key.UserID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO identity_keys (user_id, identity_key, created_at)
+		VALUES (:user_id, :identity_key, :created_at)
+		ON DUPLICATE KEY UPDATE identity_key = VALUES(identity_key), created_at = VALUES(created_at)
+	`
+	if _, err := tx.NamedExecContext(ctx, query, key); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSignedPrekey returns the current signed prekey of the user, or nil if there is none
+func (r *prekeyRepository) GetSignedPrekey(ctx context.Context, userID string) (*models.SignedPrekey, error) {
+	prekey := &models.SignedPrekey{}
+	query := `SELECT user_id, key_id, public_key, signature, created_at FROM signed_prekeys WHERE user_id = ?`
+	if err := r.db.GetContext(ctx, prekey, query, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return prekey, nil
+}
+
+// SetSignedPrekey replaces the signed prekey of the user
+func (r *prekeyRepository) SetSignedPrekey(ctx context.Context, prekey *models.SignedPrekey) error {
+	query := `
+		INSERT INTO signed_prekeys (user_id, key_id, public_key, signature, created_at)
+		VALUES (:user_id, :key_id, :public_key, :signature, :created_at)
+		ON DUPLICATE KEY UPDATE key_id = VALUES(key_id), public_key = VALUES(public_key),
+			signature = VALUES(signature), created_at = VALUES(created_at)
+	`
+	_, err := r.db.NamedExecContext(ctx, query, prekey)
+	return err
+}
+
+// AddOneTimePrekeys stores a batch of one-time prekeys. Key IDs that are
+// already stored are skipped, so an upload can be retried.
+func (r *prekeyRepository) AddOneTimePrekeys(ctx context.Context, prekeys []models.OneTimePrekey) error {
+	if len(prekeys) == 0 {
+		return nil
+	}
+	query := `
+		INSERT IGNORE INTO one_time_prekeys (user_id, key_id, public_key, created_at)
+		VALUES (:user_id, :key_id, :public_key, :created_at)
+	`
+	_, err := r.db.NamedExecContext(ctx, query, prekeys)
+	return err
+}
+
+func (r *prekeyRepository) CountOneTimePrekeys(ctx context.Context, userID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM one_time_prekeys WHERE user_id = ?`
+	if err := r.db.GetContext(ctx, &count, query, userID); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// TakeOneTimePrekey removes the oldest one-time prekey of the user and returns
+// it with the number of prekeys left. The prekey is nil when the stock is empty.
+// Concurrent callers never receive the same prekey.
+func (r *prekeyRepository) TakeOneTimePrekey(ctx context.Context, userID string) (*models.OneTimePrekey, int, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	prekey := &models.OneTimePrekey{}
+	query := `
+		SELECT user_id, key_id, public_key, created_at
+		FROM one_time_prekeys
+		WHERE user_id = ?
+		ORDER BY key_id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`
+	if err := tx.GetContext(ctx, prekey, query, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM one_time_prekeys WHERE user_id = ? AND key_id = ?`, userID, prekey.KeyID); err != nil {
+		return nil, 0, err
+	}
+
+	var left int
+	if err := tx.GetContext(ctx, &left, `SELECT COUNT(*) FROM one_time_prekeys WHERE user_id = ?`, userID); err != nil {
+		return nil, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+	return prekey, left, nil
+}
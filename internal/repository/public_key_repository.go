@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poshta/internal/domain/models"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type PublicKeyRepository interface {
+	Rotate(ctx context.Context, userID, publicKey string) (*models.PublicKey, error)
+	Get(ctx context.Context, userID string, version int) (*models.PublicKey, error)
+	CurrentVersions(ctx context.Context, userIDs []string) (map[string]int, error)
+}
+
+type publicKeyRepository struct {
+	db *sqlx.DB
+}
+
+func NewPublicKeyRepository(db *sqlx.DB) PublicKeyRepository {
+	return &publicKeyRepository{
+		db: db,
+	}
+}
+
+// Rotate revokes the current public key of the user and makes publicKey the next
+// version, recorded in the key log. Verifications of the old key are reset. It
+// returns nil if the user doesn't exist.
+func (r *publicKeyRepository) Rotate(ctx context.Context, userID, publicKey string) (*models.PublicKey, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// блокируем строку, чтобы параллельные ротации не получили одну и ту же версию
+	var current int
+	if err := tx.GetContext(ctx, &current, `SELECT public_key_version FROM users WHERE id = ? FOR UPDATE`, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	key := &models.PublicKey{
+		UserID:    userID,
+		Version:   current + 1,
+		PublicKey: publicKey,
+		CreatedAt: now,
+	}
+
+	revoke := `UPDATE user_public_keys SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, revoke, now, userID); err != nil {
+		return nil, err
+	}
+	insert := `
+		INSERT INTO user_public_keys (user_id, version, public_key, created_at)
+		VALUES (:user_id, :version, :public_key, :created_at)
+	`
+	if _, err := tx.NamedExecContext(ctx, insert, key); err != nil {
+		return nil, err
+	}
+	update := `UPDATE users SET public_key = ?, public_key_version = ?, updated_at = NOW() WHERE id = ?`
+	if _, err := tx.ExecContext(ctx, update, publicKey, key.Version, userID); err != nil {
+		return nil, err
+	}
+	if err := appendKeyLog(ctx, tx, userID, key.Version, publicKey); err != nil {
+		return nil, err
+	}
+
+	// проверки старого ключа больше не действуют, чаты с пользователем помечаются
+	reset := `
+		UPDATE key_verifications
+		SET verified_at = NULL, key_changed_at = ?
+		WHERE user_id = ? AND verified_at IS NOT NULL
+	`
+	if _, err := tx.ExecContext(ctx, reset, now, userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Get returns a version of the user's public key, or nil if there is no such version
+func (r *publicKeyRepository) Get(ctx context.Context, userID string, version int) (*models.PublicKey, error) {
+	key := &models.PublicKey{}
+	query := `
+		SELECT user_id, version, public_key, created_at, revoked_at
+		FROM user_public_keys
+		WHERE user_id = ? AND version = ?
+	`
+	if err := r.db.GetContext(ctx, key, query, userID, version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// CurrentVersions returns the current public key version of each of the users
+func (r *publicKeyRepository) CurrentVersions(ctx context.Context, userIDs []string) (map[string]int, error) {
+	versions := make(map[string]int, len(userIDs))
+	if len(userIDs) == 0 {
+		return versions, nil
+	}
+
+	query, args, err := sqlx.In(`SELECT id, public_key_version FROM users WHERE id IN (?)`, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryxContext(ctx, r.db.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id      string
+			version int
+		)
+		if err := rows.Scan(&id, &version); err != nil {
+			return nil, err
+		}
+		versions[id] = version
+	}
+	return versions, rows.Err()
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"poshta/internal/domain/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type ReceiptRepository interface {
+	MarkDelivered(ctx context.Context, chatID, userID string, messageID int64) error
+	MarkRead(ctx context.Context, chatID, userID string, messageID int64) error
+	GetPositions(ctx context.Context, chatID string) ([]models.ReadPosition, error)
+}
+
+type receiptRepository struct {
+	db *sqlx.DB
+}
+
+func NewReceiptRepository(db *sqlx.DB) ReceiptRepository {
+	return &receiptRepository{
+		db: db,
+	}
+}
+
+// MarkDelivered moves the delivery position forward; it never moves back
+func (r *receiptRepository) MarkDelivered(ctx context.Context, chatID, userID string, messageID int64) error {
+	query := `
+		INSERT INTO chat_read_positions (chat_id, user_id, last_delivered_message_id, updated_at)
+		VALUES (?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE
+			last_delivered_message_id = GREATEST(last_delivered_message_id, VALUES(last_delivered_message_id))
+	`
+	_, err := r.db.ExecContext(ctx, query, chatID, userID, messageID)
+	return err
+}
+
+// MarkRead moves the read position forward. A read message is delivered as well.
+func (r *receiptRepository) MarkRead(ctx context.Context, chatID, userID string, messageID int64) error {
+	query := `
+		INSERT INTO chat_read_positions (chat_id, user_id, last_delivered_message_id, last_read_message_id, updated_at)
+		VALUES (?, ?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE
+			last_delivered_message_id = GREATEST(last_delivered_message_id, VALUES(last_delivered_message_id)),
+			last_read_message_id = GREATEST(last_read_message_id, VALUES(last_read_message_id))
+	`
+	_, err := r.db.ExecContext(ctx, query, chatID, userID, messageID, messageID)
+	return err
+}
+
+func (r *receiptRepository) GetPositions(ctx context.Context, chatID string) ([]models.ReadPosition, error) {
+	query := `
+		SELECT chat_id, user_id, last_delivered_message_id, last_read_message_id, updated_at
+		FROM chat_read_positions
+		WHERE chat_id = ?
+	`
+	positions := make([]models.ReadPosition, 0)
+	if err := r.db.SelectContext(ctx, &positions, query, chatID); err != nil {
+		return nil, err
+	}
+	return positions, nil
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poshta/internal/domain/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrTokenAlreadyRotated is returned by Rotate when the token was rotated or
+// revoked in the meantime
+var ErrTokenAlreadyRotated = errors.New("refresh token already rotated")
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token *models.RefreshToken) error
+	GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	Rotate(ctx context.Context, oldID string, token *models.RefreshToken) error
+}
+
+type refreshTokenRepository struct {
+	db *sqlx.DB
+}
+
+func NewRefreshTokenRepository(db *sqlx.DB) RefreshTokenRepository {
+	return &refreshTokenRepository{
+		db: db,
+	}
+}
+
+const insertRefreshToken = `
+	INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, created_at, expires_at)
+	VALUES (:id, :user_id, :family_id, :token_hash, :created_at, :expires_at)
+`
+
+func (r *refreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	_, err := r.db.NamedExecContext(ctx, insertRefreshToken, token)
+	return err
+}
+
+// GetByHash returns the token with the given hash, or nil if there is none
+func (r *refreshTokenRepository) GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	token := &models.RefreshToken{}
+	query := `
+		SELECT id, user_id, family_id, token_hash, created_at, expires_at, rotated_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = ?
+	`
+	if err := r.db.GetContext(ctx, token, query, tokenHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// Rotate marks the old token as rotated and stores its successor in one
+// transaction. Only one of concurrent rotations of the same token succeeds.
+func (r *refreshTokenRepository) Rotate(ctx context.Context, oldID string, token *models.RefreshToken) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE refresh_tokens
+		SET rotated_at = UTC_TIMESTAMP()
+		WHERE id = ? AND rotated_at IS NULL AND revoked_at IS NULL
+	`
+	result, err := tx.ExecContext(ctx, query, oldID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTokenAlreadyRotated
+	}
+
+	if _, err := tx.NamedExecContext(ctx, insertRefreshToken, token); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poshta/internal/domain/models"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *models.Session) error
+	GetByID(ctx context.Context, id string) (*models.Session, error)
+	GetByUserID(ctx context.Context, userID string) ([]models.Session, error)
+	Touch(ctx context.Context, id string, at time.Time) error
+	Revoke(ctx context.Context, id string) error
+	RevokeUser(ctx context.Context, userID string) ([]string, error)
+}
+
+type sessionRepository struct {
+	db *sqlx.DB
+}
+
+func NewSessionRepository(db *sqlx.DB) SessionRepository {
+	return &sessionRepository{
+		db: db,
+	}
+}
+
+func (r *sessionRepository) Create(ctx context.Context, session *models.Session) error {
+	query := `
+		INSERT INTO sessions (id, user_id, device_name, user_agent, ip, created_at, last_used_at)
+		VALUES (:id, :user_id, :device_name, :user_agent, :ip, :created_at, :last_used_at)
+	`
+	_, err := r.db.NamedExecContext(ctx, query, session)
+	return err
+}
+
+// GetByID returns the session, or nil if there is none
+func (r *sessionRepository) GetByID(ctx context.Context, id string) (*models.Session, error) {
+	session := &models.Session{}
+	query := `
+		SELECT id, user_id, device_name, user_agent, ip, created_at, last_used_at, revoked_at
+		FROM sessions
+		WHERE id = ?
+	`
+	if err := r.db.GetContext(ctx, session, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// GetByUserID returns the active sessions of the user, most recently used first
+func (r *sessionRepository) GetByUserID(ctx context.Context, userID string) ([]models.Session, error) {
+	query := `
+		SELECT id, user_id, device_name, user_agent, ip, created_at, last_used_at, revoked_at
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL
+		ORDER BY last_used_at DESC
+	`
+	sessions := make([]models.Session, 0)
+	if err := r.db.SelectContext(ctx, &sessions, query, userID); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Touch records that the session was used
+func (r *sessionRepository) Touch(ctx context.Context, id string, at time.Time) error {
+	query := `UPDATE sessions SET last_used_at = ? WHERE id = ? AND last_used_at < ?`
+	_, err := r.db.ExecContext(ctx, query, at, id, at)
+	return err
+}
+
+// Revoke ends the session and revokes its refresh tokens
+func (r *sessionRepository) Revoke(ctx context.Context, id string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = UTC_TIMESTAMP() WHERE id = ? AND revoked_at IS NULL`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE family_id = ? AND revoked_at IS NULL`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeUser ends every active session of the user together with its refresh
+// tokens and returns the IDs of the ended sessions
+func (r *sessionRepository) RevokeUser(ctx context.Context, userID string) ([]string, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]string, 0)
+	if err := tx.SelectContext(ctx, &ids, `SELECT id FROM sessions WHERE user_id = ? AND revoked_at IS NULL FOR UPDATE`, userID); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = UTC_TIMESTAMP() WHERE user_id = ? AND revoked_at IS NULL`, userID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = UTC_TIMESTAMP() WHERE user_id = ? AND revoked_at IS NULL`, userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"poshta/internal/domain/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type TOTPRepository interface {
+	GetByUserID(ctx context.Context, userID string) (*models.TOTP, error)
+	Save(ctx context.Context, totp *models.TOTP) error
+	Enable(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error
+	UseStep(ctx context.Context, userID string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error)
+	Delete(ctx context.Context, userID string) error
+}
+
+type totpRepository struct {
+	db *sqlx.DB
+}
+
+func NewTOTPRepository(db *sqlx.DB) TOTPRepository {
+	return &totpRepository{
+		db: db,
+	}
+}
+
+// GetByUserID returns the TOTP enrollment of the user, or nil if there is none
+func (r *totpRepository) GetByUserID(ctx context.Context, userID string) (*models.TOTP, error) {
+	totp := &models.TOTP{}
+	query := `
+		SELECT user_id, secret, created_at, enabled_at, last_used_step
+		FROM user_totp
+		WHERE user_id = ?
+	`
+	if err := r.db.GetContext(ctx, totp, query, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return totp, nil
+}
+
+// Save stores a pending enrollment, replacing a previous pending one
+func (r *totpRepository) Save(ctx context.Context, totp *models.TOTP) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret, created_at, enabled_at, last_used_step)
+		VALUES (:user_id, :secret, :created_at, NULL, 0)
+		ON DUPLICATE KEY UPDATE
+			secret = VALUES(secret),
+			created_at = VALUES(created_at),
+			enabled_at = NULL,
+			last_used_step = 0
+	`
+	_, err := r.db.NamedExecContext(ctx, query, totp)
+	return err
+}
+
+// Enable activates the enrollment and replaces the recovery codes
+func (r *totpRepository) Enable(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE user_totp SET enabled_at = UTC_TIMESTAMP(), last_used_step = ? WHERE user_id = ?`
+	if _, err := tx.ExecContext(ctx, query, step, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records that the code of a time step was used. It reports false if
+// that step or a later one was already used.
+func (r *totpRepository) UseStep(ctx context.Context, userID string, step int64) (bool, error) {
+	query := `UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`
+	result, err := r.db.ExecContext(ctx, query, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It reports false if
+// there is no such unused code.
+func (r *totpRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	query := `
+		UPDATE user_recovery_codes
+		SET used_at = UTC_TIMESTAMP()
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// Delete removes the enrollment and the recovery codes of the user
+func (r *totpRepository) Delete(ctx context.Context, userID string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -3,17 +3,27 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"poshta/internal/domain/models"
+	"strings"
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
+// ErrDuplicateUser is returned by Update when the username or the email address is taken
+var ErrDuplicateUser = errors.New("duplicate user")
+
 type UserRepository interface {
 	GetByID(ctx context.Context, id string) (*models.User, error)
 	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
 	Create(ctx context.Context, user *models.User) (string, error)
 	Update(ctx context.Context, user *models.User) error
 	GetUserPublicKey(ctx context.Context, userID string) (string, error)
+	MarkEmailVerified(ctx context.Context, userID, email string) error
+	UpdatePassword(ctx context.Context, userID, passwordHash string) error
+	Search(ctx context.Context, searcherID, prefix string, limit int) ([]models.User, error)
 }
 
 
@@ -30,7 +40,7 @@ func NewUserRepository(db *sqlx.DB) UserRepository {
 
 func (r *userRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, email, password, created_at, updated_at, public_key FROM users WHERE id = ?`
+	query := `SELECT id, username, email, email_verified_at, display_name, bio, discoverability, password, created_at, updated_at, public_key, public_key_version FROM users WHERE id = ?`
 	err := r.db.GetContext(ctx, user, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -44,7 +54,7 @@ func (r *userRepository) GetByID(ctx context.Context, id string) (*models.User,
 
 func (r *userRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, email, password, created_at, updated_at FROM users WHERE username = ?`
+	query := `SELECT id, username, email, email_verified_at, display_name, bio, discoverability, password, created_at, updated_at FROM users WHERE username = ?`
 	err := r.db.GetContext(ctx, user, query, username)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -56,35 +66,76 @@ func (r *userRepository) GetByUsername(ctx context.Context, username string) (*m
 }
 
 
+// GetByEmail returns the user with the email address, or nil if there is none
+func (r *userRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	user := &models.User{}
+	query := `SELECT id, username, email, email_verified_at, display_name, bio, discoverability, password, created_at, updated_at FROM users WHERE email = ?`
+	err := r.db.GetContext(ctx, user, query, email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // User not found
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+
+// Create stores the user together with version 1 of their public key and records
+// the key in the key log
 func (r *userRepository) Create(ctx context.Context, user *models.User) (string, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
 
 	userID := uuid.New().String()
 	query := `
-		INSERT INTO users (id, username, email, password, public_key, created_at, updated_at) 
-		VALUES (?, ?, ?, ?, ?, NOW(), NOW())
+		INSERT INTO users (id, username, email, password, public_key, public_key_version, created_at, updated_at) 
+		VALUES (?, ?, ?, ?, ?, 1, NOW(), NOW())
 	`
-	_, err := r.db.ExecContext(ctx, query, 
+	_, err = tx.ExecContext(ctx, query, 
 		userID,
 		user.Username, 
 		user.Email, 
 		user.Password, 
 		user.PublicKey)
+	if err != nil {
+		return "", err
+	}
 
+	keyQuery := `
+		INSERT INTO user_public_keys (user_id, version, public_key, created_at)
+		VALUES (?, 1, ?, UTC_TIMESTAMP())
+	`
+	if _, err := tx.ExecContext(ctx, keyQuery, userID, user.PublicKey); err != nil {
+		return "", err
+	}
+	if err := appendKeyLog(ctx, tx, userID, 1, user.PublicKey); err != nil {
+		return "", err
+	}
 
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		return "", err
 	}
 	return userID, nil
 }
 
-// Update updates an existing user
+// Update updates the profile of an existing user. The password is changed with
+// UpdatePassword. ErrDuplicateUser is returned if the username or the email
+// address belongs to another user.
 func (r *userRepository) Update(ctx context.Context, user *models.User) error {
 	query := `
 		UPDATE users 
-		SET username = ?, email = ?, password = ?, updated_at = NOW() 
+		SET username = ?, email = ?, email_verified_at = ?, display_name = ?, bio = ?, discoverability = ?, updated_at = NOW() 
 		WHERE id = ?
 	`
-	_, err := r.db.ExecContext(ctx, query, user.Username, user.Email, user.Password, user.ID)
+	_, err := r.db.ExecContext(ctx, query, user.Username, user.Email, user.EmailVerifiedAt, user.DisplayName, user.Bio, user.Discoverability, user.ID)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return ErrDuplicateUser
+	}
 	return err
 }
 
@@ -99,4 +150,56 @@ func (r * userRepository) GetUserPublicKey(ctx context.Context, userID string) (
 		return "", err
 	}
 	return publicKey, nil
-}
\ No newline at end of file
+}
+
+// MarkEmailVerified marks the email address of the user as verified, unless the
+// user has changed the address in the meantime
+func (r *userRepository) MarkEmailVerified(ctx context.Context, userID, email string) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = UTC_TIMESTAMP()
+		WHERE id = ? AND email = ? AND email_verified_at IS NULL
+	`
+	_, err := r.db.ExecContext(ctx, query, userID, email)
+	return err
+}
+
+// UpdatePassword replaces the password hash of the user
+func (r *userRepository) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+	query := `UPDATE users SET password = ?, updated_at = NOW() WHERE id = ?`
+	_, err := r.db.ExecContext(ctx, query, passwordHash, userID)
+	return err
+}
+
+// userDiscoverable filters users u the searcher (both query arguments) may find
+// by username. Users who blocked the searcher are left out.
+const userDiscoverable = `(
+		u.discoverability = 'everyone'
+		OR (u.discoverability = 'contacts' AND EXISTS (
+			SELECT 1 FROM contacts c WHERE c.owner_id = u.id AND c.contact_id = ?
+		))
+	) AND NOT EXISTS (
+		SELECT 1 FROM user_blocks b WHERE b.blocker_id = u.id AND b.blocked_id = ?
+	)`
+
+// Search returns the users whose username starts with prefix and who allow the
+// searcher to find them, ordered by username
+func (r *userRepository) Search(ctx context.Context, searcherID, prefix string, limit int) ([]models.User, error) {
+	query := `
+		SELECT u.id, u.username, u.display_name, u.bio, u.created_at
+		FROM users u
+		WHERE u.username LIKE ? AND u.id <> ? AND ` + userDiscoverable + `
+		ORDER BY u.username
+		LIMIT ?
+	`
+	users := make([]models.User, 0)
+	if err := r.db.SelectContext(ctx, &users, query, escapeLike(prefix)+"%", searcherID, searcherID, searcherID, limit); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"poshta/internal/domain/models"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type WSTicketRepository interface {
+	Create(ctx context.Context, ticket *models.WSTicket) error
+	Consume(ctx context.Context, ticketID string) (bool, error)
+}
+
+type wsTicketRepository struct {
+	db *sqlx.DB
+}
+
+func NewWSTicketRepository(db *sqlx.DB) WSTicketRepository {
+	return &wsTicketRepository{
+		db: db,
+	}
+}
+
+// Create stores an issued ticket. Tickets that expired unused are deleted on the way.
+func (r *wsTicketRepository) Create(ctx context.Context, ticket *models.WSTicket) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM ws_tickets WHERE expires_at <= UTC_TIMESTAMP()`); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO ws_tickets (id, user_id, expires_at) VALUES (:id, :user_id, :expires_at)`
+	_, err := r.db.NamedExecContext(ctx, query, ticket)
+	return err
+}
+
+// Consume deletes an unexpired ticket. It reports false if the ticket is
+// unknown, expired or was already used, e.g. by a concurrent connection.
+func (r *wsTicketRepository) Consume(ctx context.Context, ticketID string) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM ws_tickets WHERE id = ? AND expires_at > UTC_TIMESTAMP()`, ticketID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
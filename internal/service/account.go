@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"poshta/internal/domain/models"
+	"poshta/internal/repository"
+	"poshta/pkg/logger"
+	"poshta/pkg/mailer"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrEmailNotVerified = errors.New("email address is not verified")
+	ErrInvalidPassword  = errors.New("password must be at least 8 characters")
+)
+
+const minPasswordLength = 8
+
+// mailTimeout bounds the delivery of a single email
+const mailTimeout = 30 * time.Second
+
+type AccountConfig struct {
+	Keys                 TokenKeys
+	Issuer               string
+	AppURL               string // links in emails point here
+	VerificationTokenTTL time.Duration
+	ResetTokenTTL        time.Duration
+}
+
+// AccountService verifies email addresses and resets forgotten passwords with
+// single-use tokens sent by email
+type AccountService interface {
+	SendVerification(ctx context.Context, user *models.User) error
+	ResendVerification(ctx context.Context, email string) error
+	VerifyEmail(ctx context.Context, token string) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, password string) error
+}
+
+type accountService struct {
+	userRepo     repository.UserRepository
+	tokenRepo    repository.AccountTokenRepository
+	sessionRepo  repository.SessionRepository
+	disconnector SessionDisconnector
+	mailer       mailer.Mailer
+	cfg          AccountConfig
+}
+
+func NewAccountService(userRepo repository.UserRepository, tokenRepo repository.AccountTokenRepository, sessionRepo repository.SessionRepository, disconnector SessionDisconnector, mailer mailer.Mailer, cfg AccountConfig) AccountService {
+	return &accountService{
+		userRepo:     userRepo,
+		tokenRepo:    tokenRepo,
+		sessionRepo:  sessionRepo,
+		disconnector: disconnector,
+		mailer:       mailer,
+		cfg:          cfg,
+	}
+}
+
+// SendVerification emails the user a link that verifies their address
+func (s *accountService) SendVerification(ctx context.Context, user *models.User) error {
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	token, err := s.issueToken(ctx, user, models.TokenPurposeEmailVerification, s.cfg.VerificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	s.deliver(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"please confirm your email address by opening this link:\n\n%s\n\n"+
+			"The link expires in %s. If you did not sign up for Poshta, ignore this email.\n",
+			user.Username, s.link("/verify-email", token), formatTTL(s.cfg.VerificationTokenTTL)),
+	})
+	return nil
+}
+
+// ResendVerification sends a new verification link. Unknown addresses are
+// ignored so the endpoint can't be used to find out who is registered.
+func (s *accountService) ResendVerification(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil
+	}
+	return s.SendVerification(ctx, user)
+}
+
+// VerifyEmail marks the address the token was sent to as verified
+func (s *accountService) VerifyEmail(ctx context.Context, token string) error {
+	claims, err := s.useToken(ctx, token, models.TokenPurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+	email, _ := claims["email"].(string)
+
+	user, err := s.userRepo.GetByID(ctx, claims["sub"].(string))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	// адрес могли сменить после отправки письма
+	if user == nil || user.Email != email {
+		return ErrInvalidToken
+	}
+
+	if err := s.userRepo.MarkEmailVerified(ctx, user.ID, email); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return nil
+}
+
+// ForgotPassword emails a password reset link. Unknown addresses are ignored so
+// the endpoint can't be used to find out who is registered.
+func (s *accountService) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil
+	}
+
+	token, err := s.issueToken(ctx, user, models.TokenPurposePasswordReset, s.cfg.ResetTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	s.deliver(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"someone asked to reset the password of your Poshta account. To choose a new password, open this link:\n\n%s\n\n"+
+			"The link expires in %s. If it wasn't you, ignore this email; your password stays unchanged.\n",
+			user.Username, s.link("/reset-password", token), formatTTL(s.cfg.ResetTokenTTL)),
+	})
+	return nil
+}
+
+// ResetPassword sets a new password and ends every session of the user.
+// The reset link proves the address, so it is marked as verified as well.
+func (s *accountService) ResetPassword(ctx context.Context, token, password string) error {
+	if len(password) < minPasswordLength {
+		return ErrInvalidPassword
+	}
+
+	claims, err := s.useToken(ctx, token, models.TokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+	email, _ := claims["email"].(string)
+
+	user, err := s.userRepo.GetByID(ctx, claims["sub"].(string))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return ErrInvalidToken
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if err := s.userRepo.UpdatePassword(ctx, user.ID, string(hashedPassword)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if err := s.userRepo.MarkEmailVerified(ctx, user.ID, email); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	sessionIDs, err := s.sessionRepo.RevokeUser(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	for _, id := range sessionIDs {
+		s.disconnector.DisconnectSession(id)
+	}
+
+	logger.Info("Password reset", logrus.Fields{"user_id": user.ID})
+	return nil
+}
+
+// issueToken records a new token and returns it signed. The "type" claim is the
+// purpose, so it is never accepted where an access token is expected.
+func (s *accountService) issueToken(ctx context.Context, user *models.User, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+	record := &models.AccountToken{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := s.tokenRepo.Create(ctx, record); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	claims := jwt.MapClaims{
+		"sub":   user.ID,
+		"jti":   record.ID,
+		"email": user.Email,
+		"exp":   record.ExpiresAt.Unix(),
+		"iat":   now.Unix(),
+		"iss":   s.cfg.Issuer,
+		"type":  purpose,
+	}
+	token, err := s.cfg.Keys.Sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return token, nil
+}
+
+// useToken checks the signature and purpose of a token and consumes it
+func (s *accountService) useToken(ctx context.Context, tokenString, purpose string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, s.cfg.Keys.Keyfunc)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["type"] != purpose {
+		return nil, ErrInvalidToken
+	}
+	id, _ := claims["jti"].(string)
+	userID, _ := claims["sub"].(string)
+	if id == "" || userID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	record, err := s.tokenRepo.Use(ctx, id, purpose)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if record == nil || record.UserID != userID {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// deliver sends the message in the background, so the response time doesn't
+// reveal whether an address is registered and a slow mail server doesn't block requests
+func (s *accountService) deliver(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			logger.Error("Failed to send email", err, logrus.Fields{
+				"subject": msg.Subject,
+			})
+		}
+	}()
+}
+
+// link returns the frontend URL for path carrying the token
+func (s *accountService) link(path, token string) string {
+	return strings.TrimRight(s.cfg.AppURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// formatTTL renders a token lifetime for an email, e.g. "48 hours" or "30 minutes"
+func formatTTL(ttl time.Duration) string {
+	if ttl >= time.Hour && ttl%time.Hour == 0 {
+		if hours := int(ttl / time.Hour); hours != 1 {
+			return fmt.Sprintf("%d hours", hours)
+		}
+		return "1 hour"
+	}
+	minutes := int(ttl.Round(time.Minute) / time.Minute)
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
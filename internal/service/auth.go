@@ -2,13 +2,18 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"poshta/internal/domain/models"
 	"poshta/internal/repository"
+	"poshta/pkg/logger"
 	"time"
 	"poshta/pkg/reqresp"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,37 +24,79 @@ var (
 	ErrInternal           = errors.New("internal error")
 	ErrInvalidToken       = errors.New("invalid token")
 	ErrUserNotFound       = errors.New("user not found")
+	ErrTokenReused        = errors.New("refresh token reused")
 )
 
 
 type JWTConfig struct {
-	SecretKey       string
-	AccessTokenTTL  time.Duration
-	RefreshTokenTTL time.Duration
-	Issuer          string
+	Keys                 TokenKeys // signs and verifies tokens; HS256 with SecretKey when nil
+	SecretKey            string
+	AccessTokenTTL       time.Duration
+	RefreshTokenTTL      time.Duration
+	Issuer               string
+	WSTicketTTL          time.Duration
+	MFATokenTTL          time.Duration
+	TOTPIssuer           string // shown in authenticator apps
+	RequireVerifiedEmail bool   // refuse logins of accounts with an unverified email address
+}
+
+// EmailVerifier sends the verification email to new users
+type EmailVerifier interface {
+	SendVerification(ctx context.Context, user *models.User) error
 }
 
 // AuthService defines the interface for authentication services
 type AuthService interface {
 	Register(ctx context.Context, req reqresp.RegisterRequest) (*models.User, error)
 	Login(ctx context.Context, req reqresp.LoginRequest) (*reqresp.AuthResponse, error)
+	LoginMFA(ctx context.Context, req reqresp.MFALoginRequest) (*reqresp.AuthResponse, error)
+	EnrollTOTP(ctx context.Context, user *models.User) (*reqresp.TOTPEnrollResponse, error)
+	ActivateTOTP(ctx context.Context, userID, code string) (*reqresp.RecoveryCodesResponse, error)
+	DisableTOTP(ctx context.Context, userID string, req reqresp.TOTPCodeRequest) error
 	ValidateToken(tokenString string) (*jwt.Token, error)
+	ValidateTokenType(tokenString, tokenType string) (*jwt.Token, error)
+	GenerateWSTicket(ctx context.Context, user *models.User, sessionID string, sessionExp time.Time) (string, time.Time, error)
+	ValidateWSTicket(ctx context.Context, ticket string) (*jwt.Token, error)
+	SessionExpiry(token *jwt.Token) (time.Time, error)
+	SessionID(token *jwt.Token) string
 	GetUserFromToken(token *jwt.Token) (*models.User, error)
-	RefreshToken(refreshToken string) (*reqresp.AuthResponse, error)
-	GetUserPublicKey(userID string) (string, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*reqresp.AuthResponse, error)
+	Logout(ctx context.Context, refreshToken string) error
+	LogoutAll(ctx context.Context, userID string) error
+	JWKS() reqresp.JWKS
 }
 
+// sessionTouchInterval limits how often last_used_at of a session is updated
+const sessionTouchInterval = time.Minute
+
 // authService implements AuthService interface
 type authService struct {
-	userRepo repository.UserRepository
-	jwtCfg   JWTConfig
+	userRepo     repository.UserRepository
+	refreshRepo  repository.RefreshTokenRepository
+	sessionRepo  repository.SessionRepository
+	totpRepo     repository.TOTPRepository
+	ticketRepo   repository.WSTicketRepository
+	disconnector SessionDisconnector
+	verifier     EmailVerifier
+	guard        *LoginGuard
+	jwtCfg       JWTConfig
 }
 
 // NewAuthService creates a new instance of AuthService
-func NewAuthService(userRepo repository.UserRepository, jwtCfg JWTConfig) AuthService {
+func NewAuthService(userRepo repository.UserRepository, refreshRepo repository.RefreshTokenRepository, sessionRepo repository.SessionRepository, totpRepo repository.TOTPRepository, ticketRepo repository.WSTicketRepository, disconnector SessionDisconnector, verifier EmailVerifier, guard *LoginGuard, jwtCfg JWTConfig) AuthService {
+	if jwtCfg.Keys == nil {
+		jwtCfg.Keys = NewHMACKeys(jwtCfg.SecretKey)
+	}
 	return &authService{
-		userRepo: userRepo,
-		jwtCfg:   jwtCfg,
+		userRepo:     userRepo,
+		refreshRepo:  refreshRepo,
+		sessionRepo:  sessionRepo,
+		totpRepo:     totpRepo,
+		ticketRepo:   ticketRepo,
+		disconnector: disconnector,
+		verifier:     verifier,
+		guard:        guard,
+		jwtCfg:       jwtCfg,
 	}
 }
 
@@ -90,36 +137,100 @@ func (s *authService) Register(ctx context.Context, req reqresp.RegisterRequest)
 		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
 	}
 
+	// письмо можно запросить повторно, регистрацию из-за него не откатываем
+	if err := s.verifier.SendVerification(ctx, user); err != nil {
+		logger.Error("Failed to send verification email", err, logrus.Fields{"user_id": user.ID})
+	}
+
 	return user, nil
 }
 
-// Login handles user authentication
+// Login handles user authentication. Accounts with two-factor authentication
+// get an mfa_token instead of tokens, see LoginMFA.
 func (s *authService) Login(ctx context.Context, req reqresp.LoginRequest) (*reqresp.AuthResponse, error) {
+	if err := s.guard.Check(ctx, req.Username, req.IP); err != nil {
+		return nil, err
+	}
+
 	// Get user by username
 	user, err := s.userRepo.GetByUsername(ctx, req.Username)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
 	}
 	if user == nil {
-		return nil, ErrInvalidCredentials
+		// неизвестные имена считаются так же, чтобы не выдавать, какие заняты
+		return nil, s.loginFailed(ctx, req.Username, req.IP)
 	}
 
 	// Verify password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
-		return nil, ErrInvalidCredentials
+		return nil, s.loginFailed(ctx, req.Username, req.IP)
+	}
+
+	if s.jwtCfg.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return nil, ErrEmailNotVerified
+	}
+
+	totp, err := s.totpRepo.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if totp != nil && totp.EnabledAt != nil {
+		mfaToken, err := s.generateMFAToken(user, req.DeviceName)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		return &reqresp.AuthResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		}, nil
+	}
+
+	if err := s.guard.Succeed(ctx, user.Username); err != nil {
+		return nil, err
+	}
+	return s.startSession(ctx, user, req.DeviceName, req.UserAgent, req.IP)
+}
+
+// loginFailed counts a failed login and returns the error to report for it
+func (s *authService) loginFailed(ctx context.Context, username, ip string) error {
+	if err := s.guard.Fail(ctx, username, ip); err != nil {
+		return err
+	}
+	return ErrInvalidCredentials
+}
+
+// startSession opens a new session for an authenticated user and issues its tokens
+func (s *authService) startSession(ctx context.Context, user *models.User, deviceName, userAgent, ip string) (*reqresp.AuthResponse, error) {
+	// каждый вход — новая сессия и новое семейство refresh токенов
+	now := time.Now().UTC()
+	session := &models.Session{
+		ID:         uuid.New().String(),
+		UserID:     user.ID,
+		DeviceName: deviceName,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
 	}
 
 	// Generate tokens
-	accessToken, accessExpiry, err := s.generateAccessToken(user)
+	accessToken, accessExpiry, err := s.generateAccessToken(user, session.ID)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
 	}
 
-	refreshToken, _, err := s.generateRefreshToken(user)
+	refreshToken, record, err := s.generateRefreshToken(user, session.ID)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
 	}
+	if err := s.refreshRepo.Create(ctx, record); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
 
 	return &reqresp.AuthResponse{
 		AccessToken:  accessToken,
@@ -130,8 +241,8 @@ func (s *authService) Login(ctx context.Context, req reqresp.LoginRequest) (*req
 	}, nil
 }
 
-// generateAccessToken creates a new JWT access token
-func (s *authService) generateAccessToken(user *models.User) (string, time.Time, error) {
+// generateAccessToken creates a new JWT access token for a session
+func (s *authService) generateAccessToken(user *models.User, sessionID string) (string, time.Time, error) {
 	expiryTime := time.Now().Add(s.jwtCfg.AccessTokenTTL)
 	
 	claims := jwt.MapClaims{
@@ -142,10 +253,10 @@ func (s *authService) generateAccessToken(user *models.User) (string, time.Time,
 		"iat":   time.Now().Unix(),
 		"iss":   s.jwtCfg.Issuer,
 		"type":  "access",
+		"sid":   sessionID,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.jwtCfg.SecretKey))
+	tokenString, err := s.jwtCfg.Keys.Sign(claims)
 	if err != nil {
 		return "", time.Time{}, err
 	}
@@ -153,36 +264,46 @@ func (s *authService) generateAccessToken(user *models.User) (string, time.Time,
 	return tokenString, expiryTime, nil
 }
 
-// generateRefreshToken creates a new JWT refresh token
-func (s *authService) generateRefreshToken(user *models.User) (string, time.Time, error) {
-	expiryTime := time.Now().Add(s.jwtCfg.RefreshTokenTTL)
-	
+// generateRefreshToken creates a new JWT refresh token in the given family
+// (the session ID) together with the record to store for it
+func (s *authService) generateRefreshToken(user *models.User, familyID string) (string, *models.RefreshToken, error) {
+	now := time.Now().UTC()
+	record := &models.RefreshToken{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.jwtCfg.RefreshTokenTTL),
+	}
+
 	claims := jwt.MapClaims{
 		"sub":   fmt.Sprintf("%s", user.ID),
-		"exp":   expiryTime.Unix(),
-		"iat":   time.Now().Unix(),
+		"jti":   record.ID,
+		"exp":   record.ExpiresAt.Unix(),
+		"iat":   now.Unix(),
 		"iss":   s.jwtCfg.Issuer,
 		"type":  "refresh",
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.jwtCfg.SecretKey))
+	tokenString, err := s.jwtCfg.Keys.Sign(claims)
 	if err != nil {
-		return "", time.Time{}, err
+		return "", nil, err
 	}
 
-	return tokenString, expiryTime, nil
+	record.TokenHash = hashToken(tokenString)
+	return tokenString, record, nil
+}
+
+// hashToken returns the hex-encoded SHA-256 of a token, the form tokens are stored in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 // ValidateToken validates a JWT token
 func (s *authService) ValidateToken(tokenString string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(s.jwtCfg.SecretKey), nil
-	})
+	// the key, and with it the signing method, is chosen by the token's kid
+	token, err := jwt.Parse(tokenString, s.jwtCfg.Keys.Keyfunc)
 
 	if err != nil {
 		return nil, err
@@ -195,46 +316,71 @@ func (s *authService) ValidateToken(tokenString string) (*jwt.Token, error) {
 	return token, nil
 }
 
-// GetUserFromToken extracts user information from a validated token
-func (s *authService) GetUserFromToken(token *jwt.Token) (*models.User, error) {
+// ValidateTokenType validates a JWT token and checks its "type" claim
+func (s *authService) ValidateTokenType(tokenString, tokenType string) (*jwt.Token, error) {
+	token, err := s.ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
 		return nil, ErrInvalidToken
 	}
 
-	// Get user ID from token
-	userID, ok := claims["sub"].(string)
-	if !ok {
+	if t, ok := claims["type"].(string); !ok || t != tokenType {
 		return nil, ErrInvalidToken
 	}
 
-	
+	return token, nil
+}
 
-	user, err := s.userRepo.GetByID(context.Background(), userID)
-	
-	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+// GenerateWSTicket creates a short-lived token used to open a WebSocket connection.
+// The connection opened with it belongs to the given session and ends at sessionExp,
+// when the access token that requested the ticket expires; the ticket itself never
+// outlives that token. The ticket is stored so it can be used only once, see ValidateWSTicket.
+func (s *authService) GenerateWSTicket(ctx context.Context, user *models.User, sessionID string, sessionExp time.Time) (string, time.Time, error) {
+	now := time.Now()
+	if !sessionExp.After(now) {
+		return "", time.Time{}, ErrInvalidToken
+	}
+	expiryTime := now.Add(s.jwtCfg.WSTicketTTL)
+	if sessionExp.Before(expiryTime) {
+		expiryTime = sessionExp
+	}
+	record := &models.WSTicket{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		ExpiresAt: expiryTime.UTC(),
 	}
 
-	return user, nil
-}
+	claims := jwt.MapClaims{
+		"sub":         user.ID,
+		"jti":         record.ID,
+		"exp":         expiryTime.Unix(),
+		"iat":         now.Unix(),
+		"iss":         s.jwtCfg.Issuer,
+		"type":        "ws_ticket",
+		"sid":         sessionID,
+		"session_exp": sessionExp.Unix(),
+	}
 
-func (s *authService) GetUserPublicKey(userID string) (string, error) {
-	user, err := s.userRepo.GetByID(context.Background(), userID)
+	tokenString, err := s.jwtCfg.Keys.Sign(claims)
 	if err != nil {
-		return "", fmt.Errorf("%w: %v", ErrInternal, err)
+		return "", time.Time{}, err
 	}
-	if user == nil {
-		return "", ErrUserNotFound
+
+	if err := s.ticketRepo.Create(ctx, record); err != nil {
+		return "", time.Time{}, err
 	}
 
-	return user.PublicKey, nil
+	return tokenString, expiryTime, nil
 }
 
-// RefreshToken handles token refresh
-func (s *authService) RefreshToken(refreshToken string) (*reqresp.AuthResponse, error) {
-	// Validate refresh token
-	token, err := s.ValidateToken(refreshToken)
+// ValidateWSTicket validates a WebSocket ticket and uses it up: a ticket opens
+// at most one connection, however long it has left to live
+func (s *authService) ValidateWSTicket(ctx context.Context, ticket string) (*jwt.Token, error) {
+	token, err := s.ValidateTokenType(ticket, "ws_ticket")
 	if err != nil {
 		return nil, err
 	}
@@ -243,42 +389,209 @@ func (s *authService) RefreshToken(refreshToken string) (*reqresp.AuthResponse,
 	if !ok {
 		return nil, ErrInvalidToken
 	}
+	id, _ := claims["jti"].(string)
+	if id == "" {
+		return nil, ErrInvalidToken
+	}
+
+	consumed, err := s.ticketRepo.Consume(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if !consumed {
+		return nil, ErrInvalidToken
+	}
+	return token, nil
+}
+
+// SessionExpiry returns the moment a connection authenticated by the token must end
+func (s *authService) SessionExpiry(token *jwt.Token) (time.Time, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return time.Time{}, ErrInvalidToken
+	}
+
+	if sessionExp, ok := claims["session_exp"].(float64); ok {
+		return time.Unix(int64(sessionExp), 0), nil
+	}
 
-	// Check if token is refresh token
-	tokenType, ok := claims["type"].(string)
-	if !ok || tokenType != "refresh" {
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return time.Time{}, ErrInvalidToken
+	}
+
+	return exp.Time, nil
+}
+
+// SessionID returns the session a token was issued for, or "" for tokens issued
+// before sessions were introduced
+func (s *authService) SessionID(token *jwt.Token) string {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	sessionID, _ := claims["sid"].(string)
+	return sessionID
+}
+
+// GetUserFromToken extracts user information from a validated token.
+// Tokens of revoked sessions are rejected.
+func (s *authService) GetUserFromToken(token *jwt.Token) (*models.User, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
 		return nil, ErrInvalidToken
 	}
 
-	// Get user ID from token and fetch user
-	userIDStr, ok := claims["sub"].(string)
+	// Get user ID from token
+	userID, ok := claims["sub"].(string)
 	if !ok {
 		return nil, ErrInvalidToken
 	}
 
+	if sessionID := s.SessionID(token); sessionID != "" {
+		if err := s.useSession(context.Background(), sessionID, userID); err != nil {
+			return nil, err
+		}
+	}
 	
 
-	user, err := s.userRepo.GetByID(context.Background(), (userIDStr))
+	user, err := s.userRepo.GetByID(context.Background(), userID)
 	
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
 	}
 
+	return user, nil
+}
+
+// JWKS returns the public keys tokens can be verified with
+func (s *authService) JWKS() reqresp.JWKS {
+	return s.jwtCfg.Keys.JWKS()
+}
+
+// RefreshToken rotates a refresh token: the presented token is invalidated and a
+// new one from the same family is returned. Presenting a token that was already
+// rotated means it was stolen or replayed, so the whole family is revoked.
+func (s *authService) RefreshToken(ctx context.Context, refreshToken string) (*reqresp.AuthResponse, error) {
+	stored, err := s.storedRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.RotatedAt != nil {
+		return nil, s.revokeReusedFamily(ctx, stored)
+	}
+
+	user, err := s.userRepo.GetByID(ctx, stored.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err := s.useSession(ctx, stored.FamilyID, user.ID); err != nil {
+		return nil, err
+	}
+
 	// Generate new tokens
-	accessToken, accessExpiry, err := s.generateAccessToken(user)
+	accessToken, accessExpiry, err := s.generateAccessToken(user, stored.FamilyID)
 	if err != nil {
 		return nil, err
 	}
 
-	newRefreshToken, _, err := s.generateRefreshToken(user)
+	newRefreshToken, record, err := s.generateRefreshToken(user, stored.FamilyID)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := s.refreshRepo.Rotate(ctx, stored.ID, record); err != nil {
+		if errors.Is(err, repository.ErrTokenAlreadyRotated) {
+			// параллельный refresh тем же токеном
+			return nil, s.revokeReusedFamily(ctx, stored)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
 	return &reqresp.AuthResponse{
 		AccessToken:  accessToken,
 		RefreshToken: newRefreshToken,
 		TokenType:    "Bearer",
 		ExpiresIn:    int64(time.Until(accessExpiry).Seconds()),
+		UserID:       user.ID,
 	}, nil
 }
+
+// Logout ends the session the refresh token belongs to
+func (s *authService) Logout(ctx context.Context, refreshToken string) error {
+	stored, err := s.storedRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return revokeSession(ctx, s.sessionRepo, s.disconnector, stored.FamilyID)
+}
+
+// LogoutAll ends every session of the user
+func (s *authService) LogoutAll(ctx context.Context, userID string) error {
+	sessionIDs, err := s.sessionRepo.RevokeUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	for _, id := range sessionIDs {
+		s.disconnector.DisconnectSession(id)
+	}
+	return nil
+}
+
+// storedRefreshToken validates a refresh JWT and loads its record. Revoked and
+// unknown tokens are rejected; rotated ones are returned for reuse detection.
+func (s *authService) storedRefreshToken(ctx context.Context, refreshToken string) (*models.RefreshToken, error) {
+	if _, err := s.ValidateTokenType(refreshToken, "refresh"); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	stored, err := s.refreshRepo.GetByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if stored == nil || stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	return stored, nil
+}
+
+// revokeReusedFamily ends the session of a rotated token presented again
+func (s *authService) revokeReusedFamily(ctx context.Context, stored *models.RefreshToken) error {
+	logger.Info("Refresh token reuse detected, revoking session", logrus.Fields{
+		"user_id":    stored.UserID,
+		"session_id": stored.FamilyID,
+	})
+
+	if err := revokeSession(ctx, s.sessionRepo, s.disconnector, stored.FamilyID); err != nil {
+		return err
+	}
+	return ErrTokenReused
+}
+
+// useSession checks that the session of the user is still active and records its use
+func (s *authService) useSession(ctx context.Context, sessionID, userID string) error {
+	session, err := s.sessionRepo.GetByID(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if session == nil || session.UserID != userID || session.RevokedAt != nil {
+		return ErrInvalidToken
+	}
+
+	now := time.Now().UTC()
+	if now.Sub(session.LastUsedAt) < sessionTouchInterval {
+		return nil
+	}
+	if err := s.sessionRepo.Touch(ctx, sessionID, now); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"poshta/internal/domain/models"
+	"testing"
+	"time"
+)
+
+// memoryWSTicketRepository keeps issued tickets like the SQL repository does
+type memoryWSTicketRepository struct {
+	tickets map[string]time.Time
+}
+
+func (r *memoryWSTicketRepository) Create(ctx context.Context, ticket *models.WSTicket) error {
+	r.tickets[ticket.ID] = ticket.ExpiresAt
+	return nil
+}
+
+func (r *memoryWSTicketRepository) Consume(ctx context.Context, ticketID string) (bool, error) {
+	expiresAt, ok := r.tickets[ticketID]
+	if !ok || !expiresAt.After(time.Now()) {
+		return false, nil
+	}
+	delete(r.tickets, ticketID)
+	return true, nil
+}
+
+func newTestAuthService() *authService {
+	return &authService{
+		ticketRepo: &memoryWSTicketRepository{tickets: make(map[string]time.Time)},
+		jwtCfg: JWTConfig{
+			Keys:           NewHMACKeys("test-secret"),
+			Issuer:         "poshta-test",
+			AccessTokenTTL: 15 * time.Minute,
+			WSTicketTTL:    30 * time.Second,
+		},
+	}
+}
+
+func TestWSTicketIsSingleUse(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthService()
+	user := &models.User{ID: "user"}
+
+	ticket, _, err := s.GenerateWSTicket(ctx, user, "session", time.Now().Add(15*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ValidateWSTicket(ctx, ticket); err != nil {
+		t.Fatalf("first use of a ticket: %v", err)
+	}
+	if _, err := s.ValidateWSTicket(ctx, ticket); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("second use of a ticket: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestWSTicketKeepsAccessTokenExpiry(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthService()
+	user := &models.User{ID: "user"}
+
+	// токен доступа вот-вот истечёт: соединение не должно жить дольше него
+	accessExp := time.Unix(time.Now().Add(2*time.Second).Unix(), 0)
+	ticket, expiry, err := s.GenerateWSTicket(ctx, user, "session", accessExp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiry.After(accessExp) {
+		t.Errorf("ticket expires at %v, after the access token at %v", expiry, accessExp)
+	}
+
+	token, err := s.ValidateWSTicket(ctx, ticket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionExp, err := s.SessionExpiry(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sessionExp.Equal(accessExp) {
+		t.Errorf("connection ends at %v, want the access token expiry %v", sessionExp, accessExp)
+	}
+
+	if _, _, err := s.GenerateWSTicket(ctx, user, "session", time.Now().Add(-time.Second)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ticket for an expired access token: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestTokenTypesAreNotInterchangeable(t *testing.T) {
+	ctx := context.Background()
+	s := newTestAuthService()
+	user := &models.User{ID: "user"}
+
+	ticket, _, err := s.GenerateWSTicket(ctx, user, "session", time.Now().Add(15*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh, _, err := s.generateRefreshToken(user, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// middleware принимает только токены доступа
+	for name, token := range map[string]string{"ws ticket": ticket, "refresh token": refresh} {
+		if _, err := s.ValidateTokenType(token, "access"); err == nil {
+			t.Errorf("a %s was accepted as an access token", name)
+		}
+	}
+	if _, err := s.ValidateWSTicket(ctx, refresh); err == nil {
+		t.Errorf("a refresh token was accepted as a ws ticket")
+	}
+}
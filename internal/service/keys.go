@@ -0,0 +1,328 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"poshta/pkg/reqresp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported JWT signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const (
+	keyFileExt = ".pem"
+	rsaKeyBits = 2048
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// TokenKeys signs tokens and resolves the key to verify them with
+type TokenKeys interface {
+	Sign(claims jwt.Claims) (string, error)
+	Keyfunc(token *jwt.Token) (interface{}, error)
+	JWKS() reqresp.JWKS
+}
+
+// hmacKeys signs with a single shared secret (HS256). The secret is never published.
+type hmacKeys struct {
+	secret []byte
+}
+
+func NewHMACKeys(secret string) TokenKeys {
+	return &hmacKeys{secret: []byte(secret)}
+}
+
+func (k *hmacKeys) Sign(claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+}
+
+func (k *hmacKeys) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return k.secret, nil
+}
+
+func (k *hmacKeys) JWKS() reqresp.JWKS {
+	return reqresp.JWKS{Keys: []reqresp.JWK{}}
+}
+
+// signingKey is an asymmetric key pair identified by its kid
+type signingKey struct {
+	id        string
+	private   crypto.Signer
+	method    jwt.SigningMethod
+	createdAt time.Time
+}
+
+// KeyStore keeps the RS256 or EdDSA keys in a directory, one PKCS#8 PEM file
+// per key named <kid>.pem. The newest key of the configured algorithm signs;
+// older keys keep verifying until they have been superseded for longer than
+// retireAfter, after which they are retired and their files removed.
+type KeyStore struct {
+	mu               sync.RWMutex
+	dir              string
+	alg              string
+	rotationInterval time.Duration
+	retireAfter      time.Duration
+	keys             []*signingKey // oldest first
+}
+
+// NewKeyStore loads the keys from dir and creates the first key if there is none
+func NewKeyStore(dir, alg string, rotationInterval, retireAfter time.Duration) (*KeyStore, error) {
+	if alg != AlgRS256 && alg != AlgEdDSA {
+		return nil, fmt.Errorf("unsupported key algorithm %q", alg)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	ks := &KeyStore{
+		dir:              dir,
+		alg:              alg,
+		rotationInterval: rotationInterval,
+		retireAfter:      retireAfter,
+	}
+	if _, err := ks.Rotate(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Rotate reloads the key directory, creates a new signing key when the current
+// one is older than the rotation interval and retires superseded keys.
+// It reports whether a new key was created.
+func (ks *KeyStore) Rotate() (bool, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	// другие инстансы могли записать ключи в тот же каталог
+	if err := ks.load(); err != nil {
+		return false, err
+	}
+
+	rotated := false
+	if active := ks.active(); active == nil || time.Since(active.createdAt) >= ks.rotationInterval {
+		key, err := ks.generate()
+		if err != nil {
+			return false, err
+		}
+		ks.keys = append(ks.keys, key)
+		rotated = true
+	}
+
+	return rotated, ks.retire()
+}
+
+func (ks *KeyStore) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	key := ks.active()
+	ks.mu.RUnlock()
+	if key == nil {
+		return "", ErrUnknownKey
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// Keyfunc returns the public key named by the token's kid if it is not retired
+func (ks *KeyStore) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	for _, key := range ks.keys {
+		if key.id != kid {
+			continue
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.private.Public(), nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// JWKS returns the public part of every non-retired key
+func (ks *KeyStore) JWKS() reqresp.JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := reqresp.JWKS{Keys: make([]reqresp.JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		jwk := reqresp.JWK{
+			Kid: key.id,
+			Use: "sig",
+			Alg: key.method.Alg(),
+		}
+		switch pub := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// active returns the newest key of the configured algorithm
+func (ks *KeyStore) active() *signingKey {
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		if ks.keys[i].method.Alg() == ks.alg {
+			return ks.keys[i]
+		}
+	}
+	return nil
+}
+
+// retire drops keys that were superseded by a newer key more than retireAfter ago
+func (ks *KeyStore) retire() error {
+	kept := ks.keys[:0]
+	for i, key := range ks.keys {
+		if i+1 < len(ks.keys) && time.Since(ks.keys[i+1].createdAt) > ks.retireAfter {
+			if err := os.Remove(ks.path(key.id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, key)
+	}
+	ks.keys = kept
+	return nil
+}
+
+// load reads every key file of the directory. The creation time of a key is the
+// modification time of its file.
+func (ks *KeyStore) load() error {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*signingKey, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), keyFileExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(ks.dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		key, err := parseSigningKey(strings.TrimSuffix(entry.Name(), keyFileExt), data)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", entry.Name(), err)
+		}
+		key.createdAt = info.ModTime()
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].createdAt.Before(keys[j].createdAt)
+	})
+	ks.keys = keys
+	return nil
+}
+
+// generate creates a key of the configured algorithm and writes it to the directory
+func (ks *KeyStore) generate() (*signingKey, error) {
+	var private crypto.Signer
+	switch ks.alg {
+	case AlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	case AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	id := fmt.Sprintf("%s-%s", now.UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(ks.path(id), data, 0o600); err != nil {
+		return nil, err
+	}
+
+	key, err := parseSigningKey(id, data)
+	if err != nil {
+		return nil, err
+	}
+	key.createdAt = now
+	return key, nil
+}
+
+func (ks *KeyStore) path(kid string) string {
+	return filepath.Join(ks.dir, kid+keyFileExt)
+}
+
+func parseSigningKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{id: kid}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.private = private
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.private = private
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
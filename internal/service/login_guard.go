@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"poshta/internal/domain/models"
+	"poshta/internal/repository"
+	"poshta/pkg/logger"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+// LoginThrottledError is returned while a username or an IP address has to wait
+// before the next login attempt
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string { return ErrTooManyAttempts.Error() }
+func (e *LoginThrottledError) Unwrap() error { return ErrTooManyAttempts }
+
+type LoginGuardConfig struct {
+	MaxFailuresPerUsername int           // failures before the username is locked
+	MaxFailuresPerIP       int           // failures before the IP address is locked
+	BackoffAfter           int           // failures allowed before the backoff starts
+	BackoffBase            time.Duration // delay after the first counted failure, doubled with every further one
+	BackoffMax             time.Duration
+	FailureWindow          time.Duration // failures older than this are forgotten
+	LockoutDuration        time.Duration
+}
+
+// LoginGuard slows down password guessing. Failed logins are counted per
+// username and per IP address; each failure past BackoffAfter doubles the wait
+// before the next attempt, and reaching the maximum locks the username or
+// address for LockoutDuration.
+type LoginGuard struct {
+	store repository.LoginAttemptRepository
+	audit repository.AuditRepository
+	cfg   LoginGuardConfig
+}
+
+func NewLoginGuard(store repository.LoginAttemptRepository, audit repository.AuditRepository, cfg LoginGuardConfig) *LoginGuard {
+	return &LoginGuard{
+		store: store,
+		audit: audit,
+		cfg:   cfg,
+	}
+}
+
+// Check returns a *LoginThrottledError if the username or the IP address has to wait
+func (g *LoginGuard) Check(ctx context.Context, username, ip string) error {
+	now := time.Now().UTC()
+
+	var wait time.Duration
+	for _, key := range g.keys(username, ip) {
+		attempt, err := g.store.Get(ctx, key.name)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		if attempt == nil {
+			continue
+		}
+
+		if attempt.LockedUntil != nil {
+			if now.Before(*attempt.LockedUntil) {
+				wait = max(wait, attempt.LockedUntil.Sub(now))
+				continue
+			}
+			if err := g.unlock(ctx, key.name, now); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if now.Sub(attempt.LastFailureAt) > g.cfg.FailureWindow {
+			continue
+		}
+		wait = max(wait, attempt.LastFailureAt.Add(g.backoff(attempt.Failures)).Sub(now))
+	}
+
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Fail counts a failed login and locks the username or the IP address once it
+// reaches its maximum
+func (g *LoginGuard) Fail(ctx context.Context, username, ip string) error {
+	now := time.Now().UTC()
+
+	for _, key := range g.keys(username, ip) {
+		attempt, err := g.store.RecordFailure(ctx, key.name, now, g.cfg.FailureWindow)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		if attempt.Failures < key.maxFailures || attempt.LockedUntil != nil {
+			continue
+		}
+
+		until := now.Add(g.cfg.LockoutDuration)
+		if err := g.store.Lock(ctx, key.name, until); err != nil {
+			return fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		logger.Info("Login locked", logrus.Fields{
+			"subject":      key.name,
+			"ip":           ip,
+			"failures":     attempt.Failures,
+			"locked_until": until,
+		})
+		g.record(ctx, models.AuditLoginLocked, key.name, ip, now)
+	}
+	return nil
+}
+
+// Succeed clears the failures of the username. The counter of the IP address is
+// kept, so logging in to an own account doesn't reset it.
+func (g *LoginGuard) Succeed(ctx context.Context, username string) error {
+	if err := g.store.Reset(ctx, usernameKey(username)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return nil
+}
+
+// Prune unlocks expired lockouts and forgets failures older than the window.
+// It is run periodically so the store doesn't grow without bound.
+func (g *LoginGuard) Prune(ctx context.Context) (int64, error) {
+	now := time.Now().UTC()
+
+	keys, err := g.store.ExpiredLocks(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if err := g.unlock(ctx, key, now); err != nil {
+			return 0, err
+		}
+	}
+
+	return g.store.DeleteStale(ctx, now.Add(-g.cfg.FailureWindow))
+}
+
+// unlock ends an expired lockout and records it, unless another instance did so first
+func (g *LoginGuard) unlock(ctx context.Context, key string, now time.Time) error {
+	unlocked, err := g.store.Unlock(ctx, key, now)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if !unlocked {
+		return nil
+	}
+
+	logger.Info("Login unlocked", logrus.Fields{"subject": key})
+	g.record(ctx, models.AuditLoginUnlocked, key, "", now)
+	return nil
+}
+
+// record writes an audit event. A failure is logged; it must not block logins.
+func (g *LoginGuard) record(ctx context.Context, eventType, subject, ip string, now time.Time) {
+	event := &models.AuditEvent{
+		Type:      eventType,
+		Subject:   subject,
+		IP:        ip,
+		CreatedAt: now,
+	}
+	if err := g.audit.Record(ctx, event); err != nil {
+		logger.Error("Failed to record audit event", err, logrus.Fields{
+			"type":    eventType,
+			"subject": subject,
+		})
+	}
+}
+
+// backoff returns how long to wait after the given number of failures
+func (g *LoginGuard) backoff(failures int) time.Duration {
+	n := failures - g.cfg.BackoffAfter
+	if n <= 0 {
+		return 0
+	}
+	// защита от переполнения при сдвиге
+	if n > 30 {
+		return g.cfg.BackoffMax
+	}
+	return min(g.cfg.BackoffBase<<(n-1), g.cfg.BackoffMax)
+}
+
+type attemptKey struct {
+	name        string
+	maxFailures int
+}
+
+func (g *LoginGuard) keys(username, ip string) []attemptKey {
+	keys := []attemptKey{{name: usernameKey(username), maxFailures: g.cfg.MaxFailuresPerUsername}}
+	if ip != "" {
+		keys = append(keys, attemptKey{name: "ip:" + ip, maxFailures: g.cfg.MaxFailuresPerIP})
+	}
+	return keys
+}
+
+func usernameKey(username string) string {
+	return "username:" + strings.ToLower(strings.TrimSpace(username))
+}
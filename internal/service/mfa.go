@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"poshta/internal/domain/models"
+	"poshta/pkg/reqresp"
+	"poshta/pkg/totp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidOTP         = errors.New("invalid one-time code")
+	ErrTOTPNotEnrolled    = errors.New("two-factor authentication is not enrolled")
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+)
+
+const (
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 10 // 16 base32 characters
+
+	// totpSkew is how many 30 second steps of clock drift are tolerated each way
+	totpSkew = 1
+)
+
+// EnrollTOTP starts a TOTP enrollment. It stays pending until ActivateTOTP
+// verifies the first code; enrolling again replaces a pending secret.
+func (s *authService) EnrollTOTP(ctx context.Context, user *models.User) (*reqresp.TOTPEnrollResponse, error) {
+	existing, err := s.totpRepo.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if existing != nil && existing.EnabledAt != nil {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	if err := s.totpRepo.Save(ctx, &models.TOTP{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	return &reqresp.TOTPEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(s.jwtCfg.TOTPIssuer, user.Username, secret),
+	}, nil
+}
+
+// ActivateTOTP enables a pending enrollment once the user proves it works with a
+// first code, and returns a fresh set of recovery codes
+func (s *authService) ActivateTOTP(ctx context.Context, userID, code string) (*reqresp.RecoveryCodesResponse, error) {
+	enrollment, err := s.totpRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if enrollment == nil {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if enrollment.EnabledAt != nil {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidOTP
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	if err := s.totpRepo.Enable(ctx, userID, step, hashes); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	return &reqresp.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableTOTP turns two-factor authentication off. It has to be confirmed with a
+// current code or a recovery code.
+func (s *authService) DisableTOTP(ctx context.Context, userID string, req reqresp.TOTPCodeRequest) error {
+	if err := s.verifySecondFactor(ctx, userID, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+
+	if err := s.totpRepo.Delete(ctx, userID); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return nil
+}
+
+// LoginMFA completes a login started by Login for an account with two-factor
+// authentication and issues the tokens
+func (s *authService) LoginMFA(ctx context.Context, req reqresp.MFALoginRequest) (*reqresp.AuthResponse, error) {
+	token, err := s.ValidateTokenType(req.MFAToken, "mfa")
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	userID, ok := claims["sub"].(string)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	deviceName, _ := claims["device_name"].(string)
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil, ErrInvalidToken
+	}
+
+	// коды подбираются так же, как пароли
+	if err := s.guard.Check(ctx, user.Username, req.IP); err != nil {
+		return nil, err
+	}
+	if err := s.verifySecondFactor(ctx, userID, req.Code, req.RecoveryCode); err != nil {
+		if errors.Is(err, ErrInvalidOTP) {
+			if err := s.guard.Fail(ctx, user.Username, req.IP); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+	if err := s.guard.Succeed(ctx, user.Username); err != nil {
+		return nil, err
+	}
+
+	return s.startSession(ctx, user, deviceName, req.UserAgent, req.IP)
+}
+
+// verifySecondFactor accepts either a TOTP code, each at most once, or an unused recovery code
+func (s *authService) verifySecondFactor(ctx context.Context, userID, code, recoveryCode string) error {
+	enrollment, err := s.totpRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if enrollment == nil || enrollment.EnabledAt == nil {
+		return ErrTOTPNotEnrolled
+	}
+
+	if recoveryCode != "" {
+		used, err := s.totpRepo.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		if !used {
+			return ErrInvalidOTP
+		}
+		return nil
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return ErrInvalidOTP
+	}
+	used, err := s.totpRepo.UseStep(ctx, userID, step)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if !used {
+		// код уже использован
+		return ErrInvalidOTP
+	}
+	return nil
+}
+
+// generateMFAToken creates the short-lived token that links the password step
+// of a login to the second factor step
+func (s *authService) generateMFAToken(user *models.User, deviceName string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":         user.ID,
+		"exp":         now.Add(s.jwtCfg.MFATokenTTL).Unix(),
+		"iat":         now.Unix(),
+		"iss":         s.jwtCfg.Issuer,
+		"type":        "mfa",
+		"device_name": deviceName,
+	}
+	return s.jwtCfg.Keys.Sign(claims)
+}
+
+// generateRecoveryCode returns a random code formatted as XXXX-XXXX-XXXX-XXXX
+func generateRecoveryCode() (string, error) {
+	raw := make([]byte, recoveryCodeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	encoded := base32.StdEncoding.EncodeToString(raw)
+
+	groups := make([]string, 0, len(encoded)/4)
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:i+4])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// normalizeRecoveryCode makes recovery codes comparable regardless of case and separators
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
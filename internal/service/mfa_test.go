@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"poshta/internal/domain/models"
+	"poshta/pkg/totp"
+	"testing"
+	"time"
+)
+
+// memoryTOTPRepository keeps one enrollment and mirrors the step bookkeeping of
+// the SQL repository: a step is accepted only if it is newer than the last used one
+type memoryTOTPRepository struct {
+	enrollment    *models.TOTP
+	recoveryCodes map[string]bool // hash -> used
+}
+
+func (r *memoryTOTPRepository) GetByUserID(ctx context.Context, userID string) (*models.TOTP, error) {
+	if r.enrollment == nil || r.enrollment.UserID != userID {
+		return nil, nil
+	}
+	return r.enrollment, nil
+}
+
+func (r *memoryTOTPRepository) Save(ctx context.Context, t *models.TOTP) error {
+	r.enrollment = t
+	return nil
+}
+
+func (r *memoryTOTPRepository) Enable(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	now := time.Now()
+	r.enrollment.EnabledAt = &now
+	r.enrollment.LastUsedStep = step
+	r.recoveryCodes = make(map[string]bool)
+	for _, h := range recoveryCodeHashes {
+		r.recoveryCodes[h] = false
+	}
+	return nil
+}
+
+func (r *memoryTOTPRepository) UseStep(ctx context.Context, userID string, step int64) (bool, error) {
+	if r.enrollment.LastUsedStep >= step {
+		return false, nil
+	}
+	r.enrollment.LastUsedStep = step
+	return true, nil
+}
+
+func (r *memoryTOTPRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) (bool, error) {
+	used, ok := r.recoveryCodes[codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	r.recoveryCodes[codeHash] = true
+	return true, nil
+}
+
+func (r *memoryTOTPRepository) Delete(ctx context.Context, userID string) error {
+	r.enrollment = nil
+	return nil
+}
+
+func TestVerifySecondFactorRejectsReusedCode(t *testing.T) {
+	ctx := context.Background()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	repo := &memoryTOTPRepository{enrollment: &models.TOTP{
+		UserID:       "user",
+		Secret:       secret,
+		EnabledAt:    &now,
+		LastUsedStep: totp.Step(now) - 2,
+	}}
+	s := &authService{totpRepo: repo}
+
+	code, err := totp.Code(secret, totp.Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verifySecondFactor(ctx, "user", code, ""); err != nil {
+		t.Fatalf("first use of a code: %v", err)
+	}
+	if err := s.verifySecondFactor(ctx, "user", code, ""); !errors.Is(err, ErrInvalidOTP) {
+		t.Fatalf("second use of a code: got %v, want ErrInvalidOTP", err)
+	}
+
+	// код предыдущего шага всё ещё в окне, но старше использованного
+	previous, err := totp.Code(secret, totp.Step(now)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verifySecondFactor(ctx, "user", previous, ""); !errors.Is(err, ErrInvalidOTP) {
+		t.Fatalf("code of an earlier step: got %v, want ErrInvalidOTP", err)
+	}
+}
+
+func TestVerifySecondFactorRejectsReusedRecoveryCode(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	code, err := generateRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &memoryTOTPRepository{
+		enrollment:    &models.TOTP{UserID: "user", EnabledAt: &now},
+		recoveryCodes: map[string]bool{hashToken(normalizeRecoveryCode(code)): false},
+	}
+	s := &authService{totpRepo: repo}
+
+	if err := s.verifySecondFactor(ctx, "user", "", code); err != nil {
+		t.Fatalf("first use of a recovery code: %v", err)
+	}
+	if err := s.verifySecondFactor(ctx, "user", "", code); !errors.Is(err, ErrInvalidOTP) {
+		t.Fatalf("second use of a recovery code: got %v, want ErrInvalidOTP", err)
+	}
+}
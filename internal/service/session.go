@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"poshta/internal/domain/models"
+	"poshta/internal/repository"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionDisconnector closes the live connections opened within a session.
+// It is implemented by ws.Hub.
+type SessionDisconnector interface {
+	DisconnectSession(sessionID string)
+}
+
+// SessionService lists and revokes the logins of a user
+type SessionService interface {
+	GetSessions(ctx context.Context, userID, currentSessionID string) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+}
+
+type sessionService struct {
+	sessionRepo  repository.SessionRepository
+	disconnector SessionDisconnector
+}
+
+func NewSessionService(sessionRepo repository.SessionRepository, disconnector SessionDisconnector) SessionService {
+	return &sessionService{
+		sessionRepo:  sessionRepo,
+		disconnector: disconnector,
+	}
+}
+
+// GetSessions returns the active sessions of the user, marking the one the request was made from
+func (s *sessionService) GetSessions(ctx context.Context, userID, currentSessionID string) ([]models.Session, error) {
+	sessions, err := s.sessionRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends a session of the user: its refresh tokens stop working and
+// its WebSocket connections are closed
+func (s *sessionService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	session, err := s.sessionRepo.GetByID(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if session == nil || session.UserID != userID || session.RevokedAt != nil {
+		return ErrSessionNotFound
+	}
+
+	return revokeSession(ctx, s.sessionRepo, s.disconnector, sessionID)
+}
+
+func revokeSession(ctx context.Context, sessionRepo repository.SessionRepository, disconnector SessionDisconnector, sessionID string) error {
+	if err := sessionRepo.Revoke(ctx, sessionID); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	disconnector.DisconnectSession(sessionID)
+	return nil
+}
@@ -0,0 +1,300 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"poshta/internal/domain/models"
+	"poshta/internal/repository"
+	"poshta/pkg/reqresp"
+	"poshta/pkg/storage"
+	"time"
+)
+
+var (
+	ErrInvalidAttachmentKind = errors.New("kind must be file, image or voice")
+	ErrInvalidAttachmentSize = errors.New("size must be positive")
+	ErrAttachmentTooLarge    = errors.New("attachment exceeds the size limit for its kind")
+	ErrInvalidChunk          = errors.New("chunk must not be empty, larger than the chunk size or past the end of the attachment")
+	ErrChunkOffset           = errors.New("chunk offset does not match the received size of the upload")
+	ErrAttachmentIncomplete  = errors.New("attachment upload is not complete")
+	ErrAttachmentUnavailable = errors.New("attachment is not a complete, unsent upload of yours in this chat")
+	ErrTooManyAttachments    = errors.New("too many attachments in one message")
+	ErrAttachmentNotFound    = &policyError{kind: ErrNotFound, msg: "attachment not found"}
+)
+
+// maxAttachmentsPerMessage caps the attachments a single message can reference
+const maxAttachmentsPerMessage = 10
+
+// orphanBatchSize is how many orphaned attachments are collected per query
+const orphanBatchSize = 100
+
+// AttachmentConfig limits attachment uploads
+type AttachmentConfig struct {
+	MaxFileSize  int64 // bytes, per attachment of each kind
+	MaxImageSize int64
+	MaxVoiceSize int64
+	ChunkSize    int64         // largest chunk accepted per request
+	OrphanTTL    time.Duration // unsent attachments are deleted after this long
+}
+
+// AttachmentService stores encrypted attachments for the user in the context.
+// Clients encrypt a file, create an upload in the chat, send the ciphertext in
+// chunks and reference the finished attachment in a message. Only chat members
+// can download it. DeleteOrphans is a system operation and needs no user.
+type AttachmentService interface {
+	CreateUpload(ctx context.Context, chatID string, req reqresp.CreateAttachmentRequest) (*models.Attachment, error)
+	UploadChunk(ctx context.Context, attachmentID string, offset int64, chunk io.Reader) (*models.Attachment, error)
+	GetAttachment(ctx context.Context, attachmentID string) (*models.Attachment, error)
+	OpenAttachment(ctx context.Context, attachmentID string) (*models.Attachment, io.ReadCloser, error)
+	DeleteOrphans(ctx context.Context) (int, error)
+}
+
+type attachmentService struct {
+	attachmentRepo repository.AttachmentRepository
+	storage        storage.Storage
+	policy         *Policy
+	cfg            AttachmentConfig
+}
+
+func NewAttachmentService(attachmentRepo repository.AttachmentRepository, storage storage.Storage, policy *Policy, cfg AttachmentConfig) AttachmentService {
+	return &attachmentService{
+		attachmentRepo: attachmentRepo,
+		storage:        storage,
+		policy:         policy,
+		cfg:            cfg,
+	}
+}
+
+// CreateUpload starts the upload of an attachment of the given kind and
+// ciphertext size to a chat the actor is a member of
+func (s *attachmentService) CreateUpload(ctx context.Context, chatID string, req reqresp.CreateAttachmentRequest) (*models.Attachment, error) {
+	actor, chat, _, err := s.policy.ChatMember(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.policy.requireVerified(actor, s.policy.restrictions.SendMessage); err != nil {
+		return nil, err
+	}
+
+	var limit int64
+	switch req.Kind {
+	case models.AttachmentKindFile:
+		limit = s.cfg.MaxFileSize
+	case models.AttachmentKindImage:
+		limit = s.cfg.MaxImageSize
+	case models.AttachmentKindVoice:
+		limit = s.cfg.MaxVoiceSize
+	default:
+		return nil, ErrInvalidAttachmentKind
+	}
+	if req.Size <= 0 {
+		return nil, ErrInvalidAttachmentSize
+	}
+	if req.Size > limit {
+		return nil, ErrAttachmentTooLarge
+	}
+
+	attachment := &models.Attachment{
+		ChatID:     chat.ID,
+		UploaderID: actor.ID,
+		Kind:       req.Kind,
+		Size:       req.Size,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	if _, err := s.attachmentRepo.Create(ctx, attachment); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return attachment, nil
+}
+
+// UploadChunk appends the next chunk of the actor's upload. offset must equal
+// the bytes received so far; after an interruption the client asks for the
+// attachment and continues from Received.
+func (s *attachmentService) UploadChunk(ctx context.Context, attachmentID string, offset int64, chunk io.Reader) (*models.Attachment, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment, err := s.attachmentRepo.GetByID(ctx, attachmentID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if attachment == nil || attachment.UploaderID != actor.ID {
+		return nil, ErrAttachmentNotFound
+	}
+	if attachment.CompletedAt != nil || offset != attachment.Received {
+		return nil, ErrChunkOffset
+	}
+
+	// чанк читается целиком: его размер нужен хранилищу заранее
+	data, err := io.ReadAll(io.LimitReader(chunk, s.cfg.ChunkSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChunk, err)
+	}
+	size := int64(len(data))
+	if size == 0 || size > s.cfg.ChunkSize || offset+size > attachment.Size {
+		return nil, ErrInvalidChunk
+	}
+
+	key, err := chunkKey(attachment.ID, attachment.ChunkCount)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if err := s.storage.Put(ctx, key, bytes.NewReader(data), size); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	updated, err := s.attachmentRepo.AddChunk(ctx, attachment.ID, offset, models.AttachmentChunk{
+		StorageKey: key,
+		Size:       size,
+	})
+	if err != nil || updated == nil {
+		// параллельная загрузка того же чанка или удалённая загрузка: блоб никому не нужен
+		_ = s.storage.Delete(context.WithoutCancel(ctx), key)
+	}
+	if errors.Is(err, repository.ErrChunkOffset) {
+		return nil, ErrChunkOffset
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if updated == nil {
+		return nil, ErrAttachmentNotFound
+	}
+	return updated, nil
+}
+
+// GetAttachment returns an attachment of a chat the actor is a member of
+func (s *attachmentService) GetAttachment(ctx context.Context, attachmentID string) (*models.Attachment, error) {
+	attachment, err := s.attachmentRepo.GetByID(ctx, attachmentID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if attachment == nil {
+		return nil, ErrAttachmentNotFound
+	}
+
+	if _, _, _, err := s.policy.ChatMember(ctx, attachment.ChatID); err != nil {
+		// не раскрываем вложения тем, кто не состоит в чате
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// OpenAttachment returns a complete attachment together with its ciphertext
+func (s *attachmentService) OpenAttachment(ctx context.Context, attachmentID string) (*models.Attachment, io.ReadCloser, error) {
+	attachment, err := s.GetAttachment(ctx, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if attachment.CompletedAt == nil {
+		return nil, nil, ErrAttachmentIncomplete
+	}
+
+	chunks, err := s.attachmentRepo.GetChunks(ctx, attachment.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	keys := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		keys = append(keys, c.StorageKey)
+	}
+	return attachment, &chunkReader{ctx: ctx, storage: s.storage, keys: keys}, nil
+}
+
+// DeleteOrphans deletes attachments older than OrphanTTL that no message
+// references, together with their blobs
+func (s *attachmentService) DeleteOrphans(ctx context.Context) (int, error) {
+	deleted := 0
+	for {
+		orphans, err := s.attachmentRepo.GetOrphans(ctx, time.Now().UTC().Add(-s.cfg.OrphanTTL), orphanBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		for _, orphan := range orphans {
+			ok, err := s.attachmentRepo.DeleteOrphan(ctx, orphan.ID, func(chunks []models.AttachmentChunk) error {
+				for _, c := range chunks {
+					if err := s.storage.Delete(ctx, c.StorageKey); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return deleted, err
+			}
+			if ok {
+				deleted++
+			}
+		}
+
+		if len(orphans) < orphanBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// chunkKey names the blob of a chunk. The random suffix keeps concurrent uploads
+// of the same chunk from overwriting each other's blob.
+func chunkKey(attachmentID string, index int) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("attachments/%s/%06d-%s", attachmentID, index, hex.EncodeToString(suffix)), nil
+}
+
+// chunkReader reads the blobs of an attachment one after another, opening each
+// only when the previous one is exhausted
+type chunkReader struct {
+	ctx     context.Context
+	storage storage.Storage
+	keys    []string
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			blob, err := r.storage.Get(r.ctx, r.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current = blob
+			r.keys = r.keys[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
@@ -0,0 +1,141 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"io"
+	"poshta/pkg/storage"
+	"strings"
+	"testing"
+)
+
+// memoryStorage keeps blobs in memory and remembers which ones were opened
+type memoryStorage struct {
+	blobs  map[string]string
+	opened []string
+	open   int
+}
+
+func (s *memoryStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	s.blobs[key] = string(data)
+	return nil
+}
+
+func (s *memoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	s.opened = append(s.opened, key)
+	s.open++
+	return &memoryBlob{Reader: strings.NewReader(data), storage: s}, nil
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, key string) error {
+	delete(s.blobs, key)
+	return nil
+}
+
+type memoryBlob struct {
+	*strings.Reader
+	storage *memoryStorage
+	closed  bool
+}
+
+func (b *memoryBlob) Close() error {
+	if !b.closed {
+		b.closed = true
+		b.storage.open--
+	}
+	return nil
+}
+
+func newChunkStorage() (*memoryStorage, []string) {
+	s := &memoryStorage{blobs: map[string]string{
+		"c0": "abc",
+		"c1": "",
+		"c2": "defgh",
+		"c3": "i",
+		"c4": "jklmnopqrstuvwxyz",
+	}}
+	return s, []string{"c0", "c1", "c2", "c3", "c4"}
+}
+
+func TestChunkReaderAcrossBoundaries(t *testing.T) {
+	const want = "abcdefghijklmnopqrstuvwxyz"
+
+	for _, bufSize := range []int{1, 2, 3, 4, 7, 64} {
+		s, keys := newChunkStorage()
+		r := &chunkReader{ctx: context.Background(), storage: s, keys: keys}
+
+		var got []byte
+		buf := make([]byte, bufSize)
+		for {
+			n, err := r.Read(buf)
+			if n > bufSize {
+				t.Fatalf("buffer %d: Read returned %d bytes", bufSize, n)
+			}
+			got = append(got, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("buffer %d: Read: %v", bufSize, err)
+			}
+		}
+
+		if string(got) != want {
+			t.Errorf("buffer %d: read %q, want %q", bufSize, got, want)
+		}
+		if strings.Join(s.opened, ",") != strings.Join(keys, ",") {
+			t.Errorf("buffer %d: opened %v, want every chunk once in order", bufSize, s.opened)
+		}
+		if s.open != 0 {
+			t.Errorf("buffer %d: %d chunks left open", bufSize, s.open)
+		}
+		if n, err := r.Read(buf); n != 0 || err != io.EOF {
+			t.Errorf("buffer %d: Read after the end = %d, %v, want 0, EOF", bufSize, n, err)
+		}
+	}
+}
+
+func TestChunkReaderOpensLazily(t *testing.T) {
+	s, keys := newChunkStorage()
+	r := &chunkReader{ctx: context.Background(), storage: s, keys: keys}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.opened) != 1 {
+		t.Errorf("opened %v after reading inside the first chunk", s.opened)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s.open != 0 {
+		t.Errorf("Close left %d chunks open", s.open)
+	}
+}
+
+func TestChunkReaderMissingChunk(t *testing.T) {
+	s, keys := newChunkStorage()
+	delete(s.blobs, "c2")
+	r := &chunkReader{ctx: context.Background(), storage: s, keys: keys}
+
+	got, err := io.ReadAll(r)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("ReadAll = %v, want ErrNotFound", err)
+	}
+	if string(got) != "abc" {
+		t.Errorf("read %q before the missing chunk, want %q", got, "abc")
+	}
+	if s.open != 0 {
+		t.Errorf("%d chunks left open", s.open)
+	}
+}
@@ -14,31 +14,80 @@ var (
 	ErrUserExists         = errors.New("user already exists")
 	ErrInternal           = errors.New("internal error")
 	ErrInvalidToken       = errors.New("invalid token")
-	ErrUserNotFound       = errors.New("user not found")
+	ErrNotGroupChat       = errors.New("chat is not a group chat")
+	ErrAlreadyChatMember  = errors.New("user is already a chat member")
+	ErrInvalidChatUsers   = errors.New("a chat needs two different users")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrInvalidSettings    = errors.New("invalid chat settings")
+	ErrInvalidCursor      = errors.New("before and after cannot be used together")
 )
 
+const (
+	DefaultMessagesPageSize = 50
+	MaxMessagesPageSize     = 200
+)
+
+// ChatService operates on chats on behalf of the user in the context, see Policy
 type ChatService interface {
 	CreateChat(ctx context.Context, chat reqresp.CreateChatRequest) (models.Chat, error)
+	CreateGroupChat(ctx context.Context, req reqresp.CreateGroupChatRequest) (models.Chat, error)
 	GetUserChats(ctx context.Context, userID string) ([]reqresp.GetChatResponse, error)
 	GetChatByID(ctx context.Context, chatID string) (*models.Chat, error)
-	GetChatMessages(ctx context.Context, chatID string, userID string) (reqresp.Chat, error)
+	GetChatMessages(ctx context.Context, chatID, deviceID string, cursor models.MessageCursor) (reqresp.Chat, error)
 	DeleteChat(ctx context.Context, chatID string) (string, error)
+
+	GetChatMembers(ctx context.Context, chatID string) ([]models.ChatMember, error)
+	AddMember(ctx context.Context, chatID, userID string) error
+	RemoveMember(ctx context.Context, chatID, userID string) error
+	PromoteMember(ctx context.Context, chatID, userID, role string) error
+	LeaveChat(ctx context.Context, chatID string) error
+	UpdateSettings(ctx context.Context, chatID string, req reqresp.ChatSettingsRequest) (models.Chat, error)
 }
 
 type chatService struct {
-	chatRepo repository.ChatRepository
-	userRepo repository.UserRepository
+	chatRepo         repository.ChatRepository
+	userRepo         repository.UserRepository
+	verificationRepo repository.KeyVerificationRepository
+	policy           *Policy
 }
 
-func NewChatService(chatRepo repository.ChatRepository, userRepo repository.UserRepository) ChatService {
+func NewChatService(chatRepo repository.ChatRepository, userRepo repository.UserRepository, verificationRepo repository.KeyVerificationRepository, policy *Policy) ChatService {
     return &chatService{
-        chatRepo: chatRepo,
-		userRepo: userRepo,
+        chatRepo:         chatRepo,
+		userRepo:         userRepo,
+		verificationRepo: verificationRepo,
+		policy:           policy,
     }
 }
 
 
+// CreateChat opens a direct chat between the actor and another user. User1ID
+// defaults to the actor, and the actor must be one of the two users. The other
+// user can be given by username instead of User2ID.
 func (s *chatService) CreateChat(ctx context.Context, req reqresp.CreateChatRequest) (models.Chat, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return models.Chat{}, err
+	}
+	if err := s.policy.requireVerified(actor, s.policy.restrictions.CreateChat); err != nil {
+		return models.Chat{}, err
+	}
+	if req.User1ID == "" {
+		req.User1ID = actor.ID
+	}
+	if req.User2ID == "" && req.Username != "" {
+		user, err := s.policy.FindUser(ctx, actor, "", req.Username)
+		if err != nil {
+			return models.Chat{}, err
+		}
+		req.User2ID = user.ID
+	}
+	if req.User1ID != actor.ID && req.User2ID != actor.ID {
+		return models.Chat{}, ErrForbidden
+	}
+	if req.User1ID == req.User2ID {
+		return models.Chat{}, ErrInvalidChatUsers
+	}
 
 	// check users exist
 	existingUser1, err := s.userRepo.GetByID(ctx, req.User1ID)
@@ -68,12 +117,76 @@ func (s *chatService) CreateChat(ctx context.Context, req reqresp.CreateChatRequ
 		return *existingChat, nil
 	}
 
+	otherID := req.User2ID
+	if otherID == actor.ID {
+		otherID = req.User1ID
+	}
+	if err := s.policy.Reachable(ctx, actor, otherID); err != nil {
+		return models.Chat{}, err
+	}
+
 	// Create chat
 	chat := models.Chat{
+		Type:    models.ChatTypeDirect,
 		User1ID: req.User1ID,
 		User2ID: req.User2ID,
 	}
-	chatID, err := s.chatRepo.Create(ctx, &chat)
+	members := []models.ChatMember{
+		{UserID: req.User1ID, Role: models.ChatRoleMember},
+		{UserID: req.User2ID, Role: models.ChatRoleMember},
+	}
+
+	return s.create(ctx, &chat, members)
+}
+
+// CreateGroupChat creates a group owned by the actor with the given initial members
+func (s *chatService) CreateGroupChat(ctx context.Context, req reqresp.CreateGroupChatRequest) (models.Chat, error) {
+	if req.Title == "" {
+		return models.Chat{}, errors.New("title is required")
+	}
+
+	owner, err := s.policy.Actor(ctx)
+	if err != nil {
+		return models.Chat{}, err
+	}
+	if err := s.policy.requireVerified(owner, s.policy.restrictions.CreateChat); err != nil {
+		return models.Chat{}, err
+	}
+	ownerID := owner.ID
+
+	members := []models.ChatMember{{UserID: ownerID, Role: models.ChatRoleOwner}}
+	seen := map[string]bool{ownerID: true}
+
+	for _, memberID := range req.MemberIDs {
+		if seen[memberID] {
+			continue
+		}
+		seen[memberID] = true
+
+		user, err := s.userRepo.GetByID(ctx, memberID)
+		if err != nil {
+			return models.Chat{}, fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		if user == nil {
+			return models.Chat{}, ErrUserNotFound
+		}
+		if err := s.policy.Reachable(ctx, owner, memberID); err != nil {
+			return models.Chat{}, err
+		}
+		members = append(members, models.ChatMember{UserID: memberID, Role: models.ChatRoleMember})
+	}
+
+	chat := models.Chat{
+		Type:      models.ChatTypeGroup,
+		Title:     req.Title,
+		AvatarURL: req.AvatarURL,
+	}
+
+	return s.create(ctx, &chat, members)
+}
+
+func (s *chatService) create(ctx context.Context, chat *models.Chat, members []models.ChatMember) (models.Chat, error) {
+	chatID, err := s.chatRepo.Create(ctx, chat, members)
 	if err != nil {
 		return models.Chat{}, err
 	}
@@ -89,10 +202,15 @@ func (s *chatService) CreateChat(ctx context.Context, req reqresp.CreateChatRequ
 	
 	return *chatPtr, nil
 }
+
 // get chats of users
 
 func (s *chatService) GetUserChats(ctx context.Context, userID string) ([]reqresp.GetChatResponse, error) {
-	
+	// только свои чаты
+	if _, err := s.policy.RequireUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
 	existingUser, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
@@ -108,8 +226,18 @@ func (s *chatService) GetUserChats(ctx context.Context, userID string) ([]reqres
 		return nil, err
 	}
 
-	var responses []reqresp.GetChatResponse
+	responses := make([]reqresp.GetChatResponse, 0, len(chats))
 	for _, chat := range chats {
+		if chat.Type == models.ChatTypeGroup {
+			responses = append(responses, reqresp.GetChatResponse{
+				ChatID:    chat.ID,
+				Type:      chat.Type,
+				Title:     chat.Title,
+				AvatarURL: chat.AvatarURL,
+			})
+			continue
+		}
+
 		// Determine the other user in the chat
 		var otherUserID string
 		if chat.User1ID == userID {
@@ -127,32 +255,74 @@ func (s *chatService) GetUserChats(ctx context.Context, userID string) ([]reqres
 			continue // or return error if you want strict behavior
 		}
 
-		responses = append(responses, reqresp.GetChatResponse{
-			ChatID:   chat.ID,
-			UserID:   otherUser.ID,
-			Username: otherUser.Username,
+		verification, err := s.verificationRepo.Get(ctx, userID, otherUser.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+
+		response := reqresp.GetChatResponse{
+			ChatID:    chat.ID,
+			Type:      chat.Type,
+			UserID:    otherUser.ID,
+			Username:  otherUser.Username,
 			PublicKey: otherUser.PublicKey,
-		})
+			PublicKeyVersion: otherUser.PublicKeyVersion,
+		}
+		if verification != nil {
+			response.KeyVerified = verification.VerifiedAt != nil && verification.KeyVersion == otherUser.PublicKeyVersion
+			response.KeyChanged = verification.KeyChangedAt != nil
+		}
+		responses = append(responses, response)
 	}
 
 	return responses, nil
 }
 
 func (s *chatService) GetChatByID(ctx context.Context, chatID string) (*models.Chat, error) {
-	return s.chatRepo.GetByID(ctx, chatID)
-
+	_, chat, _, err := s.policy.ChatMember(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	return chat, nil
 }
 
-func (s *chatService) GetChatMessages(ctx context.Context, chatID string, userID string) (reqresp.Chat, error) {
-	messages, err := s.chatRepo.GetMessages(ctx, chatID)
+// GetChatMessages returns a page of chat history together with the cursors of
+// the neighbouring pages
+func (s *chatService) GetChatMessages(ctx context.Context, chatID, deviceID string, cursor models.MessageCursor) (reqresp.Chat, error) {
+	actor, chat, _, err := s.policy.ChatMember(ctx, chatID)
 	if err != nil {
 		return reqresp.Chat{}, err
 	}
+	userID := actor.ID
+
+	if cursor.Before > 0 && cursor.After > 0 {
+		return reqresp.Chat{}, ErrInvalidCursor
+	}
+	if cursor.Limit <= 0 {
+		cursor.Limit = DefaultMessagesPageSize
+	}
+	if cursor.Limit > MaxMessagesPageSize {
+		cursor.Limit = MaxMessagesPageSize
+	}
 
-	chat, err := s.chatRepo.GetByID(ctx, chatID)
+	limit := cursor.Limit
+	cursor.Limit++ // одно лишнее сообщение показывает, есть ли следующая страница
+	messages, err := s.chatRepo.GetMessages(ctx, chatID, userID, deviceID, cursor)
 	if err != nil {
 		return reqresp.Chat{}, err
 	}
+	messages, prevCursor, nextCursor := paginate(messages, cursor, limit)
+
+	if chat.Type == models.ChatTypeGroup {
+		return reqresp.Chat{
+			ChatID:     chat.ID,
+			Type:       chat.Type,
+			Title:      chat.Title,
+			Messages:   messages,
+			NextCursor: nextCursor,
+			PrevCursor: prevCursor,
+		}, nil
+	}
 
 	var otherUserID string
 
@@ -166,18 +336,224 @@ func (s *chatService) GetChatMessages(ctx context.Context, chatID string, userID
 	if err != nil {
 		return reqresp.Chat{}, err
 	}
+	if otherUser == nil {
+		return reqresp.Chat{}, ErrUserNotFound
+	}
 
 	return reqresp.Chat{
-		ChatID:   chat.ID,
-		Username: otherUser.Username,
-		Messages: messages,	
+		ChatID:     chat.ID,
+		Type:       chat.Type,
+		Username:   otherUser.Username,
+		Messages:   messages,
+		NextCursor: nextCursor,
+		PrevCursor: prevCursor,
 	}, nil
 
 
 	
 }
 
+// DeleteChat deletes a chat for everyone. Any member can delete a direct chat,
+// only the owner can delete a group.
 func (s* chatService) DeleteChat(ctx context.Context, chatID string) (string, error) {
-	// _, chats := s.GetUserChats(ctx, )
+	_, chat, member, err := s.policy.ChatMember(ctx, chatID)
+	if err != nil {
+		return "", err
+	}
+	if chat.Type == models.ChatTypeGroup && member.Role != models.ChatRoleOwner {
+		return "", ErrForbidden
+	}
+
 	return s.chatRepo.Delete(ctx, chatID)
-}
\ No newline at end of file
+}
+
+func (s *chatService) GetChatMembers(ctx context.Context, chatID string) ([]models.ChatMember, error) {
+	if _, _, _, err := s.policy.ChatMember(ctx, chatID); err != nil {
+		return nil, err
+	}
+	return s.chatRepo.GetMembers(ctx, chatID)
+}
+
+// AddMember adds userID to a group chat. Only owners and admins can add members.
+func (s *chatService) AddMember(ctx context.Context, chatID, userID string) error {
+	if _, err := s.groupActor(ctx, chatID, models.ChatRoleOwner, models.ChatRoleAdmin); err != nil {
+		return err
+	}
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if err := s.policy.Reachable(ctx, actor, userID); err != nil {
+		return err
+	}
+
+	existing, err := s.chatRepo.GetMember(ctx, chatID, userID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrAlreadyChatMember
+	}
+
+	return s.chatRepo.AddMember(ctx, &models.ChatMember{
+		ChatID: chatID,
+		UserID: userID,
+		Role:   models.ChatRoleMember,
+	})
+}
+
+// RemoveMember removes userID from a group chat. The owner can remove anyone,
+// admins can only remove regular members.
+func (s *chatService) RemoveMember(ctx context.Context, chatID, userID string) error {
+	user, err := s.policy.Actor(ctx)
+	if err != nil {
+		return err
+	}
+	if user.ID == userID {
+		return s.LeaveChat(ctx, chatID)
+	}
+
+	actor, err := s.groupActor(ctx, chatID, models.ChatRoleOwner, models.ChatRoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	target, err := s.chatRepo.GetMember(ctx, chatID, userID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return ErrNotChatMember
+	}
+	if target.Role == models.ChatRoleOwner ||
+		(actor.Role == models.ChatRoleAdmin && target.Role != models.ChatRoleMember) {
+		return ErrForbidden
+	}
+
+	return s.chatRepo.RemoveMember(ctx, chatID, userID)
+}
+
+// PromoteMember changes the role of userID to admin or back to member. Only the owner can do it.
+func (s *chatService) PromoteMember(ctx context.Context, chatID, userID, role string) error {
+	if role != models.ChatRoleAdmin && role != models.ChatRoleMember {
+		return ErrInvalidRole
+	}
+
+	if _, err := s.groupActor(ctx, chatID, models.ChatRoleOwner); err != nil {
+		return err
+	}
+
+	target, err := s.chatRepo.GetMember(ctx, chatID, userID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return ErrNotChatMember
+	}
+	if target.Role == models.ChatRoleOwner {
+		return ErrForbidden
+	}
+
+	return s.chatRepo.UpdateMemberRole(ctx, chatID, userID, role)
+}
+
+// LeaveChat removes the actor from a group chat. When the owner leaves, ownership
+// passes to the longest-standing admin, or member if there are no admins.
+// The chat is deleted when its last member leaves.
+func (s *chatService) LeaveChat(ctx context.Context, chatID string) error {
+	member, err := s.groupActor(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
+	return s.chatRepo.LeaveGroup(ctx, chatID, member.UserID)
+}
+
+// UpdateSettings changes chat settings such as the disappearing messages timer.
+// Any member of a direct chat may change them; in groups only owners and admins.
+func (s *chatService) UpdateSettings(ctx context.Context, chatID string, req reqresp.ChatSettingsRequest) (models.Chat, error) {
+	if req.MessageTTL < 0 {
+		return models.Chat{}, ErrInvalidSettings
+	}
+
+	_, chat, member, err := s.policy.ChatMember(ctx, chatID)
+	if err != nil {
+		return models.Chat{}, err
+	}
+	if chat.Type == models.ChatTypeGroup &&
+		member.Role != models.ChatRoleOwner && member.Role != models.ChatRoleAdmin {
+		return models.Chat{}, ErrForbidden
+	}
+
+	if err := s.chatRepo.UpdateSettings(ctx, chatID, req.MessageTTL); err != nil {
+		return models.Chat{}, err
+	}
+
+	chat.MessageTTL = req.MessageTTL
+	return *chat, nil
+}
+
+// paginate trims the extra message fetched beyond limit and works out the cursors
+// of the older (prev) and newer (next) pages, 0 meaning there is no such page
+func paginate(messages []models.Message, cursor models.MessageCursor, limit int) ([]models.Message, int64, int64) {
+	hasMore := len(messages) > limit
+	if hasMore {
+		if cursor.After > 0 {
+			messages = messages[:limit]
+		} else {
+			messages = messages[1:]
+		}
+	}
+	if len(messages) == 0 {
+		return messages, 0, 0
+	}
+
+	first, last := messages[0].ID, messages[len(messages)-1].ID
+	var prevCursor, nextCursor int64
+
+	if cursor.After > 0 {
+		prevCursor = first
+		if hasMore {
+			nextCursor = last
+		}
+		return messages, prevCursor, nextCursor
+	}
+
+	if hasMore {
+		prevCursor = first
+	}
+	if cursor.Before > 0 {
+		nextCursor = last
+	}
+	return messages, prevCursor, nextCursor
+}
+
+// groupActor checks that the actor is a member of a group chat holding one of
+// the given roles, or any role when none are given
+func (s *chatService) groupActor(ctx context.Context, chatID string, roles ...string) (*models.ChatMember, error) {
+	_, chat, member, err := s.policy.ChatMember(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if chat.Type != models.ChatTypeGroup {
+		return nil, ErrNotGroupChat
+	}
+
+	if len(roles) == 0 {
+		return member, nil
+	}
+	for _, role := range roles {
+		if member.Role == role {
+			return member, nil
+		}
+	}
+	return nil, ErrForbidden
+}
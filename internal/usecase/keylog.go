@@ -0,0 +1,193 @@
+package usecase
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"poshta/internal/repository"
+	"poshta/pkg/keylog"
+	"poshta/pkg/reqresp"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidTreeSize     = errors.New("tree size is out of range of the key log")
+	ErrKeyLogEntryNotFound = &policyError{kind: ErrNotFound, msg: "key log entry not found"}
+)
+
+// KeyLogService serves the append-only log of public keys: signed tree heads,
+// inclusion proofs for users' current keys and consistency proofs between tree heads
+type KeyLogService interface {
+	TreeHead(ctx context.Context) (*reqresp.SignedTreeHead, error)
+	InclusionProof(ctx context.Context, userID string, treeSize uint64) (*reqresp.KeyInclusionProof, error)
+	ConsistencyProof(ctx context.Context, first, second uint64) (*reqresp.KeyConsistencyProof, error)
+	PublicKey() reqresp.KeyLogPublicKey
+	AppendMissing(ctx context.Context) (int, error)
+}
+
+type keyLogService struct {
+	keyLogRepo repository.KeyLogRepository
+	signingKey ed25519.PrivateKey
+
+	mu     sync.Mutex
+	leaves [][]byte // лог только дописывается, поэтому загруженные хеши не устаревают
+	head   *reqresp.SignedTreeHead
+}
+
+func NewKeyLogService(keyLogRepo repository.KeyLogRepository, signingKey ed25519.PrivateKey) KeyLogService {
+	return &keyLogService{
+		keyLogRepo: keyLogRepo,
+		signingKey: signingKey,
+	}
+}
+
+// TreeHead returns the signed head of the log at its current size. A new head is
+// only signed when the log has grown.
+func (s *keyLogService) TreeHead(ctx context.Context) (*reqresp.SignedTreeHead, error) {
+	size, err := s.keyLogRepo.Size(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	leaves, err := s.leafHashes(ctx, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.head != nil && s.head.TreeSize >= size {
+		head := *s.head
+		return &head, nil
+	}
+
+	head := keylog.TreeHead{
+		Size:      size,
+		RootHash:  keylog.RootHash(leaves),
+		Timestamp: time.Now().UnixMilli(),
+	}
+	s.head = &reqresp.SignedTreeHead{
+		TreeSize:  head.Size,
+		RootHash:  head.RootHash,
+		Timestamp: head.Timestamp,
+		Signature: keylog.SignTreeHead(s.signingKey, head),
+	}
+	signed := *s.head
+	return &signed, nil
+}
+
+// InclusionProof proves that the user's current public key is in the log of the
+// given size. With treeSize 0 the proof is for the current tree head, which is
+// returned with it.
+func (s *keyLogService) InclusionProof(ctx context.Context, userID string, treeSize uint64) (*reqresp.KeyInclusionProof, error) {
+	entry, err := s.keyLogRepo.GetCurrentEntry(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if entry == nil {
+		return nil, ErrKeyLogEntryNotFound
+	}
+
+	var head *reqresp.SignedTreeHead
+	if treeSize == 0 {
+		if head, err = s.TreeHead(ctx); err != nil {
+			return nil, err
+		}
+		treeSize = head.TreeSize
+	} else if err := s.checkTreeSize(ctx, treeSize); err != nil {
+		return nil, err
+	}
+	if entry.LeafIndex >= treeSize {
+		return nil, ErrInvalidTreeSize
+	}
+
+	leaves, err := s.leafHashes(ctx, treeSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	path, err := keylog.InclusionProof(leaves, entry.LeafIndex)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	return &reqresp.KeyInclusionProof{
+		Entry:     *entry,
+		TreeSize:  treeSize,
+		AuditPath: path,
+		TreeHead:  head,
+	}, nil
+}
+
+// ConsistencyProof proves that the log of size first is a prefix of the log of
+// size second. Second 0 means the current size.
+func (s *keyLogService) ConsistencyProof(ctx context.Context, first, second uint64) (*reqresp.KeyConsistencyProof, error) {
+	if second == 0 {
+		size, err := s.keyLogRepo.Size(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		second = size
+	} else if err := s.checkTreeSize(ctx, second); err != nil {
+		return nil, err
+	}
+	if first == 0 || first > second {
+		return nil, ErrInvalidTreeSize
+	}
+
+	leaves, err := s.leafHashes(ctx, second)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	proof, err := keylog.ConsistencyProof(leaves, first)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	return &reqresp.KeyConsistencyProof{
+		First:  first,
+		Second: second,
+		Proof:  proof,
+	}, nil
+}
+
+// PublicKey returns the key tree heads are signed with
+func (s *keyLogService) PublicKey() reqresp.KeyLogPublicKey {
+	return reqresp.KeyLogPublicKey{PublicKey: s.signingKey.Public().(ed25519.PublicKey)}
+}
+
+// AppendMissing records public keys stored before the log existed
+func (s *keyLogService) AppendMissing(ctx context.Context) (int, error) {
+	return s.keyLogRepo.AppendMissing(ctx)
+}
+
+// checkTreeSize refuses sizes the log hasn't reached
+func (s *keyLogService) checkTreeSize(ctx context.Context, treeSize uint64) error {
+	size, err := s.keyLogRepo.Size(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if treeSize > size {
+		return ErrInvalidTreeSize
+	}
+	return nil
+}
+
+// leafHashes returns the first size leaf hashes, loading the ones not seen yet
+func (s *keyLogService) leafHashes(ctx context.Context, size uint64) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loaded := uint64(len(s.leaves))
+	if loaded < size {
+		more, err := s.keyLogRepo.LeafHashes(ctx, loaded, size)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(more)) != size-loaded {
+			return nil, fmt.Errorf("key log has %d of leaves %d to %d", len(more), loaded, size)
+		}
+		s.leaves = append(s.leaves, more...)
+	}
+	return s.leaves[:size:size], nil
+}
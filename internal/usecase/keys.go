@@ -0,0 +1,154 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"poshta/internal/domain/models"
+	"poshta/internal/repository"
+	"poshta/pkg/reqresp"
+)
+
+var (
+	ErrInvalidPublicKey  = errors.New("public key must be 1 to 4096 characters")
+	ErrInvalidKeyVersion = errors.New("key version must be a positive number")
+	ErrStaleKey          = errors.New("message key is wrapped for an outdated public key")
+	ErrPublicKeyNotFound = &policyError{kind: ErrNotFound, msg: "public key not found"}
+)
+
+const maxPublicKeyLength = 4096
+
+// KeyService manages the public keys users encrypt messages to
+type KeyService interface {
+	RotatePublicKey(ctx context.Context, req reqresp.RotateKeyRequest) (*models.PublicKey, error)
+	GetPublicKey(ctx context.Context, userID string, version int) (*models.PublicKey, error)
+}
+
+type keyService struct {
+	userRepo      repository.UserRepository
+	publicKeyRepo repository.PublicKeyRepository
+	chatRepo      repository.ChatRepository
+	notifier      Notifier
+	policy        *Policy
+}
+
+func NewKeyService(userRepo repository.UserRepository, publicKeyRepo repository.PublicKeyRepository, chatRepo repository.ChatRepository, notifier Notifier, policy *Policy) KeyService {
+	return &keyService{
+		userRepo:      userRepo,
+		publicKeyRepo: publicKeyRepo,
+		chatRepo:      chatRepo,
+		notifier:      notifier,
+		policy:        policy,
+	}
+}
+
+// RotatePublicKey replaces the actor's public key with a new version and sends a
+// "key_changed" event to everyone the actor shares a chat with, except users with
+// a block between them and the actor, and to the actor's other devices. Setting
+// the current key again changes nothing.
+func (s *keyService) RotatePublicKey(ctx context.Context, req reqresp.RotateKeyRequest) (*models.PublicKey, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.PublicKey == "" || len(req.PublicKey) > maxPublicKeyLength {
+		return nil, ErrInvalidPublicKey
+	}
+
+	user, err := s.userRepo.GetByID(ctx, actor.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.PublicKey == req.PublicKey {
+		return s.GetPublicKey(ctx, user.ID, user.PublicKeyVersion)
+	}
+
+	key, err := s.publicKeyRepo.Rotate(ctx, user.ID, req.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if key == nil {
+		return nil, ErrUserNotFound
+	}
+
+	partners, err := s.chatRepo.GetPartnerIDs(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	// между заблокированными пользователями смена ключа не сообщается
+	partners, err = s.policy.withoutBlocked(ctx, user.ID, partners)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	createdAt := key.CreatedAt
+	s.notifier.Notify(append(partners, user.ID), reqresp.WSMessage{
+		Type:       "key_changed",
+		SenderID:   user.ID,
+		KeyVersion: key.Version,
+		CreatedAt:  &createdAt,
+	})
+
+	return key, nil
+}
+
+// GetPublicKey returns a version of the user's public key. Version 0 means the current one.
+func (s *keyService) GetPublicKey(ctx context.Context, userID string, version int) (*models.PublicKey, error) {
+	if version < 0 {
+		return nil, ErrInvalidKeyVersion
+	}
+
+	if version == 0 {
+		user, err := s.userRepo.GetByID(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		if user == nil {
+			return nil, ErrUserNotFound
+		}
+		version = user.PublicKeyVersion
+	}
+
+	key, err := s.publicKeyRepo.Get(ctx, userID, version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if key == nil {
+		return nil, ErrPublicKeyNotFound
+	}
+	return key, nil
+}
+
+// stampKeyVersions records in every account-level key the current public key
+// version of its recipient. A key the client says was wrapped for another
+// version is refused with ErrStaleKey, so the sender fetches the new key first.
+func stampKeyVersions(ctx context.Context, publicKeyRepo repository.PublicKeyRepository, keys []models.MessageKey) error {
+	recipients := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k.DeviceID == "" {
+			recipients = append(recipients, k.RecipientID)
+		}
+	}
+
+	versions, err := publicKeyRepo.CurrentVersions(ctx, recipients)
+	if err != nil {
+		return err
+	}
+
+	for i := range keys {
+		if keys[i].DeviceID != "" {
+			// ключи устройств не связаны с версиями открытого ключа аккаунта
+			keys[i].KeyVersion = 0
+			continue
+		}
+		current := versions[keys[i].RecipientID]
+		if keys[i].KeyVersion != 0 && keys[i].KeyVersion != current {
+			return ErrStaleKey
+		}
+		keys[i].KeyVersion = current
+	}
+	return nil
+}
+
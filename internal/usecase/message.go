@@ -10,68 +10,615 @@ import (
 	"time"
 )
 
+var (
+	ErrInvalidMessageKey  = errors.New("message key recipient is not a chat member")
+	ErrInvalidClientMsgID = errors.New("client_msg_id must be at most 64 characters")
+	ErrInvalidTTL         = errors.New("ttl must not be negative")
+	ErrEditWindowClosed   = &policyError{kind: ErrForbidden, msg: "message can no longer be edited"}
+)
+
+const maxClientMsgIDLength = 64
+
+// replayLimit caps how many messages and events are replayed on a single reconnect
+const replayLimit = 500
+
+// sweepBatchSize is how many expired messages are deleted per transaction
+const sweepBatchSize = 500
+
+// MessageConfig limits what senders can do with their messages
+type MessageConfig struct {
+	EditWindow time.Duration // how long after sending a message can be edited
+}
+
+// MessageUseCase operates on messages on behalf of the user in the context, see Policy.
+// DeleteExpiredMessages is a system operation and needs no user.
 type MessageUseCase interface {
-	SendMessage(ctx context.Context, message reqresp.SendMessageRequest) (int64, error)
-	DeleteMessage(ctx context.Context, messageID int64, requesterID string ) (error)
+	SendMessage(ctx context.Context, message reqresp.SendMessageRequest) (*models.Message, error)
+	EditMessage(ctx context.Context, messageID int64, edit reqresp.EditMessageRequest) (*models.Message, error)
+	DeleteMessage(ctx context.Context, messageID int64) (error)
+	GetMessageHistory(ctx context.Context, messageID int64, deviceID string) (*reqresp.MessageHistory, error)
+	Replay(ctx context.Context, deviceID string, lastMessageID, lastEventID int64) ([]reqresp.WSMessage, error)
+	MarkDelivered(ctx context.Context, chatID string, messageID int64) error
+	MarkRead(ctx context.Context, chatID string, messageID int64) error
+	GetReadPositions(ctx context.Context, chatID string) ([]models.ReadPosition, error)
+	SignalRecipients(ctx context.Context, chatID string) ([]string, error)
+	DeleteExpiredMessages(ctx context.Context) (int, error)
 }
 
 type messageUseCase struct {
 	messageRepo repository.MessageRepository
 	chatRepo    repository.ChatRepository
 	userRepo 	repository.UserRepository
+	receiptRepo repository.ReceiptRepository
+	publicKeyRepo repository.PublicKeyRepository
+	notifier    Notifier
+	policy      *Policy
+	cfg         MessageConfig
 }
 
-func NewMessageUseCase(messageRepo repository.MessageRepository, chatRepo repository.ChatRepository, userRepo repository.UserRepository) MessageUseCase {
+func NewMessageUseCase(messageRepo repository.MessageRepository, chatRepo repository.ChatRepository, userRepo repository.UserRepository, receiptRepo repository.ReceiptRepository, publicKeyRepo repository.PublicKeyRepository, notifier Notifier, policy *Policy, cfg MessageConfig) MessageUseCase {
 	return &messageUseCase {
 		messageRepo: messageRepo,
 		chatRepo:    chatRepo,
 		userRepo: 	 userRepo,	
+		receiptRepo: receiptRepo,
+		publicKeyRepo: publicKeyRepo,
+		notifier:    notifier,
+		policy:      policy,
+		cfg:         cfg,
 	}
 }
 
-func (s *messageUseCase) SendMessage(ctx context.Context, message reqresp.SendMessageRequest) (int64, error) {
-	
+// SendMessage stores the message with its per-recipient keys and returns it with Keys populated.
+// A retry with an already used client_msg_id returns the stored message without delivering it again.
+// The sender is always the actor, SenderID in the request is ignored. Attachments have to be
+// complete uploads of the sender in the same chat that no other message references.
+func (s *messageUseCase) SendMessage(ctx context.Context, message reqresp.SendMessageRequest) (*models.Message, error) {
+	if len(message.ClientMsgID) > maxClientMsgIDLength {
+		return nil, ErrInvalidClientMsgID
+	}
+	if message.TTL < 0 {
+		return nil, ErrInvalidTTL
+	}
+	if len(message.AttachmentIDs) > maxAttachmentsPerMessage {
+		return nil, ErrTooManyAttachments
+	}
 
-	// Check if chat exists
-	chat, err := s.chatRepo.GetByID(ctx, message.ChatID)
+	user, chat, _, err := s.policy.ChatMember(ctx, message.ChatID)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	if chat == nil {
-		return 0, errors.New("chat not found")
+	if err := s.policy.requireVerified(user, s.policy.restrictions.SendMessage); err != nil {
+		return nil, err
 	}
-	// Create message
+	message.SenderID = user.ID
 
-	// get username from user_id
-	user, err := s.userRepo.GetByID(ctx, message.SenderID)
+	members, err := s.chatRepo.GetMembers(ctx, chat.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := buildMessageKeys(chat, members, message)
+	if err != nil {
+		return nil, err
+	}
 
+	if err := stampKeyVersions(ctx, s.publicKeyRepo, keys); err != nil {
+		return nil, err
+	}
+
+	withheld, err := s.withheld(ctx, user, chat)
+	if err != nil {
+		return nil, err
+	}
+	if withheld {
+		// отправитель не должен узнать о блокировке: сообщение сохраняется, но видно только ему
+		keys = keysFor(keys, user.ID)
+		members = []models.ChatMember{{ChatID: chat.ID, UserID: user.ID}}
+	}
+
+	// Create message
+	now := time.Now().UTC()
 	messageModel := models.Message{
 		ChatID:   message.ChatID,
 		SenderID: message.SenderID,
 		SenderName: user.Username,
 		Content:  message.Content,
 		EncryptedKey: message.EncryptedKey,
-		CreatedAt: time.Now().UTC(),
+		ClientMsgID: message.ClientMsgID,
+		CreatedAt: now,
+		Withheld: withheld,
+		AttachmentIDs: message.AttachmentIDs,
+	}
+
+	// собственный TTL сообщения важнее таймера чата
+	ttl := chat.MessageTTL
+	if message.TTL > 0 {
+		ttl = message.TTL
+	}
+	if ttl > 0 {
+		expiredAt := now.Add(time.Duration(ttl) * time.Second)
+		messageModel.ExpiredAt = &expiredAt
+	}
+	messageID, err := s.messageRepo.Create(ctx, &messageModel, keys)
+	duplicate := errors.Is(err, repository.ErrDuplicateMessage)
+	if errors.Is(err, repository.ErrAttachmentUnavailable) {
+		return nil, ErrAttachmentUnavailable
+	}
+	if err != nil && !duplicate {
+		return nil, err
+	}
+
+	messageModel.ID = messageID
+	for i := range keys {
+		keys[i].MessageID = messageID
+	}
+	messageModel.Keys = keys
+
+	// повтор уже доставленного сообщения только подтверждается отправителю
+	if duplicate {
+		return &messageModel, nil
+	}
+
+	// каждый участник чата, включая отправителя, получает только свои ключи
+	for _, member := range members {
+		s.notifier.Notify([]string{member.UserID}, messageFrame(&messageModel, member.UserID))
+	}
+
+	return &messageModel, nil
+}
+
+
+// EditMessage replaces the content and wrapped keys of a message within
+// EditWindow of sending it. Only its sender can do it, and keys have to be given
+// for the members of the chat as it is now. The chat members get a
+// "message_edited" frame with the new content and their own keys.
+func (u *messageUseCase) EditMessage(ctx context.Context, messageID int64, edit reqresp.EditMessageRequest) (*models.Message, error) {
+	actor, msg, err := u.policy.MessageSender(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.policy.requireVerified(actor, u.policy.restrictions.SendMessage); err != nil {
+		return nil, err
 	}
-	messageID, err := s.messageRepo.Create(ctx, &messageModel)
+	if time.Since(msg.CreatedAt) > u.cfg.EditWindow {
+		return nil, ErrEditWindowClosed
+	}
+
+	chat, err := u.chatRepo.GetByID(ctx, msg.ChatID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	members, err := u.chatRepo.GetMembers(ctx, msg.ChatID)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	// покинувший чат не может править то, что в нём написал
+	if !hasMember(members, actor.ID) {
+		return nil, ErrNotChatMember
 	}
 
-	return messageID, nil
+	keys, err := buildMessageKeys(chat, members, reqresp.SendMessageRequest{
+		SenderID:           actor.ID,
+		EncryptedKey:       edit.EncryptedKey,
+		EncryptedKeySender: edit.EncryptedKeySender,
+		Keys:               edit.Keys,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := stampKeyVersions(ctx, u.publicKeyRepo, keys); err != nil {
+		return nil, err
+	}
+	if msg.Withheld {
+		// правка скрытого сообщения так же остаётся видна только отправителю
+		keys = keysFor(keys, actor.ID)
+		members = []models.ChatMember{{ChatID: msg.ChatID, UserID: actor.ID}}
+	}
+
+	editedAt := time.Now().UTC()
+	event, err := u.messageRepo.Edit(ctx, messageID, edit.Content, edit.EncryptedKey, keys, editedAt)
+	if errors.Is(err, repository.ErrMessageGone) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	msg.Content = edit.Content
+	msg.EncryptedKey = edit.EncryptedKey
+	msg.EditedAt = &editedAt
+	for i := range keys {
+		keys[i].MessageID = messageID
+	}
+	msg.Keys = keys
+
+	for _, member := range members {
+		u.notifier.Notify([]string{member.UserID}, editedFrame(msg, event, member.UserID))
+	}
+
+	return msg, nil
 }
 
+// GetMessageHistory returns a message the actor can see as it is now, with the
+// key for deviceID, followed by the versions its edits replaced
+func (u *messageUseCase) GetMessageHistory(ctx context.Context, messageID int64, deviceID string) (*reqresp.MessageHistory, error) {
+	actor, _, err := u.policy.MessageViewer(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
 
-func (u *messageUseCase) DeleteMessage(ctx context.Context, messageID int64, requesterID string) error {
+	message, err := u.messageRepo.GetForUser(ctx, messageID, actor.ID, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if message == nil {
+		return nil, ErrMessageNotFound
+	}
+
+	revisions, err := u.messageRepo.GetRevisions(ctx, messageID, actor.ID, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	return &reqresp.MessageHistory{Message: *message, Revisions: revisions}, nil
+}
+
+// DeleteMessage deletes a message for everyone. Only its sender can do it.
+func (u *messageUseCase) DeleteMessage(ctx context.Context, messageID int64) error {
+	_, msg, err := u.policy.MessageSender(ctx, messageID)
+	if err != nil {
+		return err
+	}
 
-	msg, err := u.messageRepo.GetByID(ctx, messageID)
+	event, err := u.messageRepo.Delete(ctx, messageID)
 	if err != nil {
-		return fmt.Errorf("message not found: %w", err)
+		return err
 	}
+	if msg.Withheld {
+		u.notifier.Notify([]string{msg.SenderID}, eventFrame(event))
+		return nil
+	}
+
+	members, err := u.chatRepo.GetMembers(ctx, event.ChatID)
+	if err != nil {
+		return err
+	}
+	u.notifier.Notify(memberIDs(members), eventFrame(event))
+
+	return nil
+}
 
-	if msg.SenderID != requesterID {
-		return fmt.Errorf("unauthorized")
+// Replay returns the frames a reconnecting client missed: messages newer than
+// lastMessageID and message events newer than lastEventID from all of the actor's
+// chats, followed by a "synced" frame. Edits are replayed with the current content
+// of the message. HasMore is set when the client should fetch
+// the rest of the history over REST.
+func (u *messageUseCase) Replay(ctx context.Context, deviceID string, lastMessageID, lastEventID int64) ([]reqresp.WSMessage, error) {
+	actor, err := u.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userID := actor.ID
+
+	messages, err := u.messageRepo.GetSince(ctx, userID, deviceID, lastMessageID, replayLimit)
+	if err != nil {
+		return nil, err
 	}
 
-	return u.messageRepo.Delete(ctx, messageID)
-}
\ No newline at end of file
+	events, err := u.messageRepo.GetEventsSince(ctx, userID, lastEventID, replayLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([]reqresp.WSMessage, 0, len(messages)+len(events)+1)
+	for i := range messages {
+		frames = append(frames, messageFrame(&messages[i], userID))
+	}
+	for i := range events {
+		if events[i].Type != models.MessageEventEdited {
+			frames = append(frames, eventFrame(&events[i]))
+			continue
+		}
+		// правка приходит с новым содержимым; удалённые и скрытые сообщения пропускаются
+		message, err := u.messageRepo.GetForUser(ctx, events[i].MessageID, userID, deviceID)
+		if err != nil {
+			return nil, err
+		}
+		if message != nil {
+			frames = append(frames, editedFrame(message, &events[i], userID))
+		}
+	}
+
+	synced := reqresp.WSMessage{
+		Type:    "synced",
+		ID:      lastMessageID,
+		EventID: lastEventID,
+		HasMore: len(messages) == replayLimit || len(events) == replayLimit,
+	}
+	if len(messages) > 0 {
+		synced.ID = messages[len(messages)-1].ID
+	}
+	if len(events) > 0 {
+		synced.EventID = events[len(events)-1].ID
+	}
+
+	return append(frames, synced), nil
+}
+
+// buildMessageKeys collects the wrapped keys of a message. Explicit per-recipient keys
+// take precedence; in direct chats encrypted_key and encrypted_key_sender are accepted
+// as the keys for the other participant and for the sender.
+func buildMessageKeys(chat *models.Chat, members []models.ChatMember, req reqresp.SendMessageRequest) ([]models.MessageKey, error) {
+	isMember := make(map[string]bool, len(members))
+	for _, m := range members {
+		isMember[m.UserID] = true
+	}
+
+	keys := make([]models.MessageKey, 0, len(req.Keys)+2)
+	seen := make(map[string]bool)
+	add := func(recipientID, deviceID, encryptedKey string, keyVersion int) error {
+		if encryptedKey == "" {
+			return nil
+		}
+		if !isMember[recipientID] {
+			return ErrInvalidMessageKey
+		}
+		id := recipientID + "/" + deviceID
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+		keys = append(keys, models.MessageKey{
+			RecipientID:  recipientID,
+			DeviceID:     deviceID,
+			EncryptedKey: encryptedKey,
+			KeyVersion:   keyVersion,
+		})
+		return nil
+	}
+
+	for _, k := range req.Keys {
+		if err := add(k.RecipientID, k.DeviceID, k.EncryptedKey, k.KeyVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	if chat.Type == models.ChatTypeDirect {
+		recipientID := chat.User1ID
+		if recipientID == req.SenderID {
+			recipientID = chat.User2ID
+		}
+		if err := add(recipientID, "", req.EncryptedKey, 0); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(req.SenderID, "", req.EncryptedKeySender, 0); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// withheld reports whether a direct message from the actor has to be kept from the
+// other participant because they blocked the actor. Messages to a user the actor
+// blocked are refused with ErrUserBlocked.
+func (s *messageUseCase) withheld(ctx context.Context, actor *models.User, chat *models.Chat) (bool, error) {
+	if chat.Type != models.ChatTypeDirect {
+		return false, nil
+	}
+	otherID := chat.User1ID
+	if otherID == actor.ID {
+		otherID = chat.User2ID
+	}
+
+	err := s.policy.Reachable(ctx, actor, otherID)
+	if errors.Is(err, ErrUserNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// keysFor returns the keys wrapped for the recipient
+func keysFor(keys []models.MessageKey, recipientID string) []models.MessageKey {
+	own := make([]models.MessageKey, 0, len(keys))
+	for _, k := range keys {
+		if k.RecipientID == recipientID {
+			own = append(own, k)
+		}
+	}
+	return own
+}
+
+// MarkDelivered records that the actor received every message of the chat up to messageID
+// and sends a "delivered" receipt to the chat members
+func (u *messageUseCase) MarkDelivered(ctx context.Context, chatID string, messageID int64) error {
+	actor, members, err := u.policy.ChatMessage(ctx, chatID, messageID)
+	if err != nil {
+		return err
+	}
+	userID := actor.ID
+
+	if err := u.receiptRepo.MarkDelivered(ctx, chatID, userID, messageID); err != nil {
+		return err
+	}
+
+	u.notifier.Notify(memberIDs(members), reqresp.WSMessage{
+		Type:      "delivered",
+		ChatID:    chatID,
+		SenderID:  userID,
+		MessageID: messageID,
+	})
+	return nil
+}
+
+// MarkRead records that the actor read every message of the chat up to messageID
+// and sends a "read" receipt to the chat members
+func (u *messageUseCase) MarkRead(ctx context.Context, chatID string, messageID int64) error {
+	actor, members, err := u.policy.ChatMessage(ctx, chatID, messageID)
+	if err != nil {
+		return err
+	}
+	userID := actor.ID
+
+	if err := u.receiptRepo.MarkRead(ctx, chatID, userID, messageID); err != nil {
+		return err
+	}
+
+	u.notifier.Notify(memberIDs(members), reqresp.WSMessage{
+		Type:      "read",
+		ChatID:    chatID,
+		SenderID:  userID,
+		MessageID: messageID,
+	})
+	return nil
+}
+
+// GetReadPositions returns how far every member of the chat has received and read it
+func (u *messageUseCase) GetReadPositions(ctx context.Context, chatID string) ([]models.ReadPosition, error) {
+	if _, _, _, err := u.policy.ChatMember(ctx, chatID); err != nil {
+		return nil, err
+	}
+
+	return u.receiptRepo.GetPositions(ctx, chatID)
+}
+
+// SignalRecipients returns who typing and presence frames the actor sends to the
+// chat are forwarded to: every other member, except users with a block between
+// them and the actor
+func (u *messageUseCase) SignalRecipients(ctx context.Context, chatID string) ([]string, error) {
+	actor, _, _, err := u.policy.ChatMember(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := u.chatRepo.GetMembers(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	others := make([]string, 0, len(members))
+	for _, m := range members {
+		if m.UserID != actor.ID {
+			others = append(others, m.UserID)
+		}
+	}
+
+	return u.policy.withoutBlocked(ctx, actor.ID, others)
+}
+
+// DeleteExpiredMessages hard-deletes every expired message and pushes a
+// "message_deleted" event to the members of the affected chats, or only to the
+// sender of a withheld message
+func (u *messageUseCase) DeleteExpiredMessages(ctx context.Context) (int, error) {
+	deleted := 0
+	for {
+		events, err := u.messageRepo.DeleteExpired(ctx, sweepBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		members := make(map[string][]string)
+		for i := range events {
+			if events[i].VisibleTo != "" {
+				// скрытое сообщение исчезает только у отправителя
+				u.notifier.Notify([]string{events[i].VisibleTo}, eventFrame(&events[i]))
+				continue
+			}
+			ids, ok := members[events[i].ChatID]
+			if !ok {
+				chatMembers, err := u.chatRepo.GetMembers(ctx, events[i].ChatID)
+				if err != nil {
+					return deleted, err
+				}
+				ids = memberIDs(chatMembers)
+				members[events[i].ChatID] = ids
+			}
+			u.notifier.Notify(ids, eventFrame(&events[i]))
+		}
+
+		deleted += len(events)
+		if len(events) < sweepBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// messageFrame builds the "message" frame for one recipient. When the message
+// carries all of its keys only the recipient's own keys are included, otherwise
+// EncryptedKey is expected to already hold the recipient's key.
+func messageFrame(message *models.Message, recipientID string) reqresp.WSMessage {
+	createdAt := message.CreatedAt
+	frame := reqresp.WSMessage{
+		Type:         "message",
+		ID:           message.ID,
+		ChatID:       message.ChatID,
+		SenderID:     message.SenderID,
+		Content:      message.Content,
+		EncryptedKey: message.EncryptedKey,
+		KeyVersion:   message.KeyVersion,
+		ClientMsgID:  message.ClientMsgID,
+		CreatedAt:    &createdAt,
+		ExpiredAt:    message.ExpiredAt,
+		EditedAt:     message.EditedAt,
+		AttachmentIDs: message.AttachmentIDs,
+	}
+
+	if message.Keys != nil {
+		frame.EncryptedKey = ""
+		for _, k := range message.Keys {
+			if k.RecipientID != recipientID {
+				continue
+			}
+			if k.DeviceID == "" {
+				frame.EncryptedKey = k.EncryptedKey
+				frame.KeyVersion = k.KeyVersion
+			}
+			frame.Keys = append(frame.Keys, reqresp.MessageKey{
+				RecipientID:  k.RecipientID,
+				DeviceID:     k.DeviceID,
+				EncryptedKey: k.EncryptedKey,
+				KeyVersion:   k.KeyVersion,
+			})
+		}
+	}
+
+	return frame
+}
+
+// editedFrame builds the "message_edited" frame for one recipient, carrying the
+// message as it is now, see messageFrame
+func editedFrame(message *models.Message, event *models.MessageEvent, recipientID string) reqresp.WSMessage {
+	frame := messageFrame(message, recipientID)
+	frame.Type = event.Type
+	frame.MessageID = message.ID
+	frame.EventID = event.ID
+	return frame
+}
+
+func eventFrame(event *models.MessageEvent) reqresp.WSMessage {
+	createdAt := event.CreatedAt
+	return reqresp.WSMessage{
+		Type:      event.Type,
+		ChatID:    event.ChatID,
+		MessageID: event.MessageID,
+		EventID:   event.ID,
+		CreatedAt: &createdAt,
+	}
+}
+
+func hasMember(members []models.ChatMember, userID string) bool {
+	for _, m := range members {
+		if m.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func memberIDs(members []models.ChatMember) []string {
+	ids := make([]string, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.UserID)
+	}
+	return ids
+}
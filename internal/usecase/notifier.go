@@ -0,0 +1,8 @@
+package usecase
+
+import "poshta/pkg/reqresp"
+
+// Notifier delivers real-time events to every live connection of the given users
+type Notifier interface {
+	Notify(userIDs []string, event reqresp.WSMessage)
+}
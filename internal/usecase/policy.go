@@ -0,0 +1,320 @@
+package usecase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"poshta/internal/domain/models"
+	"poshta/internal/middleware"
+	"poshta/internal/repository"
+)
+
+// Authorization errors. Specific errors such as ErrChatNotFound or ErrNotChatMember
+// wrap one of them, so handlers can map them with errors.Is.
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+)
+
+var (
+	ErrUserNotFound     = &policyError{kind: ErrNotFound, msg: "user not found"}
+	ErrChatNotFound     = &policyError{kind: ErrNotFound, msg: "chat not found"}
+	ErrMessageNotFound  = &policyError{kind: ErrNotFound, msg: "message not found"}
+	ErrNotChatMember    = &policyError{kind: ErrForbidden, msg: "user is not a chat member"}
+	ErrNotMessageOwner  = &policyError{kind: ErrForbidden, msg: "only the sender can change the message"}
+	ErrEmailNotVerified = &policyError{kind: ErrForbidden, msg: "email address is not verified"}
+	ErrUserBlocked      = &policyError{kind: ErrForbidden, msg: "you blocked this user"}
+)
+
+type policyError struct {
+	kind error
+	msg  string
+}
+
+func (e *policyError) Error() string { return e.msg }
+func (e *policyError) Unwrap() error { return e.kind }
+
+// UnverifiedRestrictions lists what accounts with an unverified email address may not do
+type UnverifiedRestrictions struct {
+	CreateChat  bool
+	SendMessage bool
+}
+
+// Policy enforces membership and ownership rules for chat and message operations.
+// The acting user is always taken from the request context, never from the payload.
+type Policy struct {
+	chatRepo     repository.ChatRepository
+	messageRepo  repository.MessageRepository
+	userRepo     repository.UserRepository
+	contactRepo  repository.ContactRepository
+	blockRepo    repository.BlockRepository
+	restrictions UnverifiedRestrictions
+}
+
+func NewPolicy(chatRepo repository.ChatRepository, messageRepo repository.MessageRepository, userRepo repository.UserRepository, contactRepo repository.ContactRepository, blockRepo repository.BlockRepository, restrictions UnverifiedRestrictions) *Policy {
+	return &Policy{
+		chatRepo:     chatRepo,
+		messageRepo:  messageRepo,
+		userRepo:     userRepo,
+		contactRepo:  contactRepo,
+		blockRepo:    blockRepo,
+		restrictions: restrictions,
+	}
+}
+
+// Actor returns the authenticated user performing the operation
+func (p *Policy) Actor(ctx context.Context) (*models.User, error) {
+	user, ok := ctx.Value(middleware.UserContextKey).(*models.User)
+	if !ok || user == nil {
+		return nil, ErrUnauthorized
+	}
+	return user, nil
+}
+
+// RequireUser checks that the actor is the given user
+func (p *Policy) RequireUser(ctx context.Context, userID string) (*models.User, error) {
+	actor, err := p.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if actor.ID != userID {
+		return nil, ErrForbidden
+	}
+	return actor, nil
+}
+
+// requireVerified refuses restricted actions to actors with an unverified email address
+func (p *Policy) requireVerified(actor *models.User, restricted bool) error {
+	if restricted && actor.EmailVerifiedAt == nil {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// FindUser returns the user with the given ID or, when userID is empty, the given
+// username. Lookups by username respect the discoverability setting of the user,
+// so users who don't want to be found can't be reached by guessing their name.
+func (p *Policy) FindUser(ctx context.Context, actor *models.User, userID, username string) (*models.User, error) {
+	if userID != "" {
+		user, err := p.userRepo.GetByID(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		if user == nil {
+			return nil, ErrUserNotFound
+		}
+		return user, nil
+	}
+
+	user, err := p.userRepo.GetByUsername(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	discoverable, err := p.discoverable(ctx, actor, user)
+	if err != nil {
+		return nil, err
+	}
+	if !discoverable {
+		// не отличаем скрытых пользователей от несуществующих
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// discoverable reports whether the actor may find the user by username. Users
+// who blocked the actor can't be found.
+func (p *Policy) discoverable(ctx context.Context, actor, user *models.User) (bool, error) {
+	if actor.ID == user.ID {
+		return true, nil
+	}
+	blocked, err := p.blockRepo.IsBlocked(ctx, user.ID, actor.ID)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if blocked {
+		return false, nil
+	}
+	switch user.Discoverability {
+	case models.DiscoverableContacts:
+		isContact, err := p.contactRepo.IsContact(ctx, user.ID, actor.ID)
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		return isContact, nil
+	case models.DiscoverableNobody:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// Reachable checks that the actor may start a conversation with the user. A user
+// who blocked the actor looks like one who doesn't exist, so the block isn't revealed.
+func (p *Policy) Reachable(ctx context.Context, actor *models.User, userID string) error {
+	blocked, err := p.blockRepo.IsBlocked(ctx, actor.ID, userID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if blocked {
+		return ErrUserBlocked
+	}
+
+	blocked, err = p.blockRepo.IsBlocked(ctx, userID, actor.ID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if blocked {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// withoutBlocked returns the users of userIDs without those who blocked the
+// actor or whom the actor blocked. Signals about the actor (typing, presence,
+// key changes) are not passed between them.
+func (p *Policy) withoutBlocked(ctx context.Context, actorID string, userIDs []string) ([]string, error) {
+	blocked, err := p.blockRepo.BlockedAmong(ctx, actorID, userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if len(blocked) == 0 {
+		return userIDs, nil
+	}
+	isBlocked := make(map[string]bool, len(blocked))
+	for _, id := range blocked {
+		isBlocked[id] = true
+	}
+	recipients := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		if !isBlocked[id] {
+			recipients = append(recipients, id)
+		}
+	}
+	return recipients, nil
+}
+
+// ChatMember checks that the actor is a member of the chat
+func (p *Policy) ChatMember(ctx context.Context, chatID string) (*models.User, *models.Chat, *models.ChatMember, error) {
+	actor, err := p.Actor(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	chat, err := p.chatRepo.GetByID(ctx, chatID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if chat == nil {
+		return nil, nil, nil, ErrChatNotFound
+	}
+
+	member, err := p.chatRepo.GetMember(ctx, chatID, actor.ID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if member == nil {
+		return nil, nil, nil, ErrNotChatMember
+	}
+
+	return actor, chat, member, nil
+}
+
+// MessageSender checks that the actor sent the message
+func (p *Policy) MessageSender(ctx context.Context, messageID int64) (*models.User, *models.Message, error) {
+	actor, err := p.Actor(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := p.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrMessageNotFound
+		}
+		return nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	// скрытое от актора сообщение для него не существует
+	if msg.Withheld && msg.SenderID != actor.ID {
+		return nil, nil, ErrMessageNotFound
+	}
+
+	if msg.SenderID != actor.ID {
+		// не раскрываем чужие сообщения тем, кто не состоит в чате
+		member, err := p.chatRepo.GetMember(ctx, msg.ChatID, actor.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		if member == nil {
+			return nil, nil, ErrMessageNotFound
+		}
+		return nil, nil, ErrNotMessageOwner
+	}
+
+	return actor, msg, nil
+}
+
+// MessageViewer checks that the actor can see the message: it belongs to a chat
+// the actor is a member of and isn't withheld from them
+func (p *Policy) MessageViewer(ctx context.Context, messageID int64) (*models.User, *models.Message, error) {
+	actor, err := p.Actor(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := p.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrMessageNotFound
+		}
+		return nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if msg.Withheld && msg.SenderID != actor.ID {
+		return nil, nil, ErrMessageNotFound
+	}
+
+	member, err := p.chatRepo.GetMember(ctx, msg.ChatID, actor.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if member == nil {
+		return nil, nil, ErrMessageNotFound
+	}
+
+	return actor, msg, nil
+}
+
+// ChatMessage checks that the actor is a member of the chat the message belongs to
+// and that the message isn't withheld from them
+func (p *Policy) ChatMessage(ctx context.Context, chatID string, messageID int64) (*models.User, []models.ChatMember, error) {
+	actor, err := p.Actor(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	members, err := p.chatRepo.GetMembers(ctx, chatID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if !hasMember(members, actor.ID) {
+		return nil, nil, ErrNotChatMember
+	}
+
+	msg, err := p.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrMessageNotFound
+		}
+		return nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if msg.ChatID != chatID || msg.Withheld && msg.SenderID != actor.ID {
+		return nil, nil, ErrMessageNotFound
+	}
+
+	return actor, members, nil
+}
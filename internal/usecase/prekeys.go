@@ -0,0 +1,241 @@
+package usecase
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"poshta/internal/domain/models"
+	"poshta/internal/repository"
+	"poshta/pkg/reqresp"
+	"time"
+)
+
+var (
+	ErrInvalidPrekey        = errors.New("keys must be base64-encoded 32 byte public keys")
+	ErrInvalidPrekeyBatch   = errors.New("invalid one-time prekey batch")
+	ErrInvalidSignature     = errors.New("signed prekey signature does not verify with the identity key")
+	ErrIdentityKeyMissing   = errors.New("upload an identity key first")
+	ErrTooManyPrekeys       = errors.New("too many one-time prekeys stored")
+	ErrPrekeyBundleNotFound = &policyError{kind: ErrNotFound, msg: "user has no prekey bundle"}
+)
+
+// PrekeyConfig limits the one-time prekey stock of a user
+type PrekeyConfig struct {
+	LowThreshold int // "prekeys_low" is sent while fewer one-time prekeys are left
+	MaxStock     int // one-time prekeys a user may have stored at once
+	MaxBatch     int // one-time prekeys per upload
+}
+
+// PrekeyService stores the public X3DH key material of the user in the context
+// and hands out prekey bundles. Private keys never reach the server.
+type PrekeyService interface {
+	SetIdentityKey(ctx context.Context, req reqresp.IdentityKeyRequest) (*models.IdentityKey, error)
+	SetSignedPrekey(ctx context.Context, req reqresp.SignedPrekeyRequest) (*models.SignedPrekey, error)
+	AddOneTimePrekeys(ctx context.Context, req reqresp.OneTimePrekeysRequest) (int, error)
+	CountOneTimePrekeys(ctx context.Context) (int, error)
+	GetBundle(ctx context.Context, userID string) (*reqresp.PrekeyBundle, error)
+}
+
+type prekeyService struct {
+	prekeyRepo repository.PrekeyRepository
+	notifier   Notifier
+	policy     *Policy
+	cfg        PrekeyConfig
+}
+
+func NewPrekeyService(prekeyRepo repository.PrekeyRepository, notifier Notifier, policy *Policy, cfg PrekeyConfig) PrekeyService {
+	return &prekeyService{
+		prekeyRepo: prekeyRepo,
+		notifier:   notifier,
+		policy:     policy,
+		cfg:        cfg,
+	}
+}
+
+// SetIdentityKey sets the actor's identity key. A new identity key invalidates
+// the signed and one-time prekeys, which have to be uploaded again.
+func (s *prekeyService) SetIdentityKey(ctx context.Context, req reqresp.IdentityKeyRequest) (*models.IdentityKey, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decodePublicKey(req.IdentityKey); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.prekeyRepo.GetIdentityKey(ctx, actor.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if existing != nil && existing.IdentityKey == req.IdentityKey {
+		return existing, nil
+	}
+
+	key := &models.IdentityKey{
+		UserID:      actor.ID,
+		IdentityKey: req.IdentityKey,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	if err := s.prekeyRepo.SetIdentityKey(ctx, key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return key, nil
+}
+
+// SetSignedPrekey replaces the actor's signed prekey after checking its
+// signature against the identity key
+func (s *prekeyService) SetSignedPrekey(ctx context.Context, req reqresp.SignedPrekeyRequest) (*models.SignedPrekey, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := decodePublicKey(req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, ErrInvalidSignature
+	}
+
+	identity, err := s.prekeyRepo.GetIdentityKey(ctx, actor.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if identity == nil {
+		return nil, ErrIdentityKeyMissing
+	}
+	identityKey, err := decodePublicKey(identity.IdentityKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if !ed25519.Verify(ed25519.PublicKey(identityKey), publicKey, signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	prekey := &models.SignedPrekey{
+		UserID:    actor.ID,
+		KeyID:     req.KeyID,
+		PublicKey: req.PublicKey,
+		Signature: req.Signature,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if err := s.prekeyRepo.SetSignedPrekey(ctx, prekey); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return prekey, nil
+}
+
+// AddOneTimePrekeys stores a batch of the actor's one-time prekeys and returns
+// how many are stored now. Key IDs that are already stored are skipped.
+func (s *prekeyService) AddOneTimePrekeys(ctx context.Context, req reqresp.OneTimePrekeysRequest) (int, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(req.Prekeys) == 0 || len(req.Prekeys) > s.cfg.MaxBatch {
+		return 0, ErrInvalidPrekeyBatch
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	prekeys := make([]models.OneTimePrekey, 0, len(req.Prekeys))
+	seen := make(map[uint32]bool, len(req.Prekeys))
+	for _, p := range req.Prekeys {
+		if seen[p.KeyID] {
+			return 0, ErrInvalidPrekeyBatch
+		}
+		seen[p.KeyID] = true
+		if _, err := decodePublicKey(p.PublicKey); err != nil {
+			return 0, err
+		}
+		prekeys = append(prekeys, models.OneTimePrekey{
+			UserID:    actor.ID,
+			KeyID:     p.KeyID,
+			PublicKey: p.PublicKey,
+			CreatedAt: now,
+		})
+	}
+
+	count, err := s.prekeyRepo.CountOneTimePrekeys(ctx, actor.ID)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if count+len(prekeys) > s.cfg.MaxStock {
+		return 0, ErrTooManyPrekeys
+	}
+
+	if err := s.prekeyRepo.AddOneTimePrekeys(ctx, prekeys); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return s.CountOneTimePrekeys(ctx)
+}
+
+func (s *prekeyService) CountOneTimePrekeys(ctx context.Context) (int, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := s.prekeyRepo.CountOneTimePrekeys(ctx, actor.ID)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return count, nil
+}
+
+// GetBundle returns the prekey bundle of a user and consumes one of their
+// one-time prekeys. Once the stock is empty the bundle has none, and X3DH runs
+// without it. The owner gets "prekeys_low" while the stock is running out.
+func (s *prekeyService) GetBundle(ctx context.Context, userID string) (*reqresp.PrekeyBundle, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if userID != actor.ID {
+		if err := s.policy.Reachable(ctx, actor, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	identity, err := s.prekeyRepo.GetIdentityKey(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	signed, err := s.prekeyRepo.GetSignedPrekey(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if identity == nil || signed == nil {
+		return nil, ErrPrekeyBundleNotFound
+	}
+
+	oneTime, left, err := s.prekeyRepo.TakeOneTimePrekey(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if left < s.cfg.LowThreshold {
+		s.notifier.Notify([]string{userID}, reqresp.WSMessage{
+			Type:  "prekeys_low",
+			Count: left,
+		})
+	}
+
+	return &reqresp.PrekeyBundle{
+		UserID:        userID,
+		IdentityKey:   identity.IdentityKey,
+		SignedPrekey:  *signed,
+		OneTimePrekey: oneTime,
+	}, nil
+}
+
+// decodePublicKey decodes a base64 Ed25519 or X25519 public key
+func decodePublicKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidPrekey
+	}
+	return key, nil
+}
@@ -0,0 +1,269 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"poshta/internal/domain/models"
+	"poshta/internal/middleware"
+	"poshta/internal/repository"
+	"poshta/pkg/logger"
+	"poshta/pkg/reqresp"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidProfile  = errors.New("invalid profile")
+	ErrInvalidPassword = errors.New("password must be at least 8 characters")
+	ErrUsernameTaken   = errors.New("username is already taken")
+	ErrEmailTaken      = errors.New("email address is already in use")
+	ErrWrongPassword   = errors.New("current password is incorrect")
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
+	minPasswordLength    = 8
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// EmailVerifier sends the verification link to a new email address.
+// It is implemented by service.AccountService.
+type EmailVerifier interface {
+	SendVerification(ctx context.Context, user *models.User) error
+}
+
+// SessionDisconnector closes the live connections opened within a session.
+// It is implemented by ws.Hub.
+type SessionDisconnector interface {
+	DisconnectSession(sessionID string)
+}
+
+// PasswordGuard throttles password guessing. It is implemented by service.LoginGuard,
+// so wrong current passwords count as failed logins.
+type PasswordGuard interface {
+	Check(ctx context.Context, username, ip string) error
+	Fail(ctx context.Context, username, ip string) error
+	Succeed(ctx context.Context, username string) error
+}
+
+// ProfileService lets the user in the context manage their own profile and see
+// the public part of the profiles of others
+type ProfileService interface {
+	GetProfile(ctx context.Context) (*models.User, error)
+	GetPublicProfile(ctx context.Context, userID string) (*reqresp.PublicProfile, error)
+	UpdateProfile(ctx context.Context, req reqresp.UpdateProfileRequest) (*models.User, error)
+	ChangePassword(ctx context.Context, req reqresp.ChangePasswordRequest) error
+}
+
+type profileService struct {
+	userRepo     repository.UserRepository
+	blockRepo    repository.BlockRepository
+	sessionRepo  repository.SessionRepository
+	disconnector SessionDisconnector
+	verifier     EmailVerifier
+	guard        PasswordGuard
+	policy       *Policy
+}
+
+func NewProfileService(userRepo repository.UserRepository, blockRepo repository.BlockRepository, sessionRepo repository.SessionRepository, disconnector SessionDisconnector, verifier EmailVerifier, guard PasswordGuard, policy *Policy) ProfileService {
+	return &profileService{
+		userRepo:     userRepo,
+		blockRepo:    blockRepo,
+		sessionRepo:  sessionRepo,
+		disconnector: disconnector,
+		verifier:     verifier,
+		guard:        guard,
+		policy:       policy,
+	}
+}
+
+// GetProfile returns the stored profile of the actor, not the copy in the token
+func (s *profileService) GetProfile(ctx context.Context) (*models.User, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.getUser(ctx, actor.ID)
+}
+
+func (s *profileService) getUser(ctx context.Context, userID string) (*models.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// GetPublicProfile returns the profile without the email address and other private
+// fields. Users who blocked the actor look like they don't exist.
+func (s *profileService) GetPublicProfile(ctx context.Context, userID string) (*reqresp.PublicProfile, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := s.blockRepo.IsBlocked(ctx, userID, actor.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if blocked {
+		return nil, ErrUserNotFound
+	}
+
+	return &reqresp.PublicProfile{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		CreatedAt:   user.CreatedAt,
+	}, nil
+}
+
+// UpdateProfile changes the fields set in the request. A new email address is
+// unverified until the link sent to it is opened.
+func (s *profileService) UpdateProfile(ctx context.Context, req reqresp.UpdateProfileRequest) (*models.User, error) {
+	user, err := s.GetProfile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if !usernamePattern.MatchString(username) {
+			return nil, fmt.Errorf("%w: username must be 3 to 32 letters, digits, '_', '.' or '-'", ErrInvalidProfile)
+		}
+		user.Username = username
+	}
+
+	if req.DisplayName != nil {
+		displayName := strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+			return nil, fmt.Errorf("%w: display name must be at most %d characters", ErrInvalidProfile, maxDisplayNameLength)
+		}
+		user.DisplayName = displayName
+	}
+
+	if req.Bio != nil {
+		bio := strings.TrimSpace(*req.Bio)
+		if utf8.RuneCountInString(bio) > maxBioLength {
+			return nil, fmt.Errorf("%w: bio must be at most %d characters", ErrInvalidProfile, maxBioLength)
+		}
+		user.Bio = bio
+	}
+
+	if req.Discoverability != nil {
+		switch *req.Discoverability {
+		case models.DiscoverableEveryone, models.DiscoverableContacts, models.DiscoverableNobody:
+			user.Discoverability = *req.Discoverability
+		default:
+			return nil, fmt.Errorf("%w: discoverability must be everyone, contacts or nobody", ErrInvalidProfile)
+		}
+	}
+
+	emailChanged := false
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			return nil, fmt.Errorf("%w: invalid email address", ErrInvalidProfile)
+		}
+		if !strings.EqualFold(email, user.Email) {
+			emailChanged = true
+			user.EmailVerifiedAt = nil
+		}
+		user.Email = email
+	}
+
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		if errors.Is(err, repository.ErrDuplicateUser) {
+			return nil, s.duplicateError(ctx, user)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	if emailChanged {
+		if err := s.verifier.SendVerification(ctx, user); err != nil {
+			logger.Error("Failed to send verification email", err, logrus.Fields{"user_id": user.ID})
+		}
+	}
+
+	return s.getUser(ctx, user.ID)
+}
+
+// duplicateError tells which of the unique fields of the user is taken
+func (s *profileService) duplicateError(ctx context.Context, user *models.User) error {
+	other, err := s.userRepo.GetByUsername(ctx, user.Username)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if other != nil && other.ID != user.ID {
+		return ErrUsernameTaken
+	}
+	return ErrEmailTaken
+}
+
+// ChangePassword sets a new password after checking the current one, and ends
+// every session of the actor except the one the request was made from.
+// Wrong passwords count as failed logins.
+func (s *profileService) ChangePassword(ctx context.Context, req reqresp.ChangePasswordRequest) error {
+	user, err := s.GetProfile(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.guard.Check(ctx, user.Username, ""); err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		if err := s.guard.Fail(ctx, user.Username, ""); err != nil {
+			return err
+		}
+		return ErrWrongPassword
+	}
+	if len(req.NewPassword) < minPasswordLength {
+		return ErrInvalidPassword
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if err := s.userRepo.UpdatePassword(ctx, user.ID, string(hashedPassword)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if err := s.guard.Succeed(ctx, user.Username); err != nil {
+		return err
+	}
+
+	sessions, err := s.sessionRepo.GetByUserID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	currentSessionID := middleware.SessionIDFromContext(ctx)
+	for _, session := range sessions {
+		if session.ID == currentSessionID {
+			continue
+		}
+		if err := s.sessionRepo.Revoke(ctx, session.ID); err != nil {
+			return fmt.Errorf("%w: %v", ErrInternal, err)
+		}
+		s.disconnector.DisconnectSession(session.ID)
+	}
+
+	logger.Info("Password changed", logrus.Fields{"user_id": user.ID})
+	return nil
+}
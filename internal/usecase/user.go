@@ -0,0 +1,236 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"poshta/internal/domain/models"
+	"poshta/internal/repository"
+	"poshta/pkg/reqresp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidSearch   = errors.New("search query must be 2 to 32 characters")
+	ErrInvalidContact  = errors.New("a contact needs a user_id or a username of another user")
+	ErrInvalidNickname = errors.New("nickname must be at most 64 characters")
+	ErrAlreadyContact  = errors.New("user is already a contact")
+	ErrInvalidBlock    = errors.New("you can't block yourself")
+	ErrContactNotFound = &policyError{kind: ErrNotFound, msg: "contact not found"}
+)
+
+const (
+	minSearchLength       = 2
+	maxSearchLength       = 32
+	DefaultSearchPageSize = 20
+	MaxSearchPageSize     = 50
+	maxNicknameLength     = 64
+)
+
+// UserService finds users and manages the contacts of the user in the context
+type UserService interface {
+	SearchUsers(ctx context.Context, query string, limit int) ([]reqresp.PublicProfile, error)
+	GetContacts(ctx context.Context) ([]models.Contact, error)
+	AddContact(ctx context.Context, req reqresp.AddContactRequest) (*models.Contact, error)
+	UpdateContact(ctx context.Context, contactID, nickname string) (*models.Contact, error)
+	RemoveContact(ctx context.Context, contactID string) error
+	BlockUser(ctx context.Context, userID string) error
+	UnblockUser(ctx context.Context, userID string) error
+}
+
+type userService struct {
+	userRepo    repository.UserRepository
+	contactRepo repository.ContactRepository
+	blockRepo   repository.BlockRepository
+	policy      *Policy
+}
+
+func NewUserService(userRepo repository.UserRepository, contactRepo repository.ContactRepository, blockRepo repository.BlockRepository, policy *Policy) UserService {
+	return &userService{
+		userRepo:    userRepo,
+		contactRepo: contactRepo,
+		blockRepo:   blockRepo,
+		policy:      policy,
+	}
+}
+
+// SearchUsers finds users by username prefix. Users only show up if their
+// discoverability setting allows the actor to find them and they haven't blocked the actor.
+func (s *userService) SearchUsers(ctx context.Context, query string, limit int) ([]reqresp.PublicProfile, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.TrimSpace(query)
+	if n := utf8.RuneCountInString(query); n < minSearchLength || n > maxSearchLength {
+		return nil, ErrInvalidSearch
+	}
+	if limit <= 0 || limit > MaxSearchPageSize {
+		limit = DefaultSearchPageSize
+	}
+
+	users, err := s.userRepo.Search(ctx, actor.ID, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	profiles := make([]reqresp.PublicProfile, 0, len(users))
+	for _, user := range users {
+		profiles = append(profiles, reqresp.PublicProfile{
+			ID:          user.ID,
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			Bio:         user.Bio,
+			CreatedAt:   user.CreatedAt,
+		})
+	}
+	return profiles, nil
+}
+
+func (s *userService) GetContacts(ctx context.Context) ([]models.Contact, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts, err := s.contactRepo.List(ctx, actor.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return contacts, nil
+}
+
+// AddContact adds a user, given by ID or by username, to the actor's contacts
+func (s *userService) AddContact(ctx context.Context, req reqresp.AddContactRequest) (*models.Contact, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.UserID == "" && req.Username == "" {
+		return nil, ErrInvalidContact
+	}
+	nickname, err := validateNickname(req.Nickname)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.policy.FindUser(ctx, actor, req.UserID, req.Username)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == actor.ID {
+		return nil, ErrInvalidContact
+	}
+
+	err = s.contactRepo.Add(ctx, &models.Contact{
+		OwnerID:   actor.ID,
+		UserID:    user.ID,
+		Nickname:  nickname,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrDuplicateContact) {
+			return nil, ErrAlreadyContact
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	return s.getContact(ctx, actor.ID, user.ID)
+}
+
+// UpdateContact changes the nickname of a contact; an empty nickname removes it
+func (s *userService) UpdateContact(ctx context.Context, contactID, nickname string) (*models.Contact, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nickname, err = validateNickname(nickname)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.getContact(ctx, actor.ID, contactID); err != nil {
+		return nil, err
+	}
+	if err := s.contactRepo.UpdateNickname(ctx, actor.ID, contactID, nickname); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+
+	return s.getContact(ctx, actor.ID, contactID)
+}
+
+func (s *userService) RemoveContact(ctx context.Context, contactID string) error {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.contactRepo.Remove(ctx, actor.ID, contactID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if !removed {
+		return ErrContactNotFound
+	}
+	return nil
+}
+
+// BlockUser blocks a user for the actor. The blocked user can't find the actor or
+// open chats with them, and their direct messages are silently withheld.
+func (s *userService) BlockUser(ctx context.Context, userID string) error {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return err
+	}
+	if userID == actor.ID {
+		return ErrInvalidBlock
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if err := s.blockRepo.Block(ctx, actor.ID, userID); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return nil
+}
+
+// UnblockUser lifts a block. Messages withheld while it was in place stay hidden.
+func (s *userService) UnblockUser(ctx context.Context, userID string) error {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.blockRepo.Unblock(ctx, actor.ID, userID); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return nil
+}
+
+func (s *userService) getContact(ctx context.Context, ownerID, contactID string) (*models.Contact, error) {
+	contact, err := s.contactRepo.Get(ctx, ownerID, contactID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if contact == nil {
+		return nil, ErrContactNotFound
+	}
+	return contact, nil
+}
+
+func validateNickname(nickname string) (string, error) {
+	nickname = strings.TrimSpace(nickname)
+	if utf8.RuneCountInString(nickname) > maxNicknameLength {
+		return "", ErrInvalidNickname
+	}
+	return nickname, nil
+}
@@ -0,0 +1,136 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"poshta/internal/domain/models"
+	"poshta/internal/repository"
+	"poshta/pkg/reqresp"
+	"poshta/pkg/safetynumber"
+	"strings"
+)
+
+var (
+	ErrInvalidVerification  = errors.New("you can't verify your own key")
+	ErrSafetyNumberMismatch = errors.New("safety number does not match the current keys")
+)
+
+// VerificationService lets the user in the context compare safety numbers with
+// other users and mark their public keys as verified
+type VerificationService interface {
+	GetSafetyNumber(ctx context.Context, userID string) (*reqresp.SafetyNumberResponse, error)
+	VerifyKey(ctx context.Context, userID string, req reqresp.VerifyKeyRequest) (*reqresp.SafetyNumberResponse, error)
+	UnverifyKey(ctx context.Context, userID string) error
+}
+
+type verificationService struct {
+	userRepo         repository.UserRepository
+	verificationRepo repository.KeyVerificationRepository
+	policy           *Policy
+}
+
+func NewVerificationService(userRepo repository.UserRepository, verificationRepo repository.KeyVerificationRepository, policy *Policy) VerificationService {
+	return &verificationService{
+		userRepo:         userRepo,
+		verificationRepo: verificationRepo,
+		policy:           policy,
+	}
+}
+
+// GetSafetyNumber returns the safety number of the actor's and the user's current
+// public keys, together with whether the actor verified the user's key
+func (s *verificationService) GetSafetyNumber(ctx context.Context, userID string) (*reqresp.SafetyNumberResponse, error) {
+	actor, user, err := s.pair(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	verification, err := s.verificationRepo.Get(ctx, actor.ID, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return safetyNumberResponse(actor, user, verification), nil
+}
+
+// VerifyKey marks the user's current public key as verified. The client sends the
+// safety number it compared, so a key that changed in the meantime isn't verified.
+func (s *verificationService) VerifyKey(ctx context.Context, userID string, req reqresp.VerifyKeyRequest) (*reqresp.SafetyNumberResponse, error) {
+	actor, user, err := s.pair(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// клиенты могут показывать номер группами по пять цифр
+	number := strings.ReplaceAll(req.SafetyNumber, " ", "")
+	if number != computeSafetyNumber(actor, user) {
+		return nil, ErrSafetyNumberMismatch
+	}
+
+	if err := s.verificationRepo.Verify(ctx, actor.ID, user.ID, user.PublicKeyVersion); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return s.GetSafetyNumber(ctx, userID)
+}
+
+// UnverifyKey withdraws the verification of the user's key
+func (s *verificationService) UnverifyKey(ctx context.Context, userID string) error {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.verificationRepo.Remove(ctx, actor.ID, userID); err != nil {
+		return fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	return nil
+}
+
+// pair loads the actor and the user with their current public keys
+func (s *verificationService) pair(ctx context.Context, userID string) (*models.User, *models.User, error) {
+	actor, err := s.policy.Actor(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if userID == actor.ID {
+		return nil, nil, ErrInvalidVerification
+	}
+	// проверять ключ заблокированного пользователя можно, а заблокировавшего нас — нет
+	if err := s.policy.Reachable(ctx, actor, userID); err != nil && !errors.Is(err, ErrUserBlocked) {
+		return nil, nil, err
+	}
+
+	// ключ в контексте мог устареть, берем текущий
+	self, err := s.userRepo.GetByID(ctx, actor.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInternal, err)
+	}
+	if self == nil || user == nil {
+		return nil, nil, ErrUserNotFound
+	}
+	return self, user, nil
+}
+
+// computeSafetyNumber hashes the public keys as stored: the text the clients
+// registered, which is what they hash too, see package safetynumber
+func computeSafetyNumber(a, b *models.User) string {
+	return safetynumber.Compute(a.ID, []byte(a.PublicKey), b.ID, []byte(b.PublicKey))
+}
+
+func safetyNumberResponse(actor, user *models.User, verification *models.KeyVerification) *reqresp.SafetyNumberResponse {
+	resp := &reqresp.SafetyNumberResponse{
+		UserID:       user.ID,
+		SafetyNumber: computeSafetyNumber(actor, user),
+		KeyVersion:   user.PublicKeyVersion,
+	}
+	if verification != nil {
+		resp.Verified = verification.VerifiedAt != nil && verification.KeyVersion == user.PublicKeyVersion
+		resp.VerifiedAt = verification.VerifiedAt
+		resp.KeyChangedAt = verification.KeyChangedAt
+	}
+	return resp
+}
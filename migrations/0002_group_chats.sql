@@ -0,0 +1,25 @@
+-- Chats can now be direct (two users) or group (any number of members)
+ALTER TABLE chats
+    ADD COLUMN type VARCHAR(16) NOT NULL DEFAULT 'direct',
+    ADD COLUMN title VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN avatar_url VARCHAR(512) NOT NULL DEFAULT '',
+    MODIFY user1_id CHAR(36) NULL,
+    MODIFY user2_id CHAR(36) NULL;
+
+CREATE TABLE IF NOT EXISTS chat_members (
+    chat_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    role VARCHAR(16) NOT NULL DEFAULT 'member',
+    joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_id, user_id),
+    INDEX idx_chat_members_user (user_id),
+    FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Backfill membership of existing direct chats
+INSERT IGNORE INTO chat_members (chat_id, user_id, role, joined_at)
+SELECT id, user1_id, 'member', created_at FROM chats WHERE user1_id IS NOT NULL;
+
+INSERT IGNORE INTO chat_members (chat_id, user_id, role, joined_at)
+SELECT id, user2_id, 'member', created_at FROM chats WHERE user2_id IS NOT NULL;
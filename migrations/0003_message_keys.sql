@@ -0,0 +1,22 @@
+-- Wrapped message keys per recipient (and optionally per recipient device).
+-- An empty device_id means the key is wrapped for the user's account key.
+CREATE TABLE IF NOT EXISTS message_keys (
+    message_id BIGINT NOT NULL,
+    recipient_id CHAR(36) NOT NULL,
+    device_id VARCHAR(64) NOT NULL DEFAULT '',
+    encrypted_key TEXT NOT NULL,
+    PRIMARY KEY (message_id, recipient_id, device_id),
+    INDEX idx_message_keys_recipient (recipient_id),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (recipient_id) REFERENCES users(id)
+);
+
+-- Existing messages only stored the key wrapped for the other participant of a direct chat
+INSERT IGNORE INTO message_keys (message_id, recipient_id, device_id, encrypted_key)
+SELECT m.id,
+       IF(m.sender_id = c.user1_id, c.user2_id, c.user1_id),
+       '',
+       m.encrypted_key
+FROM messages m
+JOIN chats c ON c.id = m.chat_id
+WHERE c.type = 'direct' AND m.encrypted_key <> '';
@@ -0,0 +1,11 @@
+-- Log of changes to already delivered messages (deletions, later edits),
+-- replayed to clients that reconnect after being offline
+CREATE TABLE IF NOT EXISTS message_events (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    chat_id CHAR(36) NOT NULL,
+    message_id BIGINT NOT NULL,
+    type VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_message_events_chat (chat_id, id),
+    FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- Client-generated message IDs make retried sends idempotent per sender
+ALTER TABLE messages
+    ADD COLUMN client_msg_id VARCHAR(64) NULL,
+    ADD UNIQUE INDEX idx_messages_sender_client_msg (sender_id, client_msg_id);
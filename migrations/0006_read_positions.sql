@@ -0,0 +1,12 @@
+-- Per-member delivery and read positions in a chat. A member has received / read
+-- every message of the chat with an ID up to the stored one.
+CREATE TABLE IF NOT EXISTS chat_read_positions (
+    chat_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    last_delivered_message_id BIGINT NOT NULL DEFAULT 0,
+    last_read_message_id BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (chat_id, user_id),
+    FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1,7 @@
+-- Disappearing messages: a per-chat timer (0 = off) and a per-message expiry
+ALTER TABLE chats
+    ADD COLUMN message_ttl INT NOT NULL DEFAULT 0;
+
+ALTER TABLE messages
+    ADD COLUMN expired_at DATETIME NULL,
+    ADD INDEX idx_messages_expired_at (expired_at);
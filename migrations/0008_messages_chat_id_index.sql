@@ -0,0 +1,2 @@
+-- Supports cursor-based pagination of chat history by message ID
+CREATE INDEX idx_messages_chat_id_id ON messages (chat_id, id);
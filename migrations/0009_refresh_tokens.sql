@@ -0,0 +1,17 @@
+-- Issued refresh tokens, stored as SHA-256 hashes. Every login starts a new
+-- family; each refresh rotates the token within its family. Presenting an
+-- already rotated token revokes the whole family.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    family_id CHAR(36) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    rotated_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    UNIQUE INDEX idx_refresh_tokens_hash (token_hash),
+    INDEX idx_refresh_tokens_family (family_id),
+    INDEX idx_refresh_tokens_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,21 @@
+-- A session is one login on one device. Its ID is the family ID of the refresh
+-- tokens issued for it, and access tokens carry it in the "sid" claim.
+CREATE TABLE IF NOT EXISTS sessions (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    device_name VARCHAR(255) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME NOT NULL,
+    revoked_at DATETIME NULL,
+    INDEX idx_sessions_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- logins made before sessions existed
+INSERT INTO sessions (id, user_id, created_at, last_used_at, revoked_at)
+SELECT family_id, user_id, MIN(created_at), MAX(created_at),
+    CASE WHEN COUNT(revoked_at) = COUNT(*) THEN MAX(revoked_at) END
+FROM refresh_tokens
+GROUP BY family_id, user_id;
@@ -0,0 +1,20 @@
+-- Optional TOTP second factor. enabled_at stays NULL until the first code has
+-- been verified. last_used_step keeps a code from being accepted twice.
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id CHAR(36) PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    enabled_at DATETIME NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Single-use recovery codes, stored as SHA-256 hashes
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    user_id CHAR(36) NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at DATETIME NULL,
+    UNIQUE INDEX idx_recovery_codes_user_hash (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
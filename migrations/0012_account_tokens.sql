@@ -0,0 +1,16 @@
+-- Email addresses are unverified until the link from the verification email is opened
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME NULL;
+
+-- Single-use tokens sent by email for verification and password reset. The
+-- token itself is a signed JWT; this table records which ones were used.
+-- Issuing a new token invalidates the unused ones of the same purpose.
+CREATE TABLE IF NOT EXISTS account_tokens (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    purpose VARCHAR(32) NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME NULL,
+    INDEX idx_account_tokens_user_purpose (user_id, purpose),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,21 @@
+-- Failed login counters, one row per username and per IP address
+-- (attempt_key is "username:<name>" or "ip:<address>"). Used when
+-- LOGIN_ATTEMPT_STORE=sql so that every instance sees the same counters.
+CREATE TABLE IF NOT EXISTS login_attempts (
+    attempt_key VARCHAR(300) PRIMARY KEY,
+    failures INT NOT NULL,
+    last_failure_at DATETIME NOT NULL,
+    locked_until DATETIME NULL,
+    INDEX idx_login_attempts_last_failure (last_failure_at),
+    INDEX idx_login_attempts_locked_until (locked_until)
+);
+
+-- Security-relevant events such as account lockouts
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    event_type VARCHAR(64) NOT NULL,
+    subject VARCHAR(300) NOT NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    INDEX idx_audit_events_subject (subject, created_at)
+);
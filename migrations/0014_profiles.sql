@@ -0,0 +1,7 @@
+-- Profile fields shown to other users
+ALTER TABLE users
+    ADD COLUMN display_name VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN bio VARCHAR(500) NOT NULL DEFAULT '';
+
+-- messages.sender_name is no longer read: sender names are joined from users,
+-- so renaming a user renames the sender of all their messages
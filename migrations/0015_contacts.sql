@@ -0,0 +1,15 @@
+-- Who may find a user by username: everyone, only users they added as a
+-- contact, or nobody
+ALTER TABLE users ADD COLUMN discoverability VARCHAR(16) NOT NULL DEFAULT 'everyone';
+
+-- Personal address book. The nickname is only visible to the owner.
+CREATE TABLE IF NOT EXISTS contacts (
+    owner_id CHAR(36) NOT NULL,
+    contact_id CHAR(36) NOT NULL,
+    nickname VARCHAR(64) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (owner_id, contact_id),
+    INDEX idx_contacts_contact (contact_id),
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (contact_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+-- A blocked user can't open chats with the blocker or find them, and their
+-- direct messages never reach the blocker
+CREATE TABLE IF NOT EXISTS user_blocks (
+    blocker_id CHAR(36) NOT NULL,
+    blocked_id CHAR(36) NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (blocker_id, blocked_id),
+    INDEX idx_user_blocks_blocked (blocked_id),
+    FOREIGN KEY (blocker_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (blocked_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Messages a blocked user sent to the blocker. They are only visible to the
+-- sender, so the block isn't revealed.
+ALTER TABLE messages ADD COLUMN withheld BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,20 @@
+-- Every public key a user has had. users.public_key stays the current one;
+-- older versions are kept so messages encrypted to them can still be matched.
+CREATE TABLE IF NOT EXISTS user_public_keys (
+    user_id CHAR(36) NOT NULL,
+    version INT NOT NULL,
+    public_key TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    revoked_at DATETIME NULL,
+    PRIMARY KEY (user_id, version),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+ALTER TABLE users ADD COLUMN public_key_version INT NOT NULL DEFAULT 1;
+
+INSERT IGNORE INTO user_public_keys (user_id, version, public_key, created_at)
+SELECT id, 1, public_key, created_at FROM users;
+
+-- Version of the recipient's public key a message key was wrapped with.
+-- 0 for device keys and for messages sent before key versions existed.
+ALTER TABLE message_keys ADD COLUMN key_version INT NOT NULL DEFAULT 0;
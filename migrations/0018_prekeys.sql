@@ -0,0 +1,29 @@
+-- X3DH key material. The server only stores and hands out public keys.
+
+-- Long-term Ed25519 identity key of a user; it signs the signed prekey
+CREATE TABLE IF NOT EXISTS identity_keys (
+    user_id CHAR(36) PRIMARY KEY,
+    identity_key VARCHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Current medium-term X25519 prekey, signed with the identity key
+CREATE TABLE IF NOT EXISTS signed_prekeys (
+    user_id CHAR(36) PRIMARY KEY,
+    key_id INT UNSIGNED NOT NULL,
+    public_key VARCHAR(64) NOT NULL,
+    signature VARCHAR(128) NOT NULL,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- One-time X25519 prekeys; each is handed out in at most one bundle
+CREATE TABLE IF NOT EXISTS one_time_prekeys (
+    user_id CHAR(36) NOT NULL,
+    key_id INT UNSIGNED NOT NULL,
+    public_key VARCHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, key_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,14 @@
+-- A user confirmed, by comparing safety numbers, that a version of another
+-- user's public key is genuine. Rotating the key clears verified_at and sets
+-- key_changed_at, which flags the chats with that user.
+CREATE TABLE IF NOT EXISTS key_verifications (
+    verifier_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL,
+    key_version INT NOT NULL,
+    verified_at DATETIME NULL,
+    key_changed_at DATETIME NULL,
+    PRIMARY KEY (verifier_id, user_id),
+    INDEX idx_key_verifications_user (user_id),
+    FOREIGN KEY (verifier_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,22 @@
+-- Append-only Merkle log of public keys (see pkg/keylog). Rows are never
+-- updated or deleted; leaf_index is the position of the leaf in the tree.
+-- There is no foreign key to users on purpose: deleting an account must not
+-- remove leaves from the log.
+CREATE TABLE IF NOT EXISTS key_log (
+    leaf_index BIGINT UNSIGNED PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    key_version INT NOT NULL,
+    public_key TEXT NOT NULL,
+    leaf_hash BINARY(32) NOT NULL,
+    logged_at BIGINT NOT NULL, -- unix seconds, part of the leaf data
+    UNIQUE KEY uniq_key_log_user_version (user_id, key_version)
+);
+
+-- Size of the log. Appends lock this single row, so leaf indexes have no gaps
+-- even when a transaction that appended is rolled back.
+CREATE TABLE IF NOT EXISTS key_log_size (
+    id TINYINT PRIMARY KEY,
+    size BIGINT UNSIGNED NOT NULL
+);
+
+INSERT IGNORE INTO key_log_size (id, size) VALUES (1, 0);
@@ -0,0 +1,29 @@
+-- Encrypted attachments. The server only stores ciphertext, uploaded in chunks;
+-- each chunk is a blob in the configured storage.
+-- chat_id has no foreign key so deleting a chat leaves the rows behind for the
+-- garbage collector, which also deletes their blobs. Deleting the message makes
+-- an attachment an orphan.
+CREATE TABLE IF NOT EXISTS attachments (
+    id CHAR(36) PRIMARY KEY,
+    chat_id CHAR(36) NOT NULL,
+    uploader_id CHAR(36) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    size BIGINT NOT NULL,
+    received BIGINT NOT NULL DEFAULT 0,
+    chunk_count INT NOT NULL DEFAULT 0,
+    message_id BIGINT NULL,
+    created_at DATETIME NOT NULL,
+    completed_at DATETIME NULL,
+    INDEX idx_attachments_message (message_id),
+    INDEX idx_attachments_orphans (message_id, created_at),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS attachment_chunks (
+    attachment_id CHAR(36) NOT NULL,
+    chunk_index INT NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    PRIMARY KEY (attachment_id, chunk_index),
+    FOREIGN KEY (attachment_id) REFERENCES attachments(id) ON DELETE CASCADE
+);
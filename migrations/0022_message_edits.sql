@@ -0,0 +1,26 @@
+-- Message editing. An edit replaces the content and wrapped keys of a message;
+-- the replaced version is kept as a revision together with its keys, so the
+-- participants can still decrypt the history.
+ALTER TABLE messages ADD COLUMN edited_at DATETIME NULL;
+
+-- created_at is when the revision's content was written: the time the message
+-- was sent or the time of the edit that introduced it
+CREATE TABLE IF NOT EXISTS message_revisions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    message_id BIGINT NOT NULL,
+    content TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    replaced_at DATETIME NOT NULL,
+    INDEX idx_message_revisions_message (message_id, id),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS message_revision_keys (
+    revision_id BIGINT NOT NULL,
+    recipient_id CHAR(36) NOT NULL,
+    device_id VARCHAR(64) NOT NULL DEFAULT '',
+    encrypted_key TEXT NOT NULL,
+    key_version INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (revision_id, recipient_id, device_id),
+    FOREIGN KEY (revision_id) REFERENCES message_revisions(id) ON DELETE CASCADE
+);
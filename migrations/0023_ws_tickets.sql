@@ -0,0 +1,8 @@
+-- Issued WebSocket tickets, keyed by the jti of the ticket. Opening a connection
+-- deletes the row, so every ticket can be used once.
+CREATE TABLE IF NOT EXISTS ws_tickets (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    INDEX idx_ws_tickets_expires_at (expires_at)
+);
@@ -0,0 +1,4 @@
+-- Events of messages withheld from everyone but their sender (because the
+-- recipient blocked the sender) are replayed only to that sender. The message
+-- itself may be gone by then, so the event keeps who may see it.
+ALTER TABLE message_events ADD COLUMN visible_to CHAR(36) NOT NULL DEFAULT '';
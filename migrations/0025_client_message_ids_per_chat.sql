@@ -0,0 +1,5 @@
+-- client_msg_id is unique per sender and chat: a retried send is recognised only
+-- in the chat it was sent to
+ALTER TABLE messages
+    DROP INDEX idx_messages_sender_client_msg,
+    ADD UNIQUE INDEX idx_messages_sender_chat_client_msg (sender_id, chat_id, client_msg_id);
@@ -0,0 +1,18 @@
+// Package httputil holds helpers shared by HTTP handlers and middleware.
+package httputil
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP returns the address the request came from. It is the peer address of
+// the connection: X-Forwarded-For and similar headers are set by the client as
+// much as by a proxy, so they are not trusted.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
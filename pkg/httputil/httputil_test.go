@@ -0,0 +1,30 @@
+package httputil
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"[2001:db8::1]:443", "", "2001:db8::1"},
+		{"192.0.2.1", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "198.51.100.7", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := ClientIP(r); got != tt.want {
+			t.Errorf("ClientIP(%q, X-Forwarded-For %q) = %q, want %q", tt.remoteAddr, tt.forwarded, got, tt.want)
+		}
+	}
+}
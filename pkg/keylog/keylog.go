@@ -0,0 +1,276 @@
+// Package keylog is the append-only Merkle log of users' public keys. Every key
+// the server registers or rotates becomes a leaf, so a key handed out to one
+// client but hidden from its owner shows up in the log. The tree and its proofs
+// follow Certificate Transparency (RFC 6962):
+//
+//	leaf hash = SHA-256(0x00 || leaf data)
+//	node hash = SHA-256(0x01 || left || right)
+//
+// The leaf data of an entry is
+//
+//	version (1 byte) || timestamp (8 bytes, unix seconds)
+//	|| user ID length (2 bytes) || user ID
+//	|| key version (4 bytes) || public key length (4 bytes) || public key
+//
+// with all numbers big endian. Tree heads are signed with Ed25519 over
+//
+//	version (1 byte) || 1 (1 byte) || timestamp (8 bytes, unix milliseconds)
+//	|| tree size (8 bytes) || root hash (32 bytes)
+//
+// Clients and auditors only need VerifyTreeHead, VerifyInclusion and
+// VerifyConsistency; RootHash and the proof functions are what the server
+// builds its answers with.
+package keylog
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	Version = 0
+
+	// HashSize is the size of leaf, node and root hashes
+	HashSize = sha256.Size
+
+	signatureTypeTreeHash = 1
+)
+
+var (
+	ErrInvalidProof     = errors.New("keylog: invalid proof")
+	ErrInvalidSignature = errors.New("keylog: invalid tree head signature")
+)
+
+// Entry is a public key recorded in the log
+type Entry struct {
+	UserID     string
+	KeyVersion uint32
+	PublicKey  string
+	Timestamp  int64 // unix seconds
+}
+
+// LeafData returns the encoding of the entry that is hashed into the tree
+func (e Entry) LeafData() []byte {
+	data := make([]byte, 0, 1+8+2+len(e.UserID)+4+4+len(e.PublicKey))
+	data = append(data, Version)
+	data = binary.BigEndian.AppendUint64(data, uint64(e.Timestamp))
+	data = binary.BigEndian.AppendUint16(data, uint16(len(e.UserID)))
+	data = append(data, e.UserID...)
+	data = binary.BigEndian.AppendUint32(data, e.KeyVersion)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(e.PublicKey)))
+	data = append(data, e.PublicKey...)
+	return data
+}
+
+// LeafHash returns the hash of the entry's leaf
+func (e Entry) LeafHash() []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(e.LeafData())
+	return h.Sum(nil)
+}
+
+// TreeHead is the size and root hash of the log at a point in time
+type TreeHead struct {
+	Size      uint64
+	RootHash  []byte
+	Timestamp int64 // unix milliseconds
+}
+
+// SigningData returns the bytes the tree head signature covers
+func (h TreeHead) SigningData() []byte {
+	data := make([]byte, 0, 2+8+8+len(h.RootHash))
+	data = append(data, Version, signatureTypeTreeHash)
+	data = binary.BigEndian.AppendUint64(data, uint64(h.Timestamp))
+	data = binary.BigEndian.AppendUint64(data, h.Size)
+	data = append(data, h.RootHash...)
+	return data
+}
+
+// SignTreeHead signs the tree head with the log's key
+func SignTreeHead(key ed25519.PrivateKey, head TreeHead) []byte {
+	return ed25519.Sign(key, head.SigningData())
+}
+
+// VerifyTreeHead checks that the tree head was signed with the log's key
+func VerifyTreeHead(key ed25519.PublicKey, head TreeHead, signature []byte) error {
+	if len(key) != ed25519.PublicKeySize || len(head.RootHash) != HashSize {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(key, head.SigningData(), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// RootHash returns the root of the tree over the given leaf hashes
+func RootHash(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		empty := sha256.Sum256(nil)
+		return empty[:]
+	}
+	return subtreeHash(leaves)
+}
+
+// InclusionProof returns the audit path of leaf index in the tree over leaves
+func InclusionProof(leaves [][]byte, index uint64) ([][]byte, error) {
+	if index >= uint64(len(leaves)) {
+		return nil, ErrInvalidProof
+	}
+	return inclusionPath(leaves, index), nil
+}
+
+// ConsistencyProof proves that the tree over the first size leaves is a prefix
+// of the tree over all leaves
+func ConsistencyProof(leaves [][]byte, size uint64) ([][]byte, error) {
+	if size == 0 || size > uint64(len(leaves)) {
+		return nil, ErrInvalidProof
+	}
+	if size == uint64(len(leaves)) {
+		return [][]byte{}, nil
+	}
+	return subproof(leaves, size, true), nil
+}
+
+// VerifyInclusion checks that leafHash is leaf index of the tree with the given
+// size and root
+func VerifyInclusion(leafHash []byte, index, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return ErrInvalidProof
+	}
+
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			if fn&1 == 0 {
+				for fn&1 == 0 && fn != 0 {
+					fn >>= 1
+					sn >>= 1
+				}
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(r, root) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree with size1 and root1 is a prefix of
+// the tree with size2 and root2
+func VerifyConsistency(size1, size2 uint64, root1, root2 []byte, proof [][]byte) error {
+	switch {
+	case size1 == 0 || size1 > size2:
+		return ErrInvalidProof
+	case size1 == size2:
+		if len(proof) != 0 || !bytes.Equal(root1, root2) {
+			return ErrInvalidProof
+		}
+		return nil
+	case len(proof) == 0:
+		return ErrInvalidProof
+	}
+
+	// для полного поддерева его корень не входит в доказательство
+	if size1&(size1-1) == 0 {
+		proof = append([][]byte{root1}, proof...)
+	}
+
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return ErrInvalidProof
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			if fn&1 == 0 {
+				for fn&1 == 0 && fn != 0 {
+					fn >>= 1
+					sn >>= 1
+				}
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, root1) || !bytes.Equal(sr, root2) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// split returns the largest power of two smaller than n
+func split(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func subtreeHash(leaves [][]byte) []byte {
+	n := uint64(len(leaves))
+	if n == 1 {
+		return leaves[0]
+	}
+	k := split(n)
+	return nodeHash(subtreeHash(leaves[:k]), subtreeHash(leaves[k:]))
+}
+
+func inclusionPath(leaves [][]byte, index uint64) [][]byte {
+	n := uint64(len(leaves))
+	if n == 1 {
+		return [][]byte{}
+	}
+	k := split(n)
+	if index < k {
+		return append(inclusionPath(leaves[:k], index), subtreeHash(leaves[k:]))
+	}
+	return append(inclusionPath(leaves[k:], index-k), subtreeHash(leaves[:k]))
+}
+
+func subproof(leaves [][]byte, m uint64, complete bool) [][]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return [][]byte{}
+		}
+		return [][]byte{subtreeHash(leaves)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(subproof(leaves[:k], m, complete), subtreeHash(leaves[k:]))
+	}
+	return append(subproof(leaves[k:], m-k, false), subtreeHash(leaves[:k]))
+}
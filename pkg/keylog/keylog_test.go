@@ -0,0 +1,227 @@
+package keylog
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// rfc6962Leaves are the leaf inputs of the RFC 6962 reference test vectors
+// used by Certificate Transparency implementations
+var rfc6962Leaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+func leafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func rfc6962LeafHashes(t *testing.T) [][]byte {
+	t.Helper()
+	hashes := make([][]byte, len(rfc6962Leaves))
+	for i, leaf := range rfc6962Leaves {
+		data, err := hex.DecodeString(leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[i] = leafHash(data)
+	}
+	return hashes
+}
+
+// testLeaves returns n distinct leaf hashes
+func testLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		leaves[i] = leafHash([]byte{byte(i), byte(i >> 8)})
+	}
+	return leaves
+}
+
+func TestRootHash(t *testing.T) {
+	tests := []struct {
+		size int
+		root string
+	}{
+		{0, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{1, "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d"},
+		{2, "fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125"},
+		{3, "aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77"},
+		{4, "d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7"},
+		{5, "4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4"},
+		{6, "76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef"},
+		{7, "ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c"},
+		{8, "5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328"},
+	}
+
+	leaves := rfc6962LeafHashes(t)
+	for _, tt := range tests {
+		got := hex.EncodeToString(RootHash(leaves[:tt.size]))
+		if got != tt.root {
+			t.Errorf("RootHash(size %d) = %s, want %s", tt.size, got, tt.root)
+		}
+	}
+}
+
+func TestInclusionProofs(t *testing.T) {
+	const maxSize = 40
+	leaves := testLeaves(maxSize)
+
+	for size := uint64(1); size <= maxSize; size++ {
+		root := RootHash(leaves[:size])
+		for index := uint64(0); index < size; index++ {
+			proof, err := InclusionProof(leaves[:size], index)
+			if err != nil {
+				t.Fatalf("InclusionProof(size %d, index %d): %v", size, index, err)
+			}
+			if err := VerifyInclusion(leaves[index], index, size, proof, root); err != nil {
+				t.Fatalf("VerifyInclusion(size %d, index %d): %v", size, index, err)
+			}
+
+			// чужой лист, другой индекс и испорченный путь не проходят проверку
+			if err := VerifyInclusion(leafHash([]byte("other")), index, size, proof, root); err == nil {
+				t.Fatalf("VerifyInclusion(size %d, index %d) accepted a different leaf", size, index)
+			}
+			if size > 1 {
+				if err := VerifyInclusion(leaves[index], (index+1)%size, size, proof, root); err == nil {
+					t.Fatalf("VerifyInclusion(size %d, index %d) accepted a wrong index", size, index)
+				}
+			}
+			for i := range proof {
+				tampered := cloneProof(proof)
+				tampered[i][0] ^= 0x01
+				if err := VerifyInclusion(leaves[index], index, size, tampered, root); err == nil {
+					t.Fatalf("VerifyInclusion(size %d, index %d) accepted a proof tampered at %d", size, index, i)
+				}
+			}
+			if len(proof) > 0 {
+				if err := VerifyInclusion(leaves[index], index, size, proof[:len(proof)-1], root); err == nil {
+					t.Fatalf("VerifyInclusion(size %d, index %d) accepted a truncated proof", size, index)
+				}
+			}
+			if err := VerifyInclusion(leaves[index], index, size, append(cloneProof(proof), root), root); err == nil {
+				t.Fatalf("VerifyInclusion(size %d, index %d) accepted an extended proof", size, index)
+			}
+		}
+	}
+}
+
+func TestConsistencyProofs(t *testing.T) {
+	const maxSize = 40
+	leaves := testLeaves(maxSize)
+
+	for size2 := uint64(1); size2 <= maxSize; size2++ {
+		root2 := RootHash(leaves[:size2])
+		for size1 := uint64(1); size1 <= size2; size1++ {
+			root1 := RootHash(leaves[:size1])
+			proof, err := ConsistencyProof(leaves[:size2], size1)
+			if err != nil {
+				t.Fatalf("ConsistencyProof(%d, %d): %v", size1, size2, err)
+			}
+			if err := VerifyConsistency(size1, size2, root1, root2, proof); err != nil {
+				t.Fatalf("VerifyConsistency(%d, %d): %v", size1, size2, err)
+			}
+
+			for i := range proof {
+				tampered := cloneProof(proof)
+				tampered[i][0] ^= 0x01
+				if err := VerifyConsistency(size1, size2, root1, root2, tampered); err == nil {
+					t.Fatalf("VerifyConsistency(%d, %d) accepted a proof tampered at %d", size1, size2, i)
+				}
+			}
+			if len(proof) > 0 {
+				if err := VerifyConsistency(size1, size2, root1, root2, proof[:len(proof)-1]); err == nil {
+					t.Fatalf("VerifyConsistency(%d, %d) accepted a truncated proof", size1, size2)
+				}
+			}
+			if size1 < size2 {
+				// ветвь, расходящаяся с новым деревом, должна быть отвергнута
+				forked := RootHash(append(cloneProof(leaves[:size1-1]), leafHash([]byte("fork"))))
+				if err := VerifyConsistency(size1, size2, forked, root2, proof); err == nil {
+					t.Fatalf("VerifyConsistency(%d, %d) accepted a forked old root", size1, size2)
+				}
+			}
+		}
+	}
+}
+
+func TestProofBounds(t *testing.T) {
+	leaves := testLeaves(5)
+
+	if _, err := InclusionProof(leaves, 5); err != ErrInvalidProof {
+		t.Errorf("InclusionProof past the end: got %v, want ErrInvalidProof", err)
+	}
+	if _, err := ConsistencyProof(leaves, 0); err != ErrInvalidProof {
+		t.Errorf("ConsistencyProof from size 0: got %v, want ErrInvalidProof", err)
+	}
+	if _, err := ConsistencyProof(leaves, 6); err != ErrInvalidProof {
+		t.Errorf("ConsistencyProof past the end: got %v, want ErrInvalidProof", err)
+	}
+	if err := VerifyInclusion(leaves[0], 5, 5, nil, RootHash(leaves)); err != ErrInvalidProof {
+		t.Errorf("VerifyInclusion past the end: got %v, want ErrInvalidProof", err)
+	}
+	if err := VerifyConsistency(3, 2, RootHash(leaves[:3]), RootHash(leaves[:2]), nil); err != ErrInvalidProof {
+		t.Errorf("VerifyConsistency shrinking: got %v, want ErrInvalidProof", err)
+	}
+}
+
+func TestTreeHeadSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := TreeHead{Size: 8, RootHash: RootHash(rfc6962LeafHashes(t)), Timestamp: 1700000000000}
+	signature := SignTreeHead(private, head)
+
+	if err := VerifyTreeHead(public, head, signature); err != nil {
+		t.Fatalf("VerifyTreeHead: %v", err)
+	}
+
+	other := head
+	other.Size = 7
+	if err := VerifyTreeHead(public, other, signature); err != ErrInvalidSignature {
+		t.Errorf("VerifyTreeHead with a changed size: got %v, want ErrInvalidSignature", err)
+	}
+	other = head
+	other.RootHash = bytes.Repeat([]byte{0}, HashSize)
+	if err := VerifyTreeHead(public, other, signature); err != ErrInvalidSignature {
+		t.Errorf("VerifyTreeHead with a changed root: got %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestEntryLeafHash(t *testing.T) {
+	entry := Entry{UserID: "user", KeyVersion: 2, PublicKey: "key", Timestamp: 1}
+	want := []byte{
+		Version,
+		0, 0, 0, 0, 0, 0, 0, 1,
+		0, 4, 'u', 's', 'e', 'r',
+		0, 0, 0, 2,
+		0, 0, 0, 3, 'k', 'e', 'y',
+	}
+	if !bytes.Equal(entry.LeafData(), want) {
+		t.Fatalf("LeafData = %x, want %x", entry.LeafData(), want)
+	}
+	if !bytes.Equal(entry.LeafHash(), leafHash(want)) {
+		t.Fatalf("LeafHash doesn't hash the leaf data with the 0x00 prefix")
+	}
+}
+
+func cloneProof(proof [][]byte) [][]byte {
+	clone := make([][]byte, len(proof))
+	for i, p := range proof {
+		clone[i] = append([]byte(nil), p...)
+	}
+	return clone
+}
@@ -0,0 +1,73 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"poshta/pkg/logger"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fileMailer writes every message to its own .eml file, for development
+type fileMailer struct {
+	dir  string
+	from *mail.Address
+}
+
+func NewFileMailer(dir, from string) (Mailer, error) {
+	addr, err := parseFrom(from)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &fileMailer{dir: dir, from: addr}, nil
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := compose(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := now.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o600)
+}
+
+// logMailer writes messages to the application log, for development. Message
+// bodies may contain secrets such as reset links.
+type logMailer struct {
+	from *mail.Address
+}
+
+func NewLogMailer(from string) (Mailer, error) {
+	addr, err := parseFrom(from)
+	if err != nil {
+		return nil, err
+	}
+	return &logMailer{from: addr}, nil
+}
+
+func (m *logMailer) Send(ctx context.Context, msg Message) error {
+	if _, err := compose(m.from, msg, time.Now()); err != nil {
+		return err
+	}
+	logger.Info("Email", logrus.Fields{
+		"from":    m.from.String(),
+		"to":      msg.To,
+		"subject": msg.Subject,
+		"body":    msg.Body,
+	})
+	return nil
+}
@@ -0,0 +1,67 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string // no authentication when empty
+	Password string
+	From     string
+}
+
+// smtpMailer sends through an SMTP server, upgrading to TLS with STARTTLS when
+// the server offers it
+type smtpMailer struct {
+	cfg  SMTPConfig
+	from *mail.Address
+}
+
+func NewSMTPMailer(cfg SMTPConfig) (Mailer, error) {
+	from, err := parseFrom(cfg.From)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("smtp host is required")
+	}
+	return &smtpMailer{cfg: cfg, from: from}, nil
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	data, err := compose(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	// net/smtp не принимает контекст, поэтому отправляем в горутине
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, m.from.Address, []string{to.Address}, data)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,16 +1,23 @@
 package reqresp
 
+// AuthResponse carries the issued tokens. When the account has two-factor
+// authentication enabled, the password step returns only MFARequired and MFAToken.
 type AuthResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	TokenType    string `json:"token_type"`
-	ExpiresIn    int64  `json:"expires_in"` // Seconds until token expires
-	UserID	     string  `json:"user_id"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"` // Seconds until token expires
+	UserID	     string  `json:"user_id,omitempty"`
+	MFARequired  bool   `json:"mfa_required,omitempty"`
+	MFAToken     string `json:"mfa_token,omitempty"` // exchanged for tokens at /auth/login/mfa
 }
 
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	DeviceName string `json:"device_name"` // shown in the session list
+	UserAgent  string `json:"-"`           // filled in from the request
+	IP         string `json:"-"`           // filled in from the request
 }
 
 type RegisterRequest struct {
@@ -20,6 +27,67 @@ type RegisterRequest struct {
 	PublicKey string `json:"public_key" binding:"required"`
 }
 
+// MFALoginRequest is the second login step: the mfa_token from the password step
+// together with either a TOTP code or a recovery code
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	UserAgent    string `json:"-"` // filled in from the request
+	IP           string `json:"-"` // filled in from the request
+}
+
+// TOTPCodeRequest confirms an operation with a TOTP code or a recovery code
+type TOTPCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`      // base32, for manual entry
+	OTPAuthURI string `json:"otpauth_uri"` // for QR codes
+}
+
+// RecoveryCodesResponse lists the recovery codes. They are shown only once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
 type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
-}
\ No newline at end of file
+}
+
+type WSTicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int64  `json:"expires_in"` // Seconds until ticket expires
+}
+
+// JWKS is a JSON Web Key Set (RFC 7517) with the public keys tokens are signed with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP curve
+	X   string `json:"x,omitempty"`   // OKP public key
+}
+
+// EmailRequest names the account for a password reset or a new verification email
+type EmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
@@ -3,20 +3,48 @@ package reqresp
 import "poshta/internal/domain/models"
 
 type CreateChatRequest struct {
-	User1ID string `json:"user1_id"`
-	User2ID string `json:"user2_id"`
+	User1ID  string `json:"user1_id"`
+	User2ID  string `json:"user2_id"`
+	Username string `json:"username"` // the other user, instead of user2_id
+}
+
+type CreateGroupChatRequest struct {
+	Title     string   `json:"title"`
+	AvatarURL string   `json:"avatar_url"`
+	MemberIDs []string `json:"member_ids"`
+}
+
+type AddChatMemberRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type UpdateChatMemberRoleRequest struct {
+	Role string `json:"role"` // "admin" или "member"
+}
+
+type ChatSettingsRequest struct {
+	MessageTTL int `json:"message_ttl"` // таймер исчезающих сообщений в секундах, 0 — выключен
 }
 
 type GetChatResponse struct {
-	ChatID    string `json: "chat_id"`
-	UserID    string `j son: "user_id"`
-	Username  string `json: "username"`
-	PublicKey string `json: "public_key`
+	ChatID    string `json:"chat_id"`
+	Type      string `json:"type"`
+	Title     string `json:"title,omitempty"`      // только для групп
+	AvatarURL string `json:"avatar_url,omitempty"` // только для групп
+	UserID    string `json:"user_id,omitempty"`    // только для личных чатов
+	Username  string `json:"username,omitempty"`   // только для личных чатов
+	PublicKey string `json:"public_key,omitempty"` // только для личных чатов
+	PublicKeyVersion int `json:"public_key_version,omitempty"` // только для личных чатов
+	KeyVerified bool `json:"key_verified,omitempty"` // ключ собеседника проверен по safety number
+	KeyChanged  bool `json:"key_changed,omitempty"`  // ключ собеседника сменился после проверки
 }
 
 type Chat struct {
 	ChatID   string    `json:"chat_id"`
+	Type     string    `json:"type"`
+	Title    string    `json:"title,omitempty"`
 	Username string    `json:"username"`
 	Messages []models.Message `json:"messages"`
+	NextCursor int64 `json:"next_cursor,omitempty"` // передать как after, чтобы получить более новые
+	PrevCursor int64 `json:"prev_cursor,omitempty"` // передать как before, чтобы получить более старые
 }
-
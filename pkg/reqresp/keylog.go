@@ -0,0 +1,31 @@
+package reqresp
+
+import "poshta/internal/domain/models"
+
+// SignedTreeHead is the size and root of the key log signed with the log's
+// Ed25519 key, see pkg/keylog. Hashes and signatures are base64.
+type SignedTreeHead struct {
+	TreeSize  uint64 `json:"tree_size"`
+	RootHash  []byte `json:"root_hash"`
+	Timestamp int64  `json:"timestamp"` // unix milliseconds
+	Signature []byte `json:"signature"`
+}
+
+// KeyInclusionProof proves that a user's current public key is in the key log
+type KeyInclusionProof struct {
+	Entry     models.KeyLogEntry `json:"entry"`
+	TreeSize  uint64             `json:"tree_size"`
+	AuditPath [][]byte           `json:"audit_path"`
+	TreeHead  *SignedTreeHead    `json:"tree_head,omitempty"` // только если tree_size не был задан
+}
+
+// KeyConsistencyProof proves that the log of size First is a prefix of the log of size Second
+type KeyConsistencyProof struct {
+	First  uint64   `json:"first"`
+	Second uint64   `json:"second"`
+	Proof  [][]byte `json:"proof"`
+}
+
+type KeyLogPublicKey struct {
+	PublicKey []byte `json:"public_key"` // base64 Ed25519 public key tree heads are signed with
+}
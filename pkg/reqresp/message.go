@@ -1,12 +1,48 @@
 package reqresp
 
+import (
+	"poshta/internal/domain/models"
+	"time"
+)
+
 type SendMessageRequest struct {
 	ChatID   string  `json:"chat_id"`
 	SenderID string  `json:"sender_id"`
 	SenderName string `json:"sender_name"`
 	Content  string `json:"content"`
+	EncryptedKey string `json:"encrypted_key"`               // ключ для собеседника в личном чате
+	EncryptedKeySender string `json:"encrypted_key_sender"` // ключ для отправителя
+	Keys     []MessageKey `json:"keys,omitempty"`           // ключи для каждого получателя/устройства
+	ClientMsgID string `json:"client_msg_id,omitempty"`      // для идемпотентной повторной отправки
+	TTL      int    `json:"ttl,omitempty"`                    // время жизни в секундах, по умолчанию таймер чата
+	AttachmentIDs []string `json:"attachment_ids,omitempty"`  // загруженные вложения, см. CreateAttachmentRequest
+}
+
+// EditMessageRequest replaces the content of a message together with all of its
+// wrapped keys, which are given the same way as in SendMessageRequest
+type EditMessageRequest struct {
+	Content      string `json:"content"`
+	EncryptedKey string `json:"encrypted_key"`               // ключ для собеседника в личном чате
+	EncryptedKeySender string `json:"encrypted_key_sender"` // ключ для отправителя
+	Keys         []MessageKey `json:"keys,omitempty"`       // ключи для каждого получателя/устройства
+}
+
+// MessageHistory is a message as it is now followed by the versions edits
+// replaced, oldest first
+type MessageHistory struct {
+	Message   models.Message           `json:"message"`
+	Revisions []models.MessageRevision `json:"revisions"`
+}
+
+type MessageKey struct {
+	RecipientID  string `json:"recipient_id"`
+	DeviceID     string `json:"device_id,omitempty"`
 	EncryptedKey string `json:"encrypted_key"`
-	EncryptedKeySender string `json:"encrypted_key_sender"`
+	KeyVersion   int    `json:"key_version,omitempty"` // версия открытого ключа получателя; 0 — текущая
+}
+
+type MarkReadRequest struct {
+	MessageID int64 `json:"message_id"` // все сообщения чата до этого ID включительно
 }
 
 type ErrorResponse struct {
@@ -14,9 +50,32 @@ type ErrorResponse struct {
 }
 
 type WSMessage struct {
-	Type         string `json:"type"`          // "typing" или "message"
-	ChatID       string `json:"chat_id"`
-	SenderID     string `json:"sender_id"`
+	Type         string `json:"type"`          // "typing", "message", "edit", "message_edited", "message_deleted", "delivered", "read", "synced", "ack", "error", "key_changed", "prekeys_low"
+	ID           int64  `json:"id,omitempty"`  // ID сообщения, присвоенный сервером
+	ClientMsgID  string `json:"client_msg_id,omitempty"` // ID сообщения, сгенерированный клиентом
+	ChatID       string `json:"chat_id,omitempty"`
+	SenderID     string `json:"sender_id,omitempty"`
 	Content      string `json:"content,omitempty"`  // только для "message"
 	EncryptedKey string `json:"encrypted_key,omitempty"` // только для "message"
+	EncryptedKeySender string `json:"encrypted_key_sender,omitempty"` // только для "message"
+	Keys         []MessageKey `json:"keys,omitempty"` // только для "message"
+	AttachmentIDs []string   `json:"attachment_ids,omitempty"` // только для "message"
+	KeyVersion   int    `json:"key_version,omitempty"` // версия ключа для EncryptedKey или новая версия в "key_changed"
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	ExpiredAt    *time.Time `json:"expired_at,omitempty"`
+	EditedAt     *time.Time `json:"edited_at,omitempty"`  // для "message" и "message_edited" после правки
+	TTL          int    `json:"ttl,omitempty"`        // только для "message" от клиента
+	MessageID    int64  `json:"message_id,omitempty"` // для событий над существующим сообщением
+	EventID      int64  `json:"event_id,omitempty"`   // курсор событий для переподключения
+	HasMore      bool   `json:"has_more,omitempty"`   // только для "synced"
+	Count        int    `json:"count,omitempty"`      // только для "prekeys_low": сколько одноразовых ключей осталось
+	Code         string `json:"code,omitempty"`       // только для "error"
+	Error        string `json:"error,omitempty"`      // только для "error"
+}
+
+// CreateAttachmentRequest starts an upload. Size is the size of the encrypted
+// file; name and type of the file belong in the encrypted message.
+type CreateAttachmentRequest struct {
+	Kind string `json:"kind"` // "file", "image" или "voice"
+	Size int64  `json:"size"`
 }
@@ -0,0 +1,34 @@
+package reqresp
+
+import "poshta/internal/domain/models"
+
+type IdentityKeyRequest struct {
+	IdentityKey string `json:"identity_key"` // base64 Ed25519 public key
+}
+
+type SignedPrekeyRequest struct {
+	KeyID     uint32 `json:"key_id"`
+	PublicKey string `json:"public_key"` // base64 X25519 public key
+	Signature string `json:"signature"`  // base64 подпись public_key ключом identity_key
+}
+
+type OneTimePrekey struct {
+	KeyID     uint32 `json:"key_id"`
+	PublicKey string `json:"public_key"` // base64 X25519 public key
+}
+
+type OneTimePrekeysRequest struct {
+	Prekeys []OneTimePrekey `json:"prekeys"`
+}
+
+type PrekeyCountResponse struct {
+	Count int `json:"count"`
+}
+
+// PrekeyBundle is everything needed to start an X3DH session with a user
+type PrekeyBundle struct {
+	UserID        string                `json:"user_id"`
+	IdentityKey   string                `json:"identity_key"`
+	SignedPrekey  models.SignedPrekey   `json:"signed_prekey"`
+	OneTimePrekey *models.OneTimePrekey `json:"one_time_prekey,omitempty"` // нет, если запас закончился
+}
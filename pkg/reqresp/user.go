@@ -0,0 +1,55 @@
+package reqresp
+
+import "time"
+
+// UpdateProfileRequest changes the fields that are set and leaves the others as they are
+type UpdateProfileRequest struct {
+	Username    *string `json:"username,omitempty"`
+	DisplayName *string `json:"display_name,omitempty"`
+	Bio         *string `json:"bio,omitempty"`
+	Email       *string `json:"email,omitempty"` // has to be verified again
+	// who may find the user by username: everyone, contacts or nobody
+	Discoverability *string `json:"discoverability,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+}
+
+// PublicProfile is what any user may see about another user
+type PublicProfile struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AddContactRequest names the user by ID or by username
+type AddContactRequest struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Nickname string `json:"nickname"`
+}
+
+type UpdateContactRequest struct {
+	Nickname string `json:"nickname"`
+}
+
+type RotateKeyRequest struct {
+	PublicKey string `json:"public_key"`
+}
+
+type SafetyNumberResponse struct {
+	UserID       string     `json:"user_id"`
+	SafetyNumber string     `json:"safety_number"` // 60 цифр, одинаковый у обоих пользователей
+	KeyVersion   int        `json:"key_version"`   // текущая версия открытого ключа пользователя
+	Verified     bool       `json:"verified"`
+	VerifiedAt   *time.Time `json:"verified_at,omitempty"`
+	KeyChangedAt *time.Time `json:"key_changed_at,omitempty"` // ключ сменился после проверки
+}
+
+type VerifyKeyRequest struct {
+	SafetyNumber string `json:"safety_number"`
+}
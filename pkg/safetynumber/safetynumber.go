@@ -0,0 +1,75 @@
+// Package safetynumber computes the safety numbers two users compare to make
+// sure they see each other's real public keys. The algorithm follows Signal's
+// numeric fingerprints, so every client can reproduce it:
+//
+//	hash = version (2 bytes, big endian) || public key || user ID
+//	repeat Iterations times: hash = SHA-512(hash || public key)
+//
+// The first 30 bytes of the final hash are split into six 5 byte big endian
+// numbers, each taken modulo 100000 and printed as five digits. The safety
+// number of a pair is the two 30 digit fingerprints, the smaller one first.
+//
+// The server treats public keys as opaque text, so the public key bytes are the
+// UTF-8 bytes of the public_key string exactly as the client registered it
+// (e.g. the base64 or PEM text, not the key it decodes to), and the user ID
+// bytes are the UTF-8 bytes of the user's ID.
+package safetynumber
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	Version    = 0
+	Iterations = 5200
+
+	// Length is the number of digits of a safety number
+	Length = 60
+)
+
+// Fingerprint returns the 30 digit fingerprint of one user's public key, given
+// as the registered public_key text, see the package doc
+func Fingerprint(userID string, publicKey []byte) string {
+	hash := make([]byte, 0, 2+len(publicKey)+len(userID))
+	hash = binary.BigEndian.AppendUint16(hash, Version)
+	hash = append(hash, publicKey...)
+	hash = append(hash, userID...)
+
+	for i := 0; i < Iterations; i++ {
+		h := sha512.New()
+		h.Write(hash)
+		h.Write(publicKey)
+		hash = h.Sum(nil)
+	}
+
+	var b strings.Builder
+	for i := 0; i < 30; i += 5 {
+		chunk := uint64(hash[i])<<32 | uint64(hash[i+1])<<24 | uint64(hash[i+2])<<16 |
+			uint64(hash[i+3])<<8 | uint64(hash[i+4])
+		fmt.Fprintf(&b, "%05d", chunk%100000)
+	}
+	return b.String()
+}
+
+// Compute returns the safety number of two users. It is the same whichever
+// user it is computed for.
+func Compute(userA string, publicKeyA []byte, userB string, publicKeyB []byte) string {
+	a := Fingerprint(userA, publicKeyA)
+	b := Fingerprint(userB, publicKeyB)
+	if a <= b {
+		return a + b
+	}
+	return b + a
+}
+
+// Format splits a safety number into groups of five digits for display
+func Format(number string) string {
+	groups := make([]string, 0, len(number)/5)
+	for i := 0; i+5 <= len(number); i += 5 {
+		groups = append(groups, number[i:i+5])
+	}
+	return strings.Join(groups, " ")
+}
@@ -0,0 +1,56 @@
+package safetynumber
+
+import "testing"
+
+const (
+	aliceID  = "alice-id"
+	aliceKey = "MCowBQYDK2VwAyEAalicepublickeyalicepublickeyalice="
+	bobID    = "bob-id"
+	bobKey   = "MCowBQYDK2VwAyEAbobpublickeybobpublickeybobpublick="
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		userID    string
+		publicKey string
+		want      string
+	}{
+		{aliceID, aliceKey, "266830871491829129953942178899"},
+		{bobID, bobKey, "381510493175000597163563252983"},
+	}
+
+	for _, tt := range tests {
+		if got := Fingerprint(tt.userID, []byte(tt.publicKey)); got != tt.want {
+			t.Errorf("Fingerprint(%s) = %s, want %s", tt.userID, got, tt.want)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	const want = "266830871491829129953942178899381510493175000597163563252983"
+
+	ab := Compute(aliceID, []byte(aliceKey), bobID, []byte(bobKey))
+	ba := Compute(bobID, []byte(bobKey), aliceID, []byte(aliceKey))
+	if ab != want {
+		t.Errorf("Compute(alice, bob) = %s, want %s", ab, want)
+	}
+	if ba != ab {
+		t.Errorf("Compute(bob, alice) = %s, want the same as Compute(alice, bob) %s", ba, ab)
+	}
+	if len(ab) != Length {
+		t.Errorf("len(Compute) = %d, want %d", len(ab), Length)
+	}
+
+	// другой ключ того же пользователя даёт другой номер
+	if changed := Compute(aliceID, []byte(aliceKey+"x"), bobID, []byte(bobKey)); changed == ab {
+		t.Errorf("Compute didn't change with the public key")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	got := Format("266830871491829129953942178899")
+	want := "26683 08714 91829 12995 39421 78899"
+	if got != want {
+		t.Errorf("Format = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestLocalStorage(t *testing.T) (*LocalStorage, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "blobs")
+	s, err := NewLocalStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func readBlob(t *testing.T, s Storage, key string) []byte {
+	t.Helper()
+	blob, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	defer blob.Close()
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLocalStoragePutGetDelete(t *testing.T) {
+	ctx := context.Background()
+	s, dir := newTestLocalStorage(t)
+	const key = "attachments/a/000001"
+
+	if err := s.Put(ctx, key, strings.NewReader("first"), 5); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := readBlob(t, s, key); string(got) != "first" {
+		t.Errorf("Get = %q, want %q", got, "first")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "attachments", "a", "000001")); err != nil {
+		t.Errorf("blob isn't stored below the directory: %v", err)
+	}
+
+	// повторная запись заменяет блоб, лишние байты читателя не сохраняются
+	if err := s.Put(ctx, key, strings.NewReader("second and more"), 6); err != nil {
+		t.Fatalf("Put replacing: %v", err)
+	}
+	if got := readBlob(t, s, key); string(got) != "second" {
+		t.Errorf("Get after replace = %q, want %q", got, "second")
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing blob = %v, want nil", err)
+	}
+}
+
+func TestLocalStorageShortPut(t *testing.T) {
+	ctx := context.Background()
+	s, dir := newTestLocalStorage(t)
+	const key = "attachments/a/000001"
+
+	if err := s.Put(ctx, key, strings.NewReader("abc"), 10); err == nil {
+		t.Fatal("Put accepted fewer bytes than announced")
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after a short Put = %v, want ErrNotFound", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "attachments", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("short Put left %d files behind", len(entries))
+	}
+}
+
+func TestLocalStorageRejectsInvalidKeys(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s, err := NewLocalStorage(filepath.Join(root, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"../secret", filepath.Join(root, "secret"), "/secret"} {
+		if err := s.Put(ctx, key, bytes.NewReader([]byte("x")), 1); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := s.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if err := s.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(root, "secret")); err != nil || string(data) != "secret" {
+		t.Errorf("file outside the storage directory changed: %q, %v", data, err)
+	}
+}
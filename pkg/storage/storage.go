@@ -0,0 +1,42 @@
+// Package storage keeps blobs, such as encrypted attachments, on the local
+// filesystem or in an S3-compatible object store (AWS S3, MinIO, ...).
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Storage stores blobs under slash-separated keys. Keys may only contain
+// letters, digits and "/", "-", "_", "." and must not contain "..".
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any existing blob
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the blob, ErrNotFound if there is none
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob; deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+var (
+	ErrNotFound   = errors.New("storage: blob not found")
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") || strings.Contains(key, "..") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '/', c == '-', c == '_', c == '.':
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
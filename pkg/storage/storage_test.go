@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckKey(t *testing.T) {
+	valid := []string{
+		"blob",
+		"attachments/0b5c/000001-9f86d081884c7d65",
+		"a/b.c/d_e-f",
+	}
+	for _, key := range valid {
+		if err := checkKey(key); err != nil {
+			t.Errorf("checkKey(%q) = %v, want nil", key, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"/etc/passwd",
+		"attachments/",
+		"..",
+		"../secret",
+		"attachments/../../secret",
+		"attachments/..",
+		"a\\b",
+		"a b",
+		"a?b",
+		"a%2Fb",
+		"ключ",
+	}
+	for _, key := range invalid {
+		if err := checkKey(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("checkKey(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed of RFC 6238 Appendix B, "12345678901234567890"
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// Appendix B lists 8 digit codes; 6 digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfc6238Secret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != tt.code {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseSecret(t *testing.T) {
+	upper, err := Code(rfc6238Secret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := Code(" "+strings.ToLower(rfc6238Secret)+" ", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper != lower {
+		t.Errorf("Code with a lowercase secret = %s, want %s", lower, upper)
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		skew   int
+		ok     bool
+	}{
+		{"current step", 0, 1, true},
+		{"previous step", -1, 1, true},
+		{"next step", 1, 1, true},
+		{"two steps behind", -2, 1, false},
+		{"two steps ahead", 2, 1, false},
+		{"previous step without skew", -1, 0, false},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfc6238Secret, current+tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfc6238Secret, code, now, tt.skew)
+		if ok != tt.ok {
+			t.Errorf("%s: Validate = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("%s: matched step %d, want %d", tt.name, step, current+tt.offset)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"} {
+		if _, ok := Validate(rfc6238Secret, code, now, 1); ok {
+			t.Errorf("Validate accepted %q", code)
+		}
+	}
+	if _, ok := Validate(rfc6238Secret, " 287082 ", now, 1); !ok {
+		t.Errorf("Validate rejected a code surrounded by spaces")
+	}
+	if _, ok := Validate("not base32!", "287082", now, 1); ok {
+		t.Errorf("Validate accepted a code for an invalid secret")
+	}
+}